@@ -0,0 +1,161 @@
+// Package auth supplies credentials for protected downloads. Providers look
+// credentials up per URL, from explicit per-host settings, a .netrc file or
+// an external credential helper, and Middleware adds them to requests.
+package auth
+
+import (
+	"GoDownload/clients"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Credentials authenticate requests to a host, either with a bearer Token
+// or, when Token is empty, with Basic authentication.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Header returns the value of the Authorization header for c.
+func (c *Credentials) Header() string {
+	if c.Token != "" {
+		return "Bearer " + c.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+}
+
+// ParseCredentials parses user:password, or bearer:token for a bearer
+// token.
+func ParseCredentials(value string) (*Credentials, error) {
+	user, secret, found := strings.Cut(value, ":")
+	if !found {
+		return nil, fmt.Errorf("expected user:password or bearer:token")
+	}
+	if strings.EqualFold(user, "bearer") {
+		return &Credentials{Token: secret}, nil
+	}
+	return &Credentials{Username: user, Password: secret}, nil
+}
+
+// Provider looks up the credentials for a URL. It returns nil credentials
+// and no error when it has none.
+type Provider interface {
+	Credentials(ctx context.Context, u *url.URL) (*Credentials, error)
+}
+
+// Rejecter is implemented by providers that want to know when the
+// credentials they gave were refused, for example to drop them from a
+// cache.
+type Rejecter interface {
+	Reject(ctx context.Context, u *url.URL, credentials *Credentials)
+}
+
+// Chain asks each provider in turn and returns the first credentials found.
+type Chain []Provider
+
+func (c Chain) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	for _, provider := range c {
+		credentials, err := provider.Credentials(ctx, u)
+		if err != nil || credentials != nil {
+			return credentials, err
+		}
+	}
+	return nil, nil
+}
+
+func (c Chain) Reject(ctx context.Context, u *url.URL, credentials *Credentials) {
+	for _, provider := range c {
+		if rejecter, ok := provider.(Rejecter); ok {
+			rejecter.Reject(ctx, u, credentials)
+		}
+	}
+}
+
+// Hosts holds fixed credentials per host. Keys are host names, optionally
+// with a port to only apply to that port.
+type Hosts map[string]*Credentials
+
+func (h Hosts) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	if credentials, ok := h[net.JoinHostPort(host, port)]; ok {
+		return credentials, nil
+	}
+	return h[host], nil
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "ftp":
+		return "21"
+	case "ftps":
+		return "990"
+	}
+	return "80"
+}
+
+// Middleware returns middleware adding the credentials provider has for
+// each request to its Authorization header. Requests that already carry
+// one, such as those with credentials in their URL, are left alone. When a
+// request is refused with 401 and provider implements Rejecter, it is told,
+// and the request is sent once more if the provider then comes up with
+// different credentials, such as a freshly fetched token.
+func Middleware(provider Provider) clients.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return clients.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Authorization") != "" {
+				return next.RoundTrip(req)
+			}
+
+			credentials, err := provider.Credentials(req.Context(), req.URL)
+			if err != nil {
+				return nil, fmt.Errorf("getting credentials for %s: %w", req.URL.Host, err)
+			}
+			if credentials == nil {
+				return next.RoundTrip(req)
+			}
+			resp, err := next.RoundTrip(authorized(req, credentials))
+			rejecter, ok := provider.(Rejecter)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized || !ok {
+				return resp, err
+			}
+
+			rejecter.Reject(req.Context(), req.URL, credentials)
+			renewed, renewErr := provider.Credentials(req.Context(), req.URL)
+			if renewErr != nil || renewed == nil || renewed.Header() == credentials.Header() || !replayable(req) {
+				return resp, err
+			}
+			resp.Body.Close()
+			if req.Body != nil && req.GetBody != nil {
+				req = req.Clone(req.Context())
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			return next.RoundTrip(authorized(req, renewed))
+		})
+	}
+}
+
+// authorized returns a copy of req sending credentials.
+func authorized(req *http.Request, credentials *Credentials) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", credentials.Header())
+	return req
+}
+
+// replayable reports whether req can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
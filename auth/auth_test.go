@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"GoDownload/clients"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCredentials(t *testing.T) {
+	credentials, err := ParseCredentials("alice:s3cr:et")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "alice", Password: "s3cr:et"}, credentials)
+
+	credentials, err = ParseCredentials("Bearer:abc.def")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer abc.def", credentials.Header())
+
+	_, err = ParseCredentials("no-separator")
+	assert.Error(t, err)
+}
+
+func TestHosts_Credentials(t *testing.T) {
+	alice := &Credentials{Username: "alice"}
+	bob := &Credentials{Username: "bob"}
+	hosts := Hosts{"example.com": alice, "example.com:8443": bob}
+
+	tests := []struct {
+		url  string
+		want *Credentials
+	}{
+		{"https://example.com/file", alice},
+		{"https://EXAMPLE.com:443/file", alice},
+		{"https://example.com:8443/file", bob},
+		{"https://other.com/file", nil},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		got, err := hosts.Credentials(context.Background(), u)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.url)
+	}
+}
+
+func TestChain_FirstMatchWins(t *testing.T) {
+	chain := Chain{
+		Hosts{"a.com": {Username: "explicit"}},
+		Hosts{"a.com": {Username: "netrc"}, "b.com": {Username: "netrc"}},
+	}
+	a, _ := url.Parse("https://a.com/")
+	b, _ := url.Parse("https://b.com/")
+	c, _ := url.Parse("https://c.com/")
+
+	got, _ := chain.Credentials(context.Background(), a)
+	assert.Equal(t, "explicit", got.Username)
+	got, _ = chain.Credentials(context.Background(), b)
+	assert.Equal(t, "netrc", got.Username)
+	got, _ = chain.Credentials(context.Background(), c)
+	assert.Nil(t, got)
+}
+
+// rejectRecorder records rejected credentials.
+type rejectRecorder struct {
+	Hosts
+	rejected []*Credentials
+}
+
+func (r *rejectRecorder) Reject(ctx context.Context, u *url.URL, credentials *Credentials) {
+	r.rejected = append(r.rejected, credentials)
+}
+
+func TestMiddleware(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		switch {
+		case r.Header.Get("Authorization") == "Bearer token":
+			w.Write([]byte("bearer"))
+		case ok && user == "alice" && password == "secret":
+			w.Write([]byte("basic"))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	host := ts.Listener.Addr().String()
+
+	tests := []struct {
+		name     string
+		provider *rejectRecorder
+		status   int
+		rejected int
+	}{
+		{"basic", &rejectRecorder{Hosts: Hosts{host: {Username: "alice", Password: "secret"}}}, http.StatusOK, 0},
+		{"bearer", &rejectRecorder{Hosts: Hosts{host: {Token: "token"}}}, http.StatusOK, 0},
+		{"refused", &rejectRecorder{Hosts: Hosts{host: {Username: "alice", Password: "wrong"}}}, http.StatusUnauthorized, 1},
+		{"none", &rejectRecorder{Hosts: Hosts{}}, http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := clients.NewClientBuilder().Use(Middleware(tt.provider)).Build()
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp, err := client.Get(ts.URL)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Len(t, tt.provider.rejected, tt.rejected)
+		})
+	}
+}
+
+func TestMiddleware_KeepsExplicitAuthorization(t *testing.T) {
+	var got string
+	next := clients.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.SetBasicAuth("url", "user")
+
+	Middleware(Hosts{"example.com": {Token: "token"}})(next).RoundTrip(req)
+	assert.Equal(t, "Basic dXJsOnVzZXI=", got)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Helper gets credentials from an external program speaking the git
+// credential helper protocol. The program is run with the action, get or
+// erase, as its last argument and is sent the request on stdin as
+// key=value lines ending with a blank line:
+//
+//	protocol=https
+//	host=example.com
+//
+// For get it answers with username= and password= lines, or with
+// authtype=Bearer and credential= for a bearer token. Answers are cached
+// per host until the server refuses them, so the program runs once per
+// host rather than once per request.
+type Helper struct {
+	// Command is the program and its arguments.
+	Command []string
+
+	mu    sync.Mutex
+	cache map[string]*Credentials
+}
+
+// NewHelper returns a helper running command, which is split into the
+// program and its arguments at spaces.
+func NewHelper(command string) *Helper {
+	return &Helper{Command: strings.Fields(command)}
+}
+
+func (h *Helper) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	key := u.Scheme + "://" + u.Host
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if credentials, ok := h.cache[key]; ok {
+		return credentials, nil
+	}
+
+	answer, err := h.run(ctx, "get", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var credentials *Credentials
+	switch {
+	case strings.EqualFold(answer["authtype"], "bearer") && answer["credential"] != "":
+		credentials = &Credentials{Token: answer["credential"]}
+	case answer["password"] != "":
+		credentials = &Credentials{Username: answer["username"], Password: answer["password"]}
+	}
+
+	if h.cache == nil {
+		h.cache = map[string]*Credentials{}
+	}
+	h.cache[key] = credentials
+	return credentials, nil
+}
+
+// Reject forgets the cached credentials for the host of u and tells the
+// program to erase them.
+func (h *Helper) Reject(ctx context.Context, u *url.URL, credentials *Credentials) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cache[u.Scheme+"://"+u.Host] != credentials {
+		return
+	}
+	delete(h.cache, u.Scheme+"://"+u.Host)
+	h.run(ctx, "erase", u, credentials)
+}
+
+// run runs the program for action and returns its answer.
+func (h *Helper) run(ctx context.Context, action string, u *url.URL, credentials *Credentials) (map[string]string, error) {
+	if len(h.Command) == 0 {
+		return nil, fmt.Errorf("no credential helper command")
+	}
+	fields := [][2]string{{"protocol", u.Scheme}, {"host", u.Host}}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		fields = append(fields, [2]string{"path", path})
+	}
+	if credentials != nil {
+		if credentials.Token != "" {
+			fields = append(fields, [2]string{"authtype", "Bearer"}, [2]string{"credential", credentials.Token})
+		} else {
+			fields = append(fields, [2]string{"username", credentials.Username}, [2]string{"password", credentials.Password})
+		}
+	}
+	var input bytes.Buffer
+	for _, field := range fields {
+		// A line break would let the URL add lines of its own, such as
+		// another host, as git refuses to since CVE-2020-5260
+		if strings.ContainsAny(field[1], "\n\r\x00") {
+			return nil, fmt.Errorf("credential helper: %s contains a newline or NUL", field[0])
+		}
+		fmt.Fprintf(&input, "%s=%s\n", field[0], field[1])
+	}
+	input.WriteString("\n")
+
+	args := append(append([]string(nil), h.Command[1:]...), action)
+	cmd := exec.CommandContext(ctx, h.Command[0], args...)
+	cmd.Stdin = &input
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s: %w: %s", h.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	answer := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		answer[key] = value
+	}
+	return answer, nil
+}
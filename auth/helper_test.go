@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeHelper writes a credential helper script that logs its action and
+// input to log and answers with answer for get.
+func writeHelper(t *testing.T, answer string) (string, string) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	script := filepath.Join(dir, "helper.sh")
+	body := "#!/bin/sh\necho \"action=$1\" >> " + log + "\ncat >> " + log + "\nif [ \"$1\" = get ]; then\nprintf '" + answer + "'\nfi\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, log
+}
+
+func TestHelper_Basic(t *testing.T) {
+	script, log := writeHelper(t, "username=alice\\npassword=s3cret\\n")
+	helper := NewHelper(script)
+	u, _ := url.Parse("https://files.example.com/releases/app.tar.gz")
+
+	for i := 0; i < 3; i++ {
+		credentials, err := helper.Credentials(context.Background(), u)
+		assert.NoError(t, err)
+		assert.Equal(t, &Credentials{Username: "alice", Password: "s3cret"}, credentials)
+	}
+
+	input, _ := os.ReadFile(log)
+	// Asked once, then cached
+	assert.Equal(t, "action=get\nprotocol=https\nhost=files.example.com\npath=releases/app.tar.gz\n\n", string(input))
+}
+
+func TestHelper_BearerAndReject(t *testing.T) {
+	script, log := writeHelper(t, "authtype=Bearer\\ncredential=tok\\n")
+	helper := NewHelper(script)
+	u, _ := url.Parse("https://api.example.com/blob")
+
+	credentials, err := helper.Credentials(context.Background(), u)
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Token: "tok"}, credentials)
+
+	helper.Reject(context.Background(), u, credentials)
+	helper.Credentials(context.Background(), u)
+
+	input, _ := os.ReadFile(log)
+	assert.Equal(t, 2, strings.Count(string(input), "action=get"))
+	assert.Contains(t, string(input), "action=erase\nprotocol=https\nhost=api.example.com\npath=blob\nauthtype=Bearer\ncredential=tok\n")
+}
+
+func TestHelper_Failure(t *testing.T) {
+	helper := NewHelper(filepath.Join(t.TempDir(), "missing-helper"))
+	_, err := helper.Credentials(context.Background(), &url.URL{Scheme: "https", Host: "example.com"})
+	assert.Error(t, err)
+}
+
+func TestHelper_RejectsLineBreaks(t *testing.T) {
+	script, log := writeHelper(t, "username=alice\\npassword=s3cret\\n")
+	helper := NewHelper(script)
+
+	for _, raw := range []string{
+		"https://evil.example/x%0ahost=github.com",
+		"https://evil.example/x%0d%0ahost=github.com",
+		"https://evil.example/x%00",
+	} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		credentials, err := helper.Credentials(context.Background(), u)
+		assert.Error(t, err, raw)
+		assert.Nil(t, credentials, raw)
+	}
+	// Nor is anything cached for the host
+	assert.Empty(t, helper.cache)
+
+	// The program was never run
+	_, err := os.Stat(log)
+	assert.True(t, os.IsNotExist(err))
+}
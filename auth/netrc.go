@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Netrc holds the credentials of a .netrc file.
+type Netrc struct {
+	machines map[string]*Credentials
+	fallback *Credentials
+}
+
+// DefaultNetrcPath returns the file named by $NETRC, or else ~/.netrc.
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads the .netrc file at path.
+func LoadNetrc(path string) (*Netrc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseNetrc(file)
+}
+
+// ParseNetrc parses the machine, default, login and password entries of a
+// .netrc file. Macro definitions are skipped, and so is account.
+func ParseNetrc(r io.Reader) (*Netrc, error) {
+	netrc := &Netrc{machines: map[string]*Credentials{}}
+	var current *Credentials
+	scanner := bufio.NewScanner(r)
+	inMacro := false
+	var tokens []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends at the first blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "macdef" {
+			inMacro = true
+			continue
+		}
+		tokens = append(tokens, fields...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		value := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			current = &Credentials{}
+			netrc.machines[strings.ToLower(value())] = current
+		case "default":
+			current = &Credentials{}
+			netrc.fallback = current
+		case "login":
+			if login := value(); current != nil {
+				current.Username = login
+			}
+		case "password":
+			if password := value(); current != nil {
+				current.Password = password
+			}
+		case "account":
+			value()
+		}
+	}
+	return netrc, nil
+}
+
+// Credentials returns the entry for the host of u, or the default entry.
+func (n *Netrc) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	if credentials, ok := n.machines[strings.ToLower(u.Hostname())]; ok {
+		return credentials, nil
+	}
+	return n.fallback, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc, err := ParseNetrc(strings.NewReader(`# Work
+machine files.example.com
+	login alice
+	password s3cret
+
+machine mirror.example.org login bob password hunter2 account ops
+
+macdef init
+cd /pub
+machine evil.example.com login mallory password nope
+
+default login anonymous password guest@
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		host string
+		want *Credentials
+	}{
+		{"files.example.com", &Credentials{Username: "alice", Password: "s3cret"}},
+		{"MIRROR.example.org", &Credentials{Username: "bob", Password: "hunter2"}},
+		{"evil.example.com", &Credentials{Username: "anonymous", Password: "guest@"}},
+		{"other.net", &Credentials{Username: "anonymous", Password: "guest@"}},
+	}
+	for _, tt := range tests {
+		got, err := netrc.Credentials(context.Background(), &url.URL{Scheme: "https", Host: tt.host})
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.host)
+	}
+}
+
+func TestParseNetrc_NoDefault(t *testing.T) {
+	netrc, err := ParseNetrc(strings.NewReader("machine a.com login a password b\n"))
+	assert.NoError(t, err)
+	got, _ := netrc.Credentials(context.Background(), &url.URL{Scheme: "https", Host: "b.com"})
+	assert.Nil(t, got)
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryLeeway is how long before it expires a token is replaced, so
+// that it does not run out while a request is on its way.
+var tokenExpiryLeeway = 30 * time.Second
+
+// ClientCredentials gets bearer tokens from an OAuth2 token endpoint with
+// the client credentials grant (RFC 6749, section 4.4). Tokens are cached
+// until shortly before they expire, or until a server refuses them, and
+// are then fetched again on the next request, so long batches outlive any
+// single token.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Params are extra form values sent to the token endpoint, such as an
+	// audience.
+	Params url.Values
+	// CredentialsInBody sends the client ID and secret as form values
+	// instead of with Basic authentication, for endpoints that need it.
+	CredentialsInBody bool
+	// Hosts lists the hosts the tokens are sent to, in the format of the
+	// Hosts provider keys. Tokens are never sent anywhere else.
+	Hosts []string
+	// Client makes the token requests. It defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu      sync.Mutex
+	token   *Credentials
+	expires time.Time
+	now     func() time.Time
+}
+
+// tokenResponse is the JSON answer of a token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *ClientCredentials) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	if !c.covers(u) {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != nil && (c.expires.IsZero() || c.clock().Before(c.expires)) {
+		return c.token, nil
+	}
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.token, c.expires = token, time.Time{}
+	if expiresIn > 0 {
+		leeway := tokenExpiryLeeway
+		if leeway > expiresIn/2 {
+			leeway = expiresIn / 2
+		}
+		c.expires = c.clock().Add(expiresIn - leeway)
+	}
+	return c.token, nil
+}
+
+// Reject drops the cached token if it is the one refused, so the next
+// request fetches a new one.
+func (c *ClientCredentials) Reject(ctx context.Context, u *url.URL, credentials *Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == credentials {
+		c.token = nil
+	}
+}
+
+// covers reports whether tokens are sent to the host of u.
+func (c *ClientCredentials) covers(u *url.URL) bool {
+	hosts := Hosts{}
+	for _, host := range c.Hosts {
+		hosts[strings.ToLower(host)] = &Credentials{}
+	}
+	credentials, _ := hosts.Credentials(context.Background(), u)
+	return credentials != nil
+}
+
+func (c *ClientCredentials) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// fetch asks the token endpoint for a new token.
+func (c *ClientCredentials) fetch(ctx context.Context) (*Credentials, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for key, values := range c.Params {
+		form[key] = values
+	}
+	if c.CredentialsInBody {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !c.CredentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching OAuth2 token: %w", err)
+	}
+	if err := json.Unmarshal(body, &token); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, 0, fmt.Errorf("fetching OAuth2 token: invalid response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		if token.Error != "" {
+			return nil, 0, fmt.Errorf("fetching OAuth2 token: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+		}
+		return nil, 0, fmt.Errorf("fetching OAuth2 token: %s", resp.Status)
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, 0, fmt.Errorf("fetching OAuth2 token: unsupported token type %q", token.TokenType)
+	}
+	return &Credentials{Token: token.AccessToken}, time.Duration(token.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenServer issues numbered tokens and lets tests revoke them.
+type tokenServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	issued    int
+	valid     map[string]bool
+	expiresIn int64
+}
+
+func newTokenServer(t *testing.T, expiresIn int64) *tokenServer {
+	s := &tokenServer{valid: map[string]bool{}, expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "downloader" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		s.mu.Lock()
+		s.issued++
+		token := fmt.Sprintf("token-%d", s.issued)
+		s.valid[token] = true
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": token, "token_type": "Bearer", "expires_in": s.expiresIn, "scope": r.FormValue("scope"),
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) revokeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.valid = map[string]bool{}
+}
+
+func (s *tokenServer) accepts(header string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := bytes.CutPrefix([]byte(header), []byte("Bearer "))
+	return ok && s.valid[string(token)]
+}
+
+func (s *tokenServer) issuedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func TestClientCredentials_CachesUntilExpiry(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	now := time.Now()
+	source := &ClientCredentials{
+		TokenURL: tokens.URL, ClientID: "downloader", ClientSecret: "s3cret",
+		Scopes: []string{"artifacts:read"}, Hosts: []string{"store.example.com"},
+		now: func() time.Time { return now },
+	}
+	store, _ := url.Parse("https://store.example.com/a.bin")
+
+	first, err := source.Credentials(context.Background(), store)
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", first.Token)
+	again, _ := source.Credentials(context.Background(), store)
+	assert.Same(t, first, again)
+
+	now = now.Add(time.Hour - tokenExpiryLeeway/2)
+	renewed, _ := source.Credentials(context.Background(), store)
+	assert.Equal(t, "token-2", renewed.Token)
+
+	other, _ := url.Parse("https://elsewhere.example.com/a.bin")
+	none, err := source.Credentials(context.Background(), other)
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestClientCredentials_Errors(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	source := &ClientCredentials{TokenURL: tokens.URL, ClientID: "downloader", ClientSecret: "wrong", Hosts: []string{"store.example.com"}}
+	_, err := source.Credentials(context.Background(), &url.URL{Scheme: "https", Host: "store.example.com"})
+	assert.ErrorContains(t, err, "invalid_client")
+}
+
+func TestClientCredentials_RefreshesOn401(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokens.accepts(r.Header.Get("Authorization")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("artifact"))
+	}))
+	defer store.Close()
+
+	source := &ClientCredentials{TokenURL: tokens.URL, ClientID: "downloader", ClientSecret: "s3cret", Hosts: []string{store.Listener.Addr().String()}}
+	client, _ := clients.NewClientBuilder().Use(Middleware(source)).Build()
+
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			tokens.revokeAll()
+		}
+		resp, err := client.Get(store.URL)
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "artifact", string(body))
+	}
+	assert.Equal(t, 2, tokens.issuedCount())
+}
+
+func TestClientCredentials_SegmentedDownloadOutlivesToken(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+
+	tokens := newTokenServer(t, 3600)
+	content := bytes.Repeat([]byte("0123456789"), 1<<20)
+	var requests int
+	var mu sync.Mutex
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokens.accepts(r.Header.Get("Authorization")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		requests++
+		// The token is revoked while the ranges are being fetched
+		if requests == 3 {
+			tokens.revokeAll()
+		}
+		mu.Unlock()
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer store.Close()
+
+	source := &ClientCredentials{TokenURL: tokens.URL, ClientID: "downloader", ClientSecret: "s3cret", Hosts: []string{store.Listener.Addr().String()}}
+	dl := downloader.New(&clients.RealHttpClient{})
+	dl.Middleware = []clients.Middleware{Middleware(source)}
+	dl.Segments = 2
+	dl.SegmentThreshold = 1
+	dir := t.TempDir()
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{store.URL + "/big.bin"}}, dir, 2, ctx)
+
+	got, err := os.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.Equal(t, 2, tokens.issuedCount())
+}
@@ -0,0 +1,148 @@
+// Package checksum verifies downloaded files against expected digests.
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrMismatch is matched by errors.Is when a file does not have the
+// expected digest.
+var ErrMismatch = errors.New("checksum mismatch")
+
+// Names of the supported algorithms, as used in input files.
+const (
+	MD5        = "md5"
+	SHA1       = "sha-1"
+	SHA256     = "sha-256"
+	SHA512     = "sha-512"
+	BLAKE2b256 = "blake2b-256"
+	BLAKE2b512 = "blake2b-512"
+)
+
+// algorithms maps every accepted spelling to its canonical name.
+var algorithms = map[string]string{
+	"md5":         MD5,
+	"sha1":        SHA1,
+	"sha-1":       SHA1,
+	"sha256":      SHA256,
+	"sha-256":     SHA256,
+	"sha512":      SHA512,
+	"sha-512":     SHA512,
+	"blake2b":     BLAKE2b512,
+	"b2":          BLAKE2b512,
+	"blake2b-256": BLAKE2b256,
+	"blake2b-512": BLAKE2b512,
+}
+
+// Checksum is an expected digest.
+type Checksum struct {
+	Algorithm string
+	Digest    []byte
+	// Alternative is the other algorithm a digest of this length may
+	// stand for, when Algorithm was only guessed from the length. A
+	// digest that does not match is then reported as an AmbiguousError.
+	Alternative string
+}
+
+// Parse parses "algorithm=hex", e.g. "sha-256=9f86d0...". Algorithm names
+// are case insensitive and the dash is optional.
+func Parse(value string) (*Checksum, error) {
+	name, digest, ok := strings.Cut(value, "=")
+	if !ok {
+		return nil, fmt.Errorf("checksum must be algorithm=digest, got %q", value)
+	}
+	return New(name, digest)
+}
+
+// New returns the checksum of the given algorithm with a hex encoded digest.
+func New(algorithm string, digest string) (*Checksum, error) {
+	name, ok := algorithms[strings.ToLower(strings.TrimSpace(algorithm))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	sum, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s digest %q", name, digest)
+	}
+	c := &Checksum{Algorithm: name, Digest: sum}
+	if size := c.NewHash().Size(); len(sum) != size {
+		return nil, fmt.Errorf("%s digest must be %d bytes, got %d", name, size, len(sum))
+	}
+	return c, nil
+}
+
+// NewHash returns a hash computing digests of the checksum's algorithm.
+func (c *Checksum) NewHash() hash.Hash {
+	switch c.Algorithm {
+	case MD5:
+		return md5.New()
+	case SHA1:
+		return sha1.New()
+	case SHA512:
+		return sha512.New()
+	case BLAKE2b256:
+		h, _ := blake2b.New256(nil)
+		return h
+	case BLAKE2b512:
+		h, _ := blake2b.New512(nil)
+		return h
+	default:
+		return sha256.New()
+	}
+}
+
+// Verify compares the digest computed by h with the expected one.
+func (c *Checksum) Verify(h hash.Hash) error {
+	if actual := h.Sum(nil); !bytes.Equal(actual, c.Digest) {
+		if c.Alternative != "" {
+			return &AmbiguousError{Algorithm: c.Algorithm, Alternative: c.Alternative, Expected: c.Digest}
+		}
+		return &MismatchError{Algorithm: c.Algorithm, Expected: c.Digest, Actual: actual}
+	}
+	return nil
+}
+
+func (c *Checksum) String() string {
+	return c.Algorithm + "=" + hex.EncodeToString(c.Digest)
+}
+
+// MismatchError reports a file whose digest is not the expected one.
+type MismatchError struct {
+	Algorithm string
+	Expected  []byte
+	Actual    []byte
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %x, got %x", e.Algorithm, e.Expected, e.Actual)
+}
+
+// Is makes errors.Is(err, ErrMismatch) hold.
+func (e *MismatchError) Is(target error) bool {
+	return target == ErrMismatch
+}
+
+// AmbiguousError reports a file that does not match a digest whose
+// algorithm was guessed from its length, which another algorithm shares.
+// The file may well be intact, so it is not a MismatchError.
+type AmbiguousError struct {
+	Algorithm   string
+	Alternative string
+	Expected    []byte
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("file does not match %x as a %s digest, but a digest of %d hex characters may also be %s: name the algorithm in the checksum list",
+		e.Expected, e.Algorithm, 2*len(e.Expected), e.Alternative)
+}
@@ -0,0 +1,69 @@
+package checksum
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const helloSHA256 = "a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e"
+
+func TestChecksum_Algorithms(t *testing.T) {
+	tests := []struct {
+		value     string
+		algorithm string
+	}{
+		{"md5=b10a8db164e0754105b7a99be72e3fe5", MD5},
+		{"sha-1=0a4d55a8d778e5022fab701977c5d840bbc486d0", SHA1},
+		{"SHA256=" + helloSHA256, SHA256},
+		{"sha-512=2c74fd17edafd80e8447b0d46741ee243b7eb74dd2149a0ab1b9246fb30382f27e853d8585719e0e67cbda0daa8f51671064615d645ae27acb15bfb1447f459b", SHA512},
+		{"blake2b=4386a08a265111c9896f56456e2cb61a64239115c4784cf438e36cc851221972da3fb0115f73cd02486254001f878ab1fd126aac69844ef1c1ca152379d0a9bd", BLAKE2b512},
+		{"blake2b-256=1dc01772ee0171f5f614c673e3c7fa1107a8cf727bdf5a6dadb379e93c0d1d00", BLAKE2b256},
+	}
+
+	for _, tt := range tests {
+		sum, err := Parse(tt.value)
+		if !assert.NoError(t, err, tt.value) {
+			continue
+		}
+		assert.Equal(t, tt.algorithm, sum.Algorithm)
+
+		h := sum.NewHash()
+		io.Copy(h, strings.NewReader("Hello World"))
+		assert.NoError(t, sum.Verify(h), tt.value)
+	}
+}
+
+func TestChecksum_Mismatch(t *testing.T) {
+	sum, _ := Parse("sha-256=" + helloSHA256)
+	h := sum.NewHash()
+	io.WriteString(h, "Hello World!")
+
+	err := sum.Verify(h)
+	assert.True(t, errors.Is(err, ErrMismatch))
+	var mismatch *MismatchError
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, SHA256, mismatch.Algorithm)
+	assert.Contains(t, err.Error(), "expected "+helloSHA256)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		helloSHA256,
+		"crc32=deadbeef",
+		"sha-256=not-hex",
+		"sha-256=b10a8db164e0754105b7a99be72e3fe5",
+	} {
+		_, err := Parse(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestChecksum_String(t *testing.T) {
+	sum, _ := Parse("SHA256=" + strings.ToUpper(helloSHA256))
+	assert.Equal(t, "sha-256="+helloSHA256, sum.String())
+}
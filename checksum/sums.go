@@ -0,0 +1,147 @@
+package checksum
+
+import (
+	"GoDownload/clients"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Sums maps file names to their expected checksums, as listed in a
+// SHA256SUMS-style file.
+type Sums map[string]*Checksum
+
+// bsdLine matches the tagged format written by "sha256sum --tag":
+// SHA256 (file.iso) = 9f86d0...
+var bsdLine = regexp.MustCompile(`^([A-Za-z0-9-]+) \((.+)\) = ([0-9A-Fa-f]+)$`)
+
+// AlgorithmFromName guesses the algorithm of a sums file from its name, such
+// as SHA256SUMS or debian.iso.sha512. It returns "" when the name does not
+// tell.
+func AlgorithmFromName(name string) string {
+	name = strings.ToLower(path.Base(name))
+	for _, candidate := range []struct{ prefix, algorithm string }{
+		{"sha512", SHA512},
+		{"sha256", SHA256},
+		{"sha1", SHA1},
+		{"md5", MD5},
+		{"b2", BLAKE2b512},
+		{"blake2b", BLAKE2b512},
+	} {
+		if strings.Contains(name, candidate.prefix) {
+			return candidate.algorithm
+		}
+	}
+	return ""
+}
+
+// algorithmFromSize guesses the algorithm of an untagged digest from its
+// number of hex characters, returning as well the other algorithm it may
+// be, if any. 64 characters are taken to be SHA-256 rather than
+// BLAKE2b-256, and 128 characters SHA-512 rather than BLAKE2b-512.
+func algorithmFromSize(hexDigest string) (algorithm string, alternative string) {
+	switch len(hexDigest) {
+	case 32:
+		return MD5, ""
+	case 40:
+		return SHA1, ""
+	case 64:
+		return SHA256, BLAKE2b256
+	case 128:
+		return SHA512, BLAKE2b512
+	}
+	return "", ""
+}
+
+// ParseSums reads a checksum list in the format written by sha256sum and
+// friends: one "digest  name" line per file, with a "*" before the name for
+// binary mode, or the BSD style "SHA256 (name) = digest". algorithm applies
+// to untagged lines; if empty it is guessed from the length of each digest,
+// see algorithmFromSize.
+// Blank lines and lines starting with # are ignored.
+func ParseSums(r io.Reader, algorithm string) (Sums, error) {
+	sums := Sums{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var name, lineAlgorithm, alternative, digest string
+		if match := bsdLine.FindStringSubmatch(line); match != nil {
+			lineAlgorithm, name, digest = match[1], match[2], match[3]
+		} else {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected digest and file name", lineNo)
+			}
+			digest = fields[0]
+			name = strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+			lineAlgorithm = algorithm
+			if lineAlgorithm == "" {
+				lineAlgorithm, alternative = algorithmFromSize(digest)
+			}
+		}
+
+		sum, err := New(lineAlgorithm, digest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		sum.Alternative = alternative
+		sums[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// FetchSums downloads and parses a checksum list. The algorithm is guessed
+// from the name of the list, falling back to the length of each digest.
+func FetchSums(ctx context.Context, client clients.HttpClient, url string) (Sums, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, clients.NewStatusError(resp))
+	}
+
+	sums, err := ParseSums(resp.Body, AlgorithmFromName(url))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return sums, nil
+}
+
+// Lookup returns the checksum listed for the file name. Entries listed with
+// a directory, such as "./name" or "dir/name", match too unless several of
+// them share the name.
+func (s Sums) Lookup(name string) *Checksum {
+	if sum, ok := s[name]; ok {
+		return sum
+	}
+	var found *Checksum
+	for listed, sum := range s {
+		if path.Base(listed) == name {
+			if found != nil {
+				return nil
+			}
+			found = sum
+		}
+	}
+	return found
+}
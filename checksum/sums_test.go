@@ -0,0 +1,111 @@
+package checksum
+
+import (
+	"GoDownload/clients"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSums(t *testing.T) {
+	input := `# release checksums
+` + helloSHA256 + `  hello.txt
+` + helloSHA256 + ` *images/disk.img
+
+SHA256 (tagged.txt) = ` + helloSHA256 + `
+MD5 (old.txt) = b10a8db164e0754105b7a99be72e3fe5
+`
+	sums, err := ParseSums(strings.NewReader(input), SHA256)
+	assert.NoError(t, err)
+	assert.Len(t, sums, 4)
+	assert.Equal(t, SHA256, sums["hello.txt"].Algorithm)
+	assert.NotNil(t, sums["images/disk.img"])
+	assert.Equal(t, SHA256, sums["tagged.txt"].Algorithm)
+	assert.Equal(t, MD5, sums["old.txt"].Algorithm)
+}
+
+func TestParseSums_GuessesAlgorithm(t *testing.T) {
+	sums, err := ParseSums(strings.NewReader("b10a8db164e0754105b7a99be72e3fe5  a\n"+helloSHA256+"  b\n"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, MD5, sums["a"].Algorithm)
+	assert.Empty(t, sums["a"].Alternative)
+	assert.Equal(t, SHA256, sums["b"].Algorithm)
+	assert.Equal(t, BLAKE2b256, sums["b"].Alternative)
+}
+
+func TestParseSums_AmbiguousLength(t *testing.T) {
+	// An untagged BLAKE2b-256 list is taken for SHA-256
+	blake := "1dc01772ee0171f5f614c673e3c7fa1107a8cf727bdf5a6dadb379e93c0d1d00"
+	sums, err := ParseSums(strings.NewReader(blake+"  hello.txt\n"), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	sum := sums["hello.txt"]
+	h := sum.NewHash()
+	io.WriteString(h, "Hello World")
+
+	err = sum.Verify(h)
+	var ambiguous *AmbiguousError
+	assert.ErrorAs(t, err, &ambiguous)
+	assert.NotErrorIs(t, err, ErrMismatch)
+	assert.ErrorContains(t, err, "64 hex characters may also be blake2b-256")
+
+	// Naming the algorithm settles it
+	sums, _ = ParseSums(strings.NewReader(blake+"  hello.txt\n"), BLAKE2b256)
+	h = sums["hello.txt"].NewHash()
+	io.WriteString(h, "Hello World")
+	assert.NoError(t, sums["hello.txt"].Verify(h))
+}
+
+func TestParseSums_Invalid(t *testing.T) {
+	_, err := ParseSums(strings.NewReader(helloSHA256+"  ok\nnot-a-digest\n"), SHA256)
+	assert.EqualError(t, err, "line 2: expected digest and file name")
+
+	_, err = ParseSums(strings.NewReader("b10a8db164e0754105b7a99be72e3fe5  short\n"), SHA256)
+	assert.Error(t, err)
+}
+
+func TestAlgorithmFromName(t *testing.T) {
+	assert.Equal(t, SHA256, AlgorithmFromName("https://example.com/release/SHA256SUMS"))
+	assert.Equal(t, SHA512, AlgorithmFromName("debian.iso.sha512"))
+	assert.Equal(t, SHA1, AlgorithmFromName("SHA1SUMS"))
+	assert.Equal(t, MD5, AlgorithmFromName("MD5SUMS"))
+	assert.Equal(t, BLAKE2b512, AlgorithmFromName("B2SUMS"))
+	assert.Equal(t, "", AlgorithmFromName("CHECKSUMS"))
+}
+
+func TestSums_Lookup(t *testing.T) {
+	sum, _ := Parse("sha-256=" + helloSHA256)
+	sums := Sums{"exact.txt": sum, "./dotted.txt": sum, "a/dup.txt": sum, "b/dup.txt": sum}
+
+	assert.Equal(t, sum, sums.Lookup("exact.txt"))
+	assert.Equal(t, sum, sums.Lookup("dotted.txt"))
+	assert.Nil(t, sums.Lookup("dup.txt"), "ambiguous names must not match")
+	assert.Nil(t, sums.Lookup("missing.txt"))
+
+	var empty Sums
+	assert.Nil(t, empty.Lookup("exact.txt"))
+}
+
+func TestFetchSums(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/SHA256SUMS" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, helloSHA256+"  hello.txt\n")
+	}))
+	defer ts.Close()
+
+	sums, err := FetchSums(context.Background(), &clients.RealHttpClient{}, ts.URL+"/SHA256SUMS")
+	assert.NoError(t, err)
+	assert.Equal(t, SHA256, sums.Lookup("hello.txt").Algorithm)
+
+	_, err = FetchSums(context.Background(), &clients.RealHttpClient{}, ts.URL+"/MISSING")
+	assert.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package clients
+
+import "fmt"
+
+// ConflictPolicy says what to do when a download's destination already
+// exists.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip leaves the existing file alone.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing file once the new one is
+	// complete.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename saves the download next to the existing file under
+	// the first free numbered name, such as file.1.txt.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictResume treats the existing file as the beginning of the
+	// download and fetches the rest.
+	ConflictResume ConflictPolicy = "resume"
+	// ConflictSkipIdentical skips the download when the existing file has
+	// the remote size and ETag or modification time, and overwrites it
+	// otherwise.
+	ConflictSkipIdentical ConflictPolicy = "skip-if-identical"
+)
+
+// ParseConflictPolicy validates the name of a conflict policy.
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(value); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename, ConflictResume, ConflictSkipIdentical:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected skip, overwrite, rename, resume or skip-if-identical", value)
+}
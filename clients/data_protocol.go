@@ -0,0 +1,79 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// DataScheme is the URL scheme of data URLs, which carry their content, as
+// in data:text/plain;base64,SGVsbG8=.
+const DataScheme = "data"
+
+// defaultDataType is the media type of data URLs that name none.
+const defaultDataType = "text/plain;charset=US-ASCII"
+
+// DataProtocol serves the content of data URLs (RFC 2397).
+type DataProtocol struct{}
+
+func (p *DataProtocol) Stat(ctx context.Context, u *url.URL) (*FileInfo, error) {
+	contentType, data, err := parseDataURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{Size: int64(len(data)), ContentType: contentType, Ranges: true}, nil
+}
+
+func (p *DataProtocol) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	_, data, err := parseDataURL(u)
+	if err != nil {
+		return nil, err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// parseDataURL returns the media type and content of a data URL.
+func parseDataURL(u *url.URL) (string, []byte, error) {
+	raw := u.Opaque
+	if u.RawQuery != "" || u.ForceQuery {
+		raw += "?" + u.RawQuery
+	}
+	meta, payload, ok := strings.Cut(raw, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid data URL: no comma")
+	}
+	encoded := strings.HasSuffix(strings.ToLower(meta), ";base64")
+	if encoded {
+		meta = meta[:len(meta)-len(";base64")]
+	}
+	if meta == "" || strings.HasPrefix(meta, ";") {
+		meta = defaultDataType
+	}
+
+	payload, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URL: %w", err)
+	}
+	if !encoded {
+		return meta, []byte(payload), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		// Padding is often left out
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "=")); err != nil {
+			return "", nil, fmt.Errorf("invalid data URL: %w", err)
+		}
+	}
+	return meta, data, nil
+}
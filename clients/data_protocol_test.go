@@ -0,0 +1,54 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		data        string
+		ok          bool
+	}{
+		{"data:,hello", defaultDataType, "hello", true},
+		{"data:text/plain,a%20b", "text/plain", "a b", true},
+		{"data:text/plain;base64,SGVsbG8=", "text/plain", "Hello", true},
+		{"data:;base64,SGVsbG8", defaultDataType, "Hello", true},
+		{"data:application/json,{\"a\":1}?x", "application/json", "{\"a\":1}?x", true},
+		{"data:text/plain;charset=utf-8,%E2%9C%93", "text/plain;charset=utf-8", "✓", true},
+		{"data:text/plain", "", "", false},
+		{"data:;base64,!!!", "", "", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		assert.NoError(t, err, test.url)
+		contentType, data, err := parseDataURL(u)
+		if !test.ok {
+			assert.Error(t, err, test.url)
+			continue
+		}
+		assert.NoError(t, err, test.url)
+		assert.Equal(t, test.contentType, contentType, test.url)
+		assert.Equal(t, test.data, string(data), test.url)
+	}
+}
+
+func TestDataProtocol(t *testing.T) {
+	u, _ := url.Parse("data:text/csv,a,b,c")
+	protocol := &DataProtocol{}
+
+	info, err := protocol.Stat(context.Background(), u)
+	assert.NoError(t, err)
+	assert.Equal(t, &FileInfo{Size: 5, ContentType: "text/csv", Ranges: true}, info)
+
+	body, err := protocol.Open(context.Background(), u, 2, 2)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(body)
+	assert.Equal(t, "b,", string(content))
+}
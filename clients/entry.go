@@ -0,0 +1,29 @@
+package clients
+
+import "net/http"
+
+// DownloadEntry is a single download together with its per-URL options.
+type DownloadEntry struct {
+	// URL is the primary location of the file.
+	URL string
+	// Mirrors are tried in order when downloading from URL fails.
+	Mirrors []string
+	// Out overrides the file name derived from the URL.
+	Out string
+	// Dir is the directory to save into. Relative paths are resolved
+	// against the download directory.
+	Dir string
+	// Checksum is the expected digest of the file, as "algorithm=hex".
+	Checksum string
+	// Header holds extra request headers sent with every request for this
+	// entry.
+	Header http.Header
+	// OnConflict overrides the downloader's policy for an existing
+	// destination file.
+	OnConflict ConflictPolicy
+}
+
+// URLs returns the primary URL followed by the mirrors.
+func (e DownloadEntry) URLs() []string {
+	return append([]string{e.URL}, e.Mirrors...)
+}
@@ -0,0 +1,49 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProtocol(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	assert.NoError(t, os.WriteFile(name, []byte("0123456789"), 0644))
+	u := &url.URL{Scheme: FileScheme, Path: filepath.ToSlash(name)}
+	protocol := &FileProtocol{}
+	ctx := context.Background()
+
+	info, err := protocol.Stat(ctx, u)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), info.Size)
+	assert.True(t, info.Ranges)
+	assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+
+	body, err := protocol.Open(ctx, u, 3, 4)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "3456", string(content))
+
+	body, err = protocol.Open(ctx, u, 6, -1)
+	assert.NoError(t, err)
+	content, _ = io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "6789", string(content))
+
+	_, err = protocol.Stat(ctx, &url.URL{Scheme: FileScheme, Path: filepath.ToSlash(filepath.Join(dir, "missing"))})
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = protocol.Stat(ctx, &url.URL{Scheme: FileScheme, Path: filepath.ToSlash(dir)})
+	assert.ErrorContains(t, err, "is a directory")
+	_, err = protocol.Stat(ctx, &url.URL{Scheme: FileScheme, Host: "example.com", Path: u.Path})
+	assert.ErrorContains(t, err, "other hosts")
+	_, err = protocol.Stat(ctx, &url.URL{Scheme: FileScheme, Host: "localhost", Path: u.Path})
+	assert.NoError(t, err)
+}
@@ -3,6 +3,7 @@ package clients
 import (
 	"context"
 	"net/http"
+	"sync"
 )
 
 // HttpClient is an interface that wraps the Get method.
@@ -12,19 +13,81 @@ type HttpClient interface {
 	Do(ctx context.Context, req *http.Request) (*http.Response, error)
 }
 
-// RealHttpClient is a real implementation that uses http.Client.
-type RealHttpClient struct{}
+// RealHttpClient is a real implementation that uses http.Client. Clients
+// are meant to be shared, so that connections are reused across downloads;
+// see ClientBuilder for tuning them.
+type RealHttpClient struct {
+	// Client sends the requests. When nil, a client shared by every
+	// RealHttpClient without one is used, with DefaultTransportConfig.
+	Client *http.Client
+}
+
+var (
+	defaultClient     *http.Client
+	defaultClientOnce sync.Once
+)
+
+// client returns the client requests are sent with.
+func (c *RealHttpClient) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	defaultClientOnce.Do(func() {
+		// The default settings have no proxy to get wrong
+		defaultClient, _ = DefaultTransportConfig().NewClient()
+	})
+	return defaultClient
+}
 
 func (c *RealHttpClient) Get(url string) (*http.Response, error) {
-	return http.Get(url)
+	return c.client().Get(url)
 }
 
 func (c *RealHttpClient) Head(url string) (*http.Response, error) {
-	return http.Head(url)
+	return c.client().Head(url)
 }
 
 func (c *RealHttpClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
-	client := &http.Client{}
-	req = req.WithContext(ctx)
-	return client.Do(req)
+	return c.client().Do(req.WithContext(ctx))
+}
+
+// headerClient adds a fixed set of headers to every request.
+type headerClient struct {
+	client HttpClient
+	header http.Header
+}
+
+// WithHeader returns a client that sends header with every request made
+// through client. Headers already present on a request are left alone.
+func WithHeader(client HttpClient, header http.Header) HttpClient {
+	if len(header) == 0 {
+		return client
+	}
+	return &headerClient{client: client, header: header}
+}
+
+func (c *headerClient) Get(url string) (*http.Response, error) {
+	return c.send(http.MethodGet, url)
+}
+
+func (c *headerClient) Head(url string) (*http.Response, error) {
+	return c.send(http.MethodHead, url)
+}
+
+func (c *headerClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req = req.Clone(ctx)
+	for name, values := range c.header {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	return c.client.Do(ctx, req)
+}
+
+func (c *headerClient) send(method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(context.Background(), req)
 }
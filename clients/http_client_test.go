@@ -79,3 +79,41 @@ func TestDo(t *testing.T) {
 		t.Errorf("Expected status code %d, but got: %d", http.StatusOK, resp.StatusCode)
 	}
 }
+
+func TestWithHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Token", r.Header.Get("X-Token"))
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+	}))
+	defer ts.Close()
+
+	client := WithHeader(&RealHttpClient{}, http.Header{"X-Token": {"secret"}, "X-Trace": {"default"}})
+
+	resp, err := client.Head(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Token") != "secret" {
+		t.Fatalf("Expected header to be sent with HEAD, got %q", resp.Header.Get("X-Token"))
+	}
+
+	// Headers set on the request itself take precedence
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("X-Trace", "explicit")
+	resp, err = client.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Token") != "secret" || resp.Header.Get("X-Trace") != "explicit" {
+		t.Fatalf("Expected merged headers, got %v", resp.Header)
+	}
+}
+
+func TestWithHeader_NoHeaders(t *testing.T) {
+	client := &RealHttpClient{}
+	if WithHeader(client, nil) != HttpClient(client) {
+		t.Fatalf("Expected the client to be returned unchanged when there are no headers")
+	}
+}
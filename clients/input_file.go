@@ -0,0 +1,140 @@
+package clients
+
+import (
+	"GoDownload/helpers"
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// ParseInputFile reads a list of downloads in a format modelled on aria2's
+// input file:
+//
+//	# Lines starting with '#' and blank lines are ignored.
+//	https://example.com/disk.img https://mirror.example.com/disk.img
+//	  out=disk-v2.img
+//	  dir=images
+//	  checksum=sha-256=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	  header=Authorization: Bearer secret
+//	  mirror=https://other-mirror.example.com/disk.img
+//	  conflict=rename
+//
+// Each non-indented line starts a new entry and may list mirrors after the
+// primary URL, separated by whitespace. Indented key=value lines set options
+// for the entry above them; header and mirror may be repeated.
+func ParseInputFile(r io.Reader) ([]DownloadEntry, error) {
+	var entries []DownloadEntry
+	err := scanInputFile(r, func(entry DownloadEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// scanInputFile parses r incrementally, calling emit for each entry as soon
+// as the line after its last option has been read.
+func scanInputFile(r io.Reader, emit func(DownloadEntry) error) error {
+	var current *DownloadEntry
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Indented lines are options of the current entry
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == nil {
+				return fmt.Errorf("line %d: option before the first URL", lineNo)
+			}
+			if err := applyInputOption(current, trimmed); err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			continue
+		}
+
+		// A new URL line completes the previous entry
+		if current != nil {
+			if err := emit(*current); err != nil {
+				return err
+			}
+		}
+		urls := strings.Fields(trimmed)
+		for _, u := range urls {
+			if !helpers.IsValidURL(u) {
+				return fmt.Errorf("line %d: invalid URL: %s", lineNo, u)
+			}
+		}
+		current = &DownloadEntry{URL: urls[0], Mirrors: urls[1:]}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if current != nil {
+		return emit(*current)
+	}
+	return nil
+}
+
+// applyInputOption sets a single key=value option on entry.
+func applyInputOption(entry *DownloadEntry, option string) error {
+	key, value, ok := strings.Cut(option, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", option)
+	}
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case "out":
+		if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+			return fmt.Errorf("out must be a plain file name, got %q", value)
+		}
+		entry.Out = value
+	case "dir":
+		// Input lists may come from a URL, so an absolute dir is refused
+		// as well
+		if !filepath.IsLocal(value) {
+			return fmt.Errorf("dir must not leave the download directory, got %q", value)
+		}
+		entry.Dir = value
+	case "checksum":
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("checksum must be algorithm=digest, got %q", value)
+		}
+		entry.Checksum = value
+	case "header":
+		name, headerValue, ok := strings.Cut(value, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("header must be Name: value, got %q", value)
+		}
+		if entry.Header == nil {
+			entry.Header = http.Header{}
+		}
+		entry.Header.Add(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+	case "conflict":
+		policy, err := ParseConflictPolicy(value)
+		if err != nil {
+			return err
+		}
+		entry.OnConflict = policy
+	case "mirror":
+		if !helpers.IsValidURL(value) {
+			return fmt.Errorf("invalid mirror URL: %s", value)
+		}
+		entry.Mirrors = append(entry.Mirrors, value)
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInputFile(t *testing.T) {
+	input := `# Disk images
+https://example.com/disk.img	https://mirror.example.com/disk.img
+  out=disk-v2.img
+  dir=images
+  checksum=sha-256=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+  header=Authorization: Bearer secret
+  header=X-Trace: 1
+  mirror=https://other.example.com/disk.img
+  conflict=rename
+
+https://example.com/readme.txt
+`
+	entries, err := ParseInputFile(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	disk := entries[0]
+	assert.Equal(t, "https://example.com/disk.img", disk.URL)
+	assert.Equal(t, []string{"https://mirror.example.com/disk.img", "https://other.example.com/disk.img"}, disk.Mirrors)
+	assert.Equal(t, "disk-v2.img", disk.Out)
+	assert.Equal(t, "images", disk.Dir)
+	assert.Equal(t, "sha-256=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", disk.Checksum)
+	assert.Equal(t, "Bearer secret", disk.Header.Get("Authorization"))
+	assert.Equal(t, "1", disk.Header.Get("X-Trace"))
+	assert.Equal(t, ConflictRename, disk.OnConflict)
+
+	assert.Equal(t, DownloadEntry{URL: "https://example.com/readme.txt", Mirrors: []string{}}, entries[1])
+}
+
+func TestParseInputFile_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"option before URL", "  out=file.txt\n", "line 1: option before the first URL"},
+		{"invalid URL", "https://example.com/a\nnot-a-url\n", "line 2: invalid URL"},
+		{"unknown option", "https://example.com/a\n  speed=fast\n", `line 2: unknown option "speed"`},
+		{"missing value", "https://example.com/a\n  out\n", "line 2: expected key=value"},
+		{"out with path", "https://example.com/a\n  out=../etc/passwd\n", "line 2: out must be a plain file name"},
+		{"dir escaping", "https://example.com/a\n  dir=../..\n", "line 2: dir must not leave the download directory"},
+		{"absolute dir", "https://example.com/a\n  dir=/etc\n", "line 2: dir must not leave the download directory"},
+		{"bad header", "https://example.com/a\n  header=NoColon\n", "line 2: header must be Name: value"},
+		{"bad conflict policy", "https://example.com/a\n  conflict=ask\n", `line 2: unknown conflict policy "ask"`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseInputFile(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestFileURLProvider_GetURLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	os.WriteFile(path, []byte("https://example.com/a\n  out=b\nhttps://example.com/c\n"), 0644)
+
+	provider := &FileURLProvider{Filename: path}
+	urls, err := provider.GetURLs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/a", "https://example.com/c"}, urls)
+}
+
+func TestFileURLProvider_Stdin(t *testing.T) {
+	provider := &FileURLProvider{Filename: "-", Stdin: strings.NewReader("https://example.com/a\n")}
+	entries, err := provider.GetEntries()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileURLProvider_MissingFile(t *testing.T) {
+	provider := &FileURLProvider{Filename: filepath.Join(t.TempDir(), "missing.txt")}
+	_, err := provider.GetEntries()
+	assert.Error(t, err)
+}
+
+func TestFileURLProvider_URL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.txt")
+	os.WriteFile(path, []byte("https://example.com/a\n"), 0644)
+
+	// Read with the protocol registered for the scheme
+	provider := &FileURLProvider{Filename: "file://" + filepath.ToSlash(path)}
+	urls, err := provider.GetURLs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/a"}, urls)
+
+	// or through the client given
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "yes", r.Header.Get("X-Client"))
+		w.Write([]byte("https://example.com/b\n"))
+	}))
+	defer ts.Close()
+	provider = &FileURLProvider{Filename: ts.URL + "/urls.txt", Client: WithHeader(&RealHttpClient{}, http.Header{"X-Client": {"yes"}})}
+	urls, err = provider.GetURLs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/b"}, urls)
+}
@@ -0,0 +1,182 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps the round tripper requests are sent through, to change
+// requests on their way out, responses on their way back, or both. Auth,
+// logging, metrics, header injection and request signing are all written
+// as middleware.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc lets an ordinary function serve as an http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps base in middleware. The first middleware sees requests first
+// and responses last.
+func Chain(base http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		base = middleware[i](base)
+	}
+	return base
+}
+
+// Use returns a client that sends the requests made through it through
+// middleware before handing them to client. It works with any HttpClient,
+// so middleware also applies on top of rate limiting, host limits or mocks.
+func Use(client HttpClient, middleware ...Middleware) HttpClient {
+	if len(middleware) == 0 {
+		return client
+	}
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return client.Do(req.Context(), req)
+	})
+	return &middlewareClient{transport: Chain(next, middleware...)}
+}
+
+// middlewareClient sends every request through a chain of middleware.
+type middlewareClient struct {
+	transport http.RoundTripper
+}
+
+func (c *middlewareClient) Get(url string) (*http.Response, error) {
+	return c.send(http.MethodGet, url)
+}
+
+func (c *middlewareClient) Head(url string) (*http.Response, error) {
+	return c.send(http.MethodHead, url)
+}
+
+func (c *middlewareClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return c.transport.RoundTrip(req.WithContext(ctx))
+}
+
+func (c *middlewareClient) send(method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(context.Background(), req)
+}
+
+// SetHeader returns middleware that sets a header on every request that
+// does not have it yet.
+func SetHeader(name string, value string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(name) == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set(name, value)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// UserAgent returns middleware that sends agent as the User-Agent.
+func UserAgent(agent string) Middleware {
+	return SetHeader("User-Agent", agent)
+}
+
+// Logging returns middleware that logs every request with its status and
+// duration at Info level, so it shows with the command line's production
+// logger. A nil sugar logs to the logger stored in the request context, if
+// any.
+func Logging(sugar *zap.SugaredLogger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			logger := sugar
+			if logger == nil {
+				logger, _ = req.Context().Value("sugar").(*zap.SugaredLogger)
+			}
+			started := time.Now()
+			resp, err := next.RoundTrip(req)
+			if logger != nil {
+				if err != nil {
+					logger.Infow("Request failed", "method", req.Method, "url", req.URL.String(), "error", err, "duration", time.Since(started))
+				} else {
+					logger.Infow("Request done", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "duration", time.Since(started))
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// MiddlewareFactory makes middleware from the argument it is enabled with,
+// which is empty when none is given.
+type MiddlewareFactory func(arg string) (Middleware, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]MiddlewareFactory{}
+)
+
+// RegisterMiddleware makes middleware available under name, so it can be
+// enabled by NewMiddleware, for example from the command line or a config
+// file. It panics if name is already taken.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, taken := registry[name]; taken {
+		panic("clients: middleware " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// RegisteredMiddleware returns the names of all registered middleware,
+// sorted.
+func RegisteredMiddleware() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewMiddleware makes registered middleware from a spec of the form name or
+// name=arg.
+func NewMiddleware(spec string) (Middleware, error) {
+	name, arg, _ := strings.Cut(spec, "=")
+	registryMu.RLock()
+	factory, ok := registry[strings.TrimSpace(name)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown middleware %q, expected one of %s", name, strings.Join(RegisteredMiddleware(), ", "))
+	}
+	return factory(arg)
+}
+
+func init() {
+	RegisterMiddleware("header", func(arg string) (Middleware, error) {
+		name, value, found := strings.Cut(arg, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", arg)
+		}
+		return SetHeader(strings.TrimSpace(name), strings.TrimSpace(value)), nil
+	})
+	RegisterMiddleware("user-agent", func(arg string) (Middleware, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("user-agent needs a value")
+		}
+		return UserAgent(arg), nil
+	})
+	RegisterMiddleware("log", func(arg string) (Middleware, error) {
+		return Logging(nil), nil
+	})
+}
@@ -0,0 +1,134 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// tagging returns middleware that records name on the way in and out.
+func tagging(name string, calls *[]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			*calls = append(*calls, name+" in")
+			resp, err := next.RoundTrip(req)
+			*calls = append(*calls, name+" out")
+			return resp, err
+		})
+	}
+}
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "base")
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	_, err := Chain(base, tagging("first", &calls), tagging("second", &calls)).RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first in", "second in", "base", "second out", "first out"}, calls)
+}
+
+func TestUse_WrapsAnyClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := NewMockHttpClient(ctrl)
+	mockClient.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "GoDownload-test", req.Header.Get("User-Agent"))
+		assert.Equal(t, http.MethodHead, req.Method)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	resp, err := Use(mockClient, UserAgent("GoDownload-test")).Head("http://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestUse_NoMiddleware(t *testing.T) {
+	client := &RealHttpClient{}
+	assert.Same(t, client, Use(client))
+}
+
+func TestSetHeader_KeepsExisting(t *testing.T) {
+	var got string
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header.Get("Accept")
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("Accept", "text/plain")
+
+	SetHeader("Accept", "*/*")(base).RoundTrip(req)
+	assert.Equal(t, "text/plain", got)
+}
+
+func TestClientBuilder_Use(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Token")))
+	}))
+	defer ts.Close()
+
+	middleware, err := NewMiddleware("header=X-Token: abc")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := mustBuild(t, NewClientBuilder().Use(middleware)).Get(ts.URL)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		body := make([]byte, 3)
+		resp.Body.Read(body)
+		assert.Equal(t, "abc", string(body))
+	}
+}
+
+func TestMiddlewareRegistry(t *testing.T) {
+	RegisterMiddleware("test-noop", func(arg string) (Middleware, error) {
+		return func(next http.RoundTripper) http.RoundTripper { return next }, nil
+	})
+
+	assert.Contains(t, RegisteredMiddleware(), "test-noop")
+	_, err := NewMiddleware("test-noop")
+	assert.NoError(t, err)
+
+	_, err = NewMiddleware("missing")
+	assert.ErrorContains(t, err, "unknown middleware")
+	_, err = NewMiddleware("header=no colon")
+	assert.Error(t, err)
+	assert.Panics(t, func() {
+		RegisterMiddleware("test-noop", nil)
+	})
+}
+
+func TestLogging_ShowsAtInfoLevel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// The command line logs at Info level and above, see zap.NewProduction
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := context.WithValue(context.Background(), "sugar", zap.New(core).Sugar())
+
+	middleware, err := NewMiddleware("log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	resp, err := mustBuild(t, NewClientBuilder().Use(middleware)).Do(ctx, req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	entries := logs.FilterMessage("Request done").All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, int64(http.StatusOK), entries[0].ContextMap()["status"])
+	}
+}
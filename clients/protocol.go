@@ -0,0 +1,192 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol fetches files from a storage system, such as an FTP server or
+// the local file system. Clients built by this package serve the protocols
+// registered with RegisterProtocol through ProtocolTransport, as if they
+// were HTTP servers, so resuming, segmenting, conflict handling and
+// progress work the same for every scheme.
+type Protocol interface {
+	// Stat returns what is known about the file at u. Errors wrapping
+	// fs.ErrNotExist or fs.ErrPermission are answered with 404 and 403.
+	Stat(ctx context.Context, u *url.URL) (*FileInfo, error)
+	// Open reads length bytes of the file at u starting at offset, or
+	// the rest of the file when length is negative; Open(ctx, u, 0, -1)
+	// reads all of it.
+	Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// FileInfo describes a file served by a Protocol.
+type FileInfo struct {
+	// Size is the length of the file, or -1 if unknown.
+	Size    int64
+	ModTime time.Time
+	// ETag identifies the version of the file, if the protocol has such
+	// a thing.
+	ETag        string
+	ContentType string
+	// Ranges tells whether Open can start at an offset. Ranges are only
+	// served for files of known size.
+	Ranges bool
+}
+
+// ProtocolTransport answers HEAD and GET requests, including single byte
+// ranges and If-Range, from protocol. Basic credentials of a request are
+// passed on to the protocol as the user info of the URL.
+func ProtocolTransport(protocol Protocol) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return protocolResponse(req, http.StatusMethodNotAllowed, nil), nil
+		}
+
+		u := req.URL
+		if user, password, ok := req.BasicAuth(); ok && u.User == nil {
+			copied := *u
+			copied.User = url.UserPassword(user, password)
+			u = &copied
+		}
+		ctx := req.Context()
+
+		info, err := protocol.Stat(ctx, u)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return protocolResponse(req, http.StatusNotFound, nil), nil
+		case errors.Is(err, fs.ErrPermission):
+			return protocolResponse(req, http.StatusForbidden, nil), nil
+		case err != nil:
+			return nil, err
+		}
+
+		resp := protocolResponse(req, http.StatusOK, info)
+		offset, length := int64(0), int64(-1)
+		if ranges(info) && req.Header.Get("Range") != "" && ifRangeMatches(req.Header.Get("If-Range"), info) {
+			start, end, ok := parseRange(req.Header.Get("Range"), info.Size)
+			if !ok {
+				resp = protocolResponse(req, http.StatusRequestedRangeNotSatisfiable, nil)
+				resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+				return resp, nil
+			}
+			offset, length = start, end-start+1
+			resp.StatusCode, resp.Status = http.StatusPartialContent, "206 Partial Content"
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size))
+			resp.ContentLength = length
+			resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+		}
+		if req.Method == http.MethodHead {
+			return resp, nil
+		}
+
+		body, err := protocol.Open(ctx, u, offset, length)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = body
+		return resp, nil
+	})
+}
+
+// protocolResponse builds a response to req, describing info if set.
+func protocolResponse(req *http.Request, status int, info *FileInfo) *http.Response {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Request:       req,
+	}
+	if info == nil {
+		return resp
+	}
+	resp.ContentLength = info.Size
+	if info.Size >= 0 {
+		resp.Header.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if !info.ModTime.IsZero() {
+		resp.Header.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.ETag != "" {
+		resp.Header.Set("ETag", info.ETag)
+	}
+	if info.ContentType != "" {
+		resp.Header.Set("Content-Type", info.ContentType)
+	}
+	if ranges(info) {
+		resp.Header.Set("Accept-Ranges", "bytes")
+	}
+	return resp
+}
+
+// ifRangeMatches reports whether an If-Range validator still matches the
+// file, so a range of it may be served.
+func ifRangeMatches(validator string, info *FileInfo) bool {
+	if validator == "" {
+		return true
+	}
+	if strings.HasPrefix(validator, `"`) {
+		return validator == info.ETag
+	}
+	since, err := http.ParseTime(validator)
+	return err == nil && !info.ModTime.IsZero() && info.ModTime.Truncate(time.Second).Equal(since)
+}
+
+// parseRange parses a single byte range against a file of size bytes and
+// returns its first and last byte.
+func parseRange(value string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(value, "bytes=")
+	if !ok || size < 0 || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" {
+		// The last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func ranges(info *FileInfo) bool {
+	return info.Ranges && info.Size >= 0
+}
@@ -0,0 +1,157 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryProtocol serves files from memory.
+type memoryProtocol struct {
+	files   map[string][]byte
+	modTime time.Time
+	users   []string
+}
+
+func (p *memoryProtocol) Stat(ctx context.Context, u *url.URL) (*FileInfo, error) {
+	p.users = append(p.users, u.User.String())
+	content, ok := p.files[u.Path]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return &FileInfo{Size: int64(len(content)), ModTime: p.modTime, Ranges: true}, nil
+}
+
+func (p *memoryProtocol) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	content := p.files[u.Path][offset:]
+	if length >= 0 {
+		content = content[:length]
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func protocolGet(t *testing.T, rt http.RoundTripper, method string, rawURL string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	assert.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := rt.RoundTrip(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func TestProtocolTransport(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	protocol := &memoryProtocol{files: map[string][]byte{"/file.txt": []byte("0123456789")}, modTime: modTime}
+	rt := ProtocolTransport(protocol)
+	lastModified := modTime.Format(http.TimeFormat)
+
+	resp, body := protocolGet(t, rt, http.MethodHead, "mem://host/file.txt", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(10), resp.ContentLength)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, lastModified, resp.Header.Get("Last-Modified"))
+	assert.Empty(t, body)
+
+	resp, body = protocolGet(t, rt, http.MethodGet, "mem://host/file.txt", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	resp, body = protocolGet(t, rt, http.MethodGet, "mem://host/file.txt", http.Header{"Range": {"bytes=4-"}, "If-Range": {lastModified}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 4-9/10", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "456789", body)
+
+	resp, body = protocolGet(t, rt, http.MethodGet, "mem://host/file.txt", http.Header{"Range": {"bytes=2-4"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, int64(3), resp.ContentLength)
+	assert.Equal(t, "234", body)
+
+	// A stale validator gets the whole file
+	stale := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	resp, body = protocolGet(t, rt, http.MethodGet, "mem://host/file.txt", http.Header{"Range": {"bytes=4-"}, "If-Range": {stale}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	resp, _ = protocolGet(t, rt, http.MethodGet, "mem://host/file.txt", http.Header{"Range": {"bytes=10-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+
+	resp, _ = protocolGet(t, rt, http.MethodGet, "mem://host/missing.txt", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = protocolGet(t, rt, http.MethodPost, "mem://host/file.txt", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestProtocolTransport_BasicAuth(t *testing.T) {
+	protocol := &memoryProtocol{files: map[string][]byte{"/file.txt": []byte("x")}}
+	rt := ProtocolTransport(protocol)
+
+	req, _ := http.NewRequest(http.MethodGet, "mem://host/file.txt", nil)
+	req.SetBasicAuth("alice", "secret")
+	resp, err := rt.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, []string{"alice:secret"}, protocol.users)
+	// The request itself is left alone
+	assert.Nil(t, req.URL.User)
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-", 10, 0, 9, true},
+		{"bytes=3-5", 10, 3, 5, true},
+		{"bytes=3-50", 10, 3, 9, true},
+		{"bytes=-4", 10, 6, 9, true},
+		{"bytes=-40", 10, 0, 9, true},
+		{"bytes=5-", -1, 0, 0, false},
+		{"bytes=10-", 10, 0, 0, false},
+		{"bytes=5-3", 10, 0, 0, false},
+		{"bytes=0-1,3-4", 10, 0, 0, false},
+		{"items=0-1", 10, 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseRange(test.value, test.size)
+		assert.Equal(t, test.ok, ok, test.value)
+		if test.ok {
+			assert.Equal(t, []int64{test.start, test.end}, []int64{start, end}, test.value)
+		}
+	}
+}
+
+func TestClientBuilder_Protocol(t *testing.T) {
+	protocol := &memoryProtocol{files: map[string][]byte{"/file.txt": []byte("from memory")}}
+	var seen []string
+	record := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			seen = append(seen, req.URL.Scheme)
+			return next.RoundTrip(req)
+		})
+	}
+	client, err := NewClientBuilder().Protocol("MEM", protocol).Use(record).Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Get("mem://host/file.txt")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "from memory", string(body))
+	assert.Equal(t, []string{"mem"}, seen)
+}
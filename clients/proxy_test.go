@@ -0,0 +1,250 @@
+package clients
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConfig_ProxyFunc(t *testing.T) {
+	config := &ProxyConfig{
+		Default: "proxy.corp:3128",
+		Hosts: map[string]string{
+			"*.example.com":           "socks5://socks.corp:1080",
+			"files.example.com":       "direct",
+			"mirror.example.org:8080": "https://secure.corp:443",
+		},
+		NoProxy: ParseNoProxy("localhost, .internal, 10.0.0.0/8, 192.168.1.1, build.example.net:8443"),
+	}
+	proxyFunc, err := config.ProxyFunc()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		url   string
+		proxy string
+	}{
+		{"http://other.org/a", "http://proxy.corp:3128"},
+		{"https://example.com/a", "socks5://socks.corp:1080"},
+		{"https://cdn.example.com/a", "socks5://socks.corp:1080"},
+		{"https://files.example.com/a", ""},
+		{"http://mirror.example.org:8080/a", "https://secure.corp:443"},
+		{"http://mirror.example.org/a", "http://proxy.corp:3128"},
+		{"http://localhost:8080/a", ""},
+		{"http://wiki.internal/a", ""},
+		{"http://internal/a", ""},
+		{"http://10.1.2.3/a", ""},
+		{"http://192.168.1.1/a", ""},
+		{"https://build.example.net:8443/a", ""},
+		{"https://build.example.net/a", "http://proxy.corp:3128"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		proxy, err := proxyFunc(req)
+		assert.NoError(t, err)
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		assert.Equal(t, tt.proxy, got, tt.url)
+	}
+}
+
+func TestProxyConfig_NoProxyEverything(t *testing.T) {
+	proxyFunc, _ := (&ProxyConfig{Default: "proxy.corp:3128", NoProxy: []string{"*"}}).ProxyFunc()
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	proxy, _ := proxyFunc(req)
+	assert.Nil(t, proxy)
+}
+
+func TestProxyConfig_Invalid(t *testing.T) {
+	_, err := (&ProxyConfig{Default: "ftp://proxy.corp"}).ProxyFunc()
+	assert.Error(t, err)
+	_, err = (&ProxyConfig{Hosts: map[string]string{"example.com": "http://"}}).ProxyFunc()
+	assert.Error(t, err)
+	_, err = NewClientBuilder().Proxy(ProxyConfig{Default: "gopher://proxy"}).Build()
+	assert.Error(t, err)
+}
+
+func TestProxy_HTTP(t *testing.T) {
+	var auth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+	client := mustBuild(t, NewClientBuilder().Proxy(ProxyConfig{Default: proxyURL.String()}))
+
+	resp, err := client.Get("http://origin.invalid/file.txt")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "proxied http://origin.invalid/file.txt", string(body))
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", auth)
+}
+
+func TestProxy_HTTPSConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret content"))
+	}))
+	defer origin.Close()
+
+	var tunnels int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&tunnels, 1)
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buffered, _ := w.(http.Hijacker).Hijack()
+		go func() {
+			io.Copy(upstream, buffered)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	client := mustBuild(t, NewClientBuilder().Proxy(ProxyConfig{Default: proxy.URL}))
+	client.Client.Transport.(*http.Transport).TLSClientConfig = origin.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+
+	resp, err := client.Get(origin.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "secret content", string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tunnels))
+}
+
+func TestProxy_SOCKS5(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("over socks"))
+	}))
+	defer origin.Close()
+
+	proxyAddr, connects := socks5Server(t, "user", "secret")
+	client := mustBuild(t, NewClientBuilder().Proxy(ProxyConfig{Default: "socks5://user:secret@" + proxyAddr}))
+
+	resp, err := client.Get(origin.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "over socks", string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(connects))
+
+	wrong := mustBuild(t, NewClientBuilder().Proxy(ProxyConfig{Default: "socks5://user:wrong@" + proxyAddr}))
+	_, err = wrong.Get(origin.URL)
+	assert.Error(t, err)
+}
+
+// socks5Server runs a minimal SOCKS5 proxy requiring username and password
+// authentication, returning its address and a count of CONNECT requests.
+func socks5Server(t *testing.T, user string, password string) (string, *int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var connects int32
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		// Greeting: version, methods; pick username/password
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+			return
+		}
+		conn.Write([]byte{5, 2})
+
+		// RFC 1929 authentication
+		version, _ := r.ReadByte()
+		userLen, _ := r.ReadByte()
+		gotUser := make([]byte, userLen)
+		io.ReadFull(r, gotUser)
+		passLen, _ := r.ReadByte()
+		gotPass := make([]byte, passLen)
+		io.ReadFull(r, gotPass)
+		if version != 1 || string(gotUser) != user || string(gotPass) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		// Request: version, command, reserved, address
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(r, request); err != nil || request[1] != 1 {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			n, _ := r.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(r, name)
+			host = string(name)
+		default:
+			return
+		}
+		portBytes := make([]byte, 2)
+		io.ReadFull(r, portBytes)
+		port := binary.BigEndian.Uint16(portBytes)
+
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		atomic.AddInt32(&connects, 1)
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		go io.Copy(upstream, r)
+		io.Copy(conn, upstream)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String(), &connects
+}
@@ -0,0 +1,214 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = map[string]Protocol{}
+	// localSchemes are the registered schemes reaching the local machine.
+	localSchemes = map[string]bool{}
+)
+
+// RegisterProtocol makes protocol serve URLs with the given scheme in every
+// client built afterwards, and in the shared default client if it has not
+// been used yet, so register from an init function. Packages providing
+// protocols register themselves, as ftp and s3 do. It panics if the scheme
+// is already taken.
+func RegisterProtocol(scheme string, protocol Protocol) {
+	scheme = strings.ToLower(scheme)
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, taken := protocols[scheme]; taken {
+		panic("clients: protocol " + scheme + " registered twice")
+	}
+	protocols[scheme] = protocol
+}
+
+// RegisterLocalProtocol registers a protocol reaching the local machine,
+// such as file. Clients do not serve it unless asked to with
+// ClientBuilder.Protocol, so that remote servers and the URL lists they
+// hand out cannot read local files, but OpenURL does.
+func RegisterLocalProtocol(scheme string, protocol Protocol) {
+	RegisterProtocol(scheme, protocol)
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	localSchemes[strings.ToLower(scheme)] = true
+}
+
+// isLocalProtocol reports whether scheme was registered as local.
+func isLocalProtocol(scheme string) bool {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	return localSchemes[strings.ToLower(scheme)]
+}
+
+// LookupProtocol returns the protocol registered for scheme.
+func LookupProtocol(scheme string) (Protocol, bool) {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	protocol, ok := protocols[strings.ToLower(scheme)]
+	return protocol, ok
+}
+
+// RegisteredProtocols returns the registered schemes, sorted.
+func RegisteredProtocols() []string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	schemes := make([]string, 0, len(protocols))
+	for scheme := range protocols {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenURL reads all of rawURL with the protocol registered for its scheme.
+func OpenURL(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	protocol, ok := LookupProtocol(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("no protocol registered for %q", u.Scheme)
+	}
+	return protocol.Open(ctx, u, 0, -1)
+}
+
+// installProtocols lets transport serve the registered protocols, except
+// local ones, and overrides. HTTP and HTTPS stay with the transport itself.
+func installProtocols(transport *http.Transport, overrides map[string]Protocol) {
+	protocolsMu.RLock()
+	installed := make(map[string]Protocol, len(protocols)+len(overrides))
+	for scheme, protocol := range protocols {
+		if !localSchemes[scheme] {
+			installed[scheme] = protocol
+		}
+	}
+	protocolsMu.RUnlock()
+	for scheme, protocol := range overrides {
+		installed[scheme] = protocol
+	}
+
+	for scheme, protocol := range installed {
+		if scheme == "http" || scheme == "https" {
+			continue
+		}
+		transport.RegisterProtocol(scheme, ProtocolTransport(protocol))
+	}
+}
+
+// HTTPProtocol reads URLs through an HttpClient. It is registered for http
+// and https, and since clients built by this package serve every
+// registered protocol, it reads other schemes through them as well.
+type HTTPProtocol struct {
+	// Client sends the requests. It defaults to the shared default
+	// client.
+	Client HttpClient
+}
+
+func (p *HTTPProtocol) client() HttpClient {
+	if p.Client == nil {
+		return &RealHttpClient{}
+	}
+	return p.Client
+}
+
+// Stat sends a HEAD request.
+func (p *HTTPProtocol) Stat(ctx context.Context, u *url.URL) (*FileInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client().Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if err := statusError(u, resp); err != nil {
+		return nil, err
+	}
+
+	info := &FileInfo{
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+		ContentType: resp.Header.Get("Content-Type"),
+		Ranges:      resp.Header.Get("Accept-Ranges") == "bytes",
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// Open sends a GET request, for a range if only part of the file is
+// wanted. Servers ignoring the range still work, at the cost of sending
+// what is skipped.
+func (p *HTTPProtocol) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return http.NoBody, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case length >= 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := p.client().Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := statusError(u, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+// statusError turns an unsuccessful response into an error, wrapping
+// fs.ErrNotExist or fs.ErrPermission where they apply.
+func statusError(u *url.URL, resp *http.Response) error {
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		return &fs.PathError{Op: "get", Path: u.Redacted(), Err: fs.ErrNotExist}
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return &fs.PathError{Op: "get", Path: u.Redacted(), Err: fs.ErrPermission}
+	}
+	return fmt.Errorf("%s: %s", u.Redacted(), resp.Status)
+}
+
+func init() {
+	RegisterProtocol("http", &HTTPProtocol{})
+	RegisterProtocol("https", &HTTPProtocol{})
+	RegisterLocalProtocol(FileScheme, &FileProtocol{})
+	RegisterProtocol(DataScheme, &DataProtocol{})
+}
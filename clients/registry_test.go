@@ -0,0 +1,167 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterProtocol(t *testing.T) {
+	registered := &memoryProtocol{files: map[string][]byte{"/file.txt": []byte("registered")}}
+	RegisterProtocol("Test-Registry", registered)
+
+	protocol, ok := LookupProtocol("test-registry")
+	assert.True(t, ok)
+	assert.Same(t, registered, protocol)
+	assert.Subset(t, RegisteredProtocols(), []string{"data", "file", "http", "https", "test-registry"})
+	assert.Panics(t, func() { RegisterProtocol("test-registry", &memoryProtocol{}) })
+
+	// Clients built afterwards serve the scheme
+	client, err := DefaultTransportConfig().NewClient()
+	assert.NoError(t, err)
+	resp, err := client.Get("test-registry://host/file.txt")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "registered", string(body))
+
+	// unless the builder overrides it
+	override := &memoryProtocol{files: map[string][]byte{"/file.txt": []byte("override")}}
+	built, err := NewClientBuilder().Protocol("test-registry", override).Build()
+	assert.NoError(t, err)
+	resp, err = built.Get("test-registry://host/file.txt")
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "override", string(body))
+}
+
+func TestRegisterLocalProtocol(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "secret.txt")
+	assert.NoError(t, os.WriteFile(name, []byte("secret"), 0644))
+	fileURL := "file://" + filepath.ToSlash(name)
+
+	// Clients leave local files alone unless given the protocol
+	client, err := DefaultTransportConfig().NewClient()
+	assert.NoError(t, err)
+	_, err = client.Get(fileURL)
+	assert.Error(t, err)
+
+	built, err := NewClientBuilder().Protocol(FileScheme, &FileProtocol{}).Build()
+	assert.NoError(t, err)
+	resp, err := built.Get(fileURL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "secret", string(body))
+
+	// OpenURL reads what the caller names
+	opened, err := OpenURL(context.Background(), fileURL)
+	assert.NoError(t, err)
+	opened.Close()
+}
+
+func TestCheckRedirect_OtherSchemes(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "secret.txt")
+	assert.NoError(t, os.WriteFile(name, []byte("secret"), 0644))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file":
+			http.Redirect(w, r, "file://"+filepath.ToSlash(name), http.StatusFound)
+		case "/data":
+			http.Redirect(w, r, "data:,injected", http.StatusFound)
+		case "/http":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		default:
+			w.Write([]byte("target"))
+		}
+	}))
+	defer ts.Close()
+
+	// Even a client serving local files does not follow servers there
+	client, err := NewClientBuilder().Protocol(FileScheme, &FileProtocol{}).Build()
+	assert.NoError(t, err)
+	for _, path := range []string{"/file", "/data"} {
+		resp, err := client.Get(ts.URL + path)
+		if assert.Error(t, err, path) {
+			assert.Contains(t, err.Error(), "refusing redirect", path)
+		} else {
+			resp.Body.Close()
+		}
+	}
+
+	resp, err := client.Get(ts.URL + "/http")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "target", string(body))
+}
+
+func TestOpenURL(t *testing.T) {
+	body, err := OpenURL(context.Background(), "data:,hello%20world")
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "hello world", string(content))
+
+	_, err = OpenURL(context.Background(), "unknown://host/file")
+	assert.ErrorContains(t, err, "no protocol registered")
+}
+
+func TestHTTPProtocol(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt":
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "file.txt", modTime, strings.NewReader("0123456789"))
+		case "/no-ranges.txt":
+			w.Write([]byte("0123456789"))
+		case "/secret.txt":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	protocol := &HTTPProtocol{Client: &RealHttpClient{}}
+	ctx := context.Background()
+	parse := func(path string) *url.URL {
+		u, _ := url.Parse(ts.URL + path)
+		return u
+	}
+
+	info, err := protocol.Stat(ctx, parse("/file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, &FileInfo{Size: 10, ModTime: modTime, ETag: `"v1"`, ContentType: "text/plain; charset=utf-8", Ranges: true}, info)
+
+	for _, path := range []string{"/file.txt", "/no-ranges.txt"} {
+		for _, test := range []struct {
+			offset, length int64
+			expected       string
+		}{{0, -1, "0123456789"}, {4, -1, "456789"}, {2, 3, "234"}, {2, 0, ""}} {
+			body, err := protocol.Open(ctx, parse(path), test.offset, test.length)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			content, _ := io.ReadAll(body)
+			body.Close()
+			assert.Equal(t, test.expected, string(content), "%s %d+%d", path, test.offset, test.length)
+		}
+	}
+
+	_, err = protocol.Stat(ctx, parse("/missing.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = protocol.Open(ctx, parse("/secret.txt"), 0, -1)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+}
@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is tried again and how long
+// to wait first. A nil *RetryPolicy never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially growing wait.
+	MaxBackoff time.Duration
+	// Multiplier is applied to the wait after every attempt.
+	Multiplier float64
+	// Jitter randomises each wait by up to this fraction in either direction.
+	Jitter float64
+	// RetryableStatusCodes lists the HTTP statuses worth retrying.
+	RetryableStatusCodes []int
+	// MaxRetryAfter is the longest Retry-After the policy is willing to wait
+	// for. Servers asking for more make the request fail immediately.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by the command line tool.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooEarly,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		MaxRetryAfter: 5 * time.Minute,
+	}
+}
+
+// StatusError reports a response with an unexpected HTTP status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is how long the server asked us to wait, if it did.
+	RetryAfter time.Duration
+}
+
+// NewStatusError describes resp, picking up its Retry-After header.
+func NewStatusError(resp *http.Response) *StatusError {
+	retryAfter, _ := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, RetryAfter: retryAfter}
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// ParseRetryAfter parses a Retry-After header, which holds either a number
+// of seconds or an HTTP date.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Retryable reports whether err is a transient failure: a retryable status,
+// a timeout, a reset or refused connection, a temporary DNS failure or a
+// body that ended early.
+func (p *RetryPolicy) Retryable(err error) bool {
+	if p == nil || err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		for _, code := range p.RetryableStatusCodes {
+			if code == statusErr.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// Backoff returns how long to wait after the given failed attempt. A
+// Retry-After sent with err takes precedence over the computed backoff.
+func (p *RetryPolicy) Backoff(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	wait := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		wait *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(wait)
+}
+
+// Do calls fn until it succeeds, fails with an error that is not retryable,
+// or runs out of attempts, sleeping between attempts as the policy
+// dictates. Attempts are numbered from 1. The last error is returned.
+func (p *RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || p == nil || attempt >= p.MaxAttempts || !p.Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.Backoff(attempt, err)
+		if p.MaxRetryAfter > 0 && wait > p.MaxRetryAfter {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 4 * time.Millisecond
+	policy.Jitter = 0
+	return policy
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"0", 0, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		wait, ok := ParseRetryAfter(tt.value, now)
+		if wait != tt.expected || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, wait, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{fmt.Errorf("failed to download file: %w", &StatusError{StatusCode: http.StatusTooManyRequests}), true},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{io.ErrUnexpectedEOF, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{context.Canceled, false},
+		{errors.New("disk full"), false},
+	}
+
+	for _, tt := range tests {
+		if result := policy.Retryable(tt.err); result != tt.expected {
+			t.Errorf("Retryable(%v) = %v; want %v", tt.err, result, tt.expected)
+		}
+	}
+
+	var nilPolicy *RetryPolicy
+	assert.False(t, nilPolicy.Retryable(io.ErrUnexpectedEOF))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := testPolicy()
+
+	assert.Equal(t, time.Millisecond, policy.Backoff(1, io.ErrUnexpectedEOF))
+	assert.Equal(t, 2*time.Millisecond, policy.Backoff(2, io.ErrUnexpectedEOF))
+	assert.Equal(t, 4*time.Millisecond, policy.Backoff(5, io.ErrUnexpectedEOF))
+
+	// Retry-After wins over the computed backoff
+	retryAfter := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second}
+	assert.Equal(t, 3*time.Second, policy.Backoff(1, retryAfter))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait := policy.Backoff(2, io.ErrUnexpectedEOF)
+		assert.True(t, wait >= time.Millisecond && wait <= 3*time.Millisecond, "jittered wait %v out of range", wait)
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := testPolicy()
+
+	calls := 0
+	err := policy.Do(context.Background(), func(attempt int) error {
+		calls++
+		assert.Equal(t, calls, attempt)
+		if attempt < 3 {
+			return &StatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Gives up after MaxAttempts
+	calls = 0
+	err = policy.Do(context.Background(), func(int) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, policy.MaxAttempts, calls)
+
+	// Does not retry permanent errors
+	calls = 0
+	err = policy.Do(context.Background(), func(int) error {
+		calls++
+		return &StatusError{StatusCode: http.StatusNotFound}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_DoGivesUpOnLongRetryAfter(t *testing.T) {
+	policy := testPolicy()
+	policy.MaxRetryAfter = time.Second
+
+	calls := 0
+	err := policy.Do(context.Background(), func(int) error {
+		calls++
+		return &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_DoStopsWhenCancelled(t *testing.T) {
+	policy := testPolicy()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := policy.Do(ctx, func(int) error {
+		calls++
+		cancel()
+		return io.ErrUnexpectedEOF
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_NilDoesNotRetry(t *testing.T) {
+	var policy *RetryPolicy
+
+	calls := 0
+	policy.Do(context.Background(), func(int) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	assert.Equal(t, 1, calls)
+}
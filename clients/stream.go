@@ -0,0 +1,65 @@
+package clients
+
+import "context"
+
+// StreamingURLProvider yields download entries one at a time, so lists far
+// larger than memory can be consumed lazily. The entries channel is closed
+// once the provider is exhausted or ctx is cancelled; the error channel then
+// delivers the error that stopped it, or nil.
+type StreamingURLProvider interface {
+	StreamEntries(ctx context.Context) (<-chan DownloadEntry, <-chan error)
+}
+
+// Stream adapts provider to a StreamingURLProvider. Providers that already
+// stream are returned unchanged; any other provider has its entries loaded
+// up front and replayed.
+func Stream(provider URLProvider) StreamingURLProvider {
+	if streaming, ok := provider.(StreamingURLProvider); ok {
+		return streaming
+	}
+	return sliceStream{provider: provider}
+}
+
+// sliceStream replays the entries of a non-streaming provider.
+type sliceStream struct {
+	provider URLProvider
+}
+
+func (s sliceStream) StreamEntries(ctx context.Context) (<-chan DownloadEntry, <-chan error) {
+	return ProduceEntries(ctx, func(emit func(DownloadEntry) error) error {
+		entries, err := Entries(s.provider)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ProduceEntries runs produce in its own goroutine, handing it an emit
+// function that blocks until the consumer takes the entry or ctx is done.
+// Providers use it to implement StreamEntries.
+func ProduceEntries(ctx context.Context, produce func(emit func(DownloadEntry) error) error) (<-chan DownloadEntry, <-chan error) {
+	entries := make(chan DownloadEntry)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		err := produce(func(entry DownloadEntry) error {
+			select {
+			case entries <- entry:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(entries)
+		errc <- err
+	}()
+
+	return entries, errc
+}
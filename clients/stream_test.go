@@ -0,0 +1,84 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collect drains a stream into a slice.
+func collect(provider StreamingURLProvider, ctx context.Context) ([]DownloadEntry, error) {
+	var entries []DownloadEntry
+	stream, errc := provider.StreamEntries(ctx)
+	for entry := range stream {
+		entries = append(entries, entry)
+	}
+	return entries, <-errc
+}
+
+func TestStream_AdaptsStaticProvider(t *testing.T) {
+	provider := &StaticURLProvider{URLs: []string{"https://example.com/a", "https://example.com/b"}}
+
+	entries, err := collect(Stream(provider), context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []DownloadEntry{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}}, entries)
+}
+
+func TestStream_ReportsProviderError(t *testing.T) {
+	provider := &StaticURLProvider{URLs: []string{"invalid-url"}}
+
+	_, err := collect(Stream(provider), context.Background())
+	assert.Error(t, err)
+}
+
+func TestFileURLProvider_StreamEntries(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "https://example.com/%d\n  out=file-%d\n", i, i)
+	}
+	provider := &FileURLProvider{Filename: "-", Stdin: strings.NewReader(input.String())}
+
+	entries, err := collect(provider, context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1000)
+	assert.Equal(t, "file-999", entries[999].Out)
+}
+
+func TestFileURLProvider_StreamEntriesParseError(t *testing.T) {
+	provider := &FileURLProvider{Filename: "-", Stdin: strings.NewReader("https://example.com/a\nnot-a-url\n")}
+
+	entries, err := collect(provider, context.Background())
+	assert.Len(t, entries, 1)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestFileURLProvider_StreamEntriesCancelled(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "https://example.com/%d\n", i)
+	}
+	provider := &FileURLProvider{Filename: "-", Stdin: strings.NewReader(input.String())}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, errc := provider.StreamEntries(ctx)
+	<-stream
+	cancel()
+	for range stream {
+	}
+	assert.ErrorIs(t, <-errc, context.Canceled)
+}
+
+func TestMultiURLProvider_StreamEntries(t *testing.T) {
+	provider := MultiURLProvider{
+		&StaticURLProvider{URLs: []string{"https://example.com/a"}},
+		&FileURLProvider{Filename: "-", Stdin: strings.NewReader("https://example.com/b\n")},
+	}
+
+	entries, err := collect(provider, context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "https://example.com/b", entries[1].URL)
+}
@@ -0,0 +1,257 @@
+package clients
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TransportConfig tunes the HTTP client shared by every download. Zero
+// durations and counts mean no limit.
+type TransportConfig struct {
+	// ConnectTimeout bounds establishing a TCP connection.
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers once a
+	// request has been sent. It does not limit reading the body.
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout is how long an unused connection is kept open.
+	IdleConnTimeout time.Duration
+	// MaxIdleConns and MaxIdleConnsPerHost cap the connections kept open
+	// for reuse, in total and per host.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// KeepAlive is the TCP keep-alive period. Negative disables TCP
+	// keep-alive probes.
+	KeepAlive time.Duration
+	// DisableHTTP2 sticks to HTTP/1.1, even with servers offering HTTP/2.
+	DisableHTTP2 bool
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool
+	// DisableCompression stops asking servers for gzip-compressed
+	// responses.
+	DisableCompression bool
+	// MaxRedirects is the most redirects followed per request. Zero keeps
+	// the net/http default of 10 and negative follows none.
+	MaxRedirects int
+	// Proxy picks the proxy of each request. Nil follows the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy *ProxyConfig
+}
+
+// DefaultTransportConfig returns the settings used unless configured
+// otherwise.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		ConnectTimeout:        30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		KeepAlive:             30 * time.Second,
+		MaxRedirects:          10,
+	}
+}
+
+// NewTransport builds an *http.Transport from the settings.
+func (c TransportConfig) NewTransport() (*http.Transport, error) {
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != nil {
+		var err error
+		if proxy, err = c.Proxy.ProxyFunc(); err != nil {
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: c.KeepAlive}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		DisableKeepAlives:     c.DisableKeepAlives,
+		DisableCompression:    c.DisableCompression,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+		ExpectContinueTimeout: time.Second,
+	}
+	if c.DisableHTTP2 {
+		// A non-nil empty map turns HTTP/2 off
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport, nil
+}
+
+// NewClient builds an *http.Client using a transport built from the
+// settings, which also serves the protocols registered so far.
+func (c TransportConfig) NewClient() (*http.Client, error) {
+	return c.newClient(nil)
+}
+
+// newClient builds a client serving protocols in addition to, or instead
+// of, the registered ones.
+func (c TransportConfig) newClient(protocols map[string]Protocol) (*http.Client, error) {
+	transport, err := c.NewTransport()
+	if err != nil {
+		return nil, err
+	}
+	installProtocols(transport, protocols)
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect(c.MaxRedirects),
+	}, nil
+}
+
+// checkRedirect returns a redirect policy following at most max
+// redirects. Redirects from http or https to other schemes, such as ftp
+// or file, are refused, as the server could otherwise point the client at
+// whatever else it can reach.
+func checkRedirect(max int) func(*http.Request, []*http.Request) error {
+	if max == 0 {
+		// The net/http default
+		max = 10
+	}
+	if max < 0 {
+		max = 0
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		previous := via[len(via)-1].URL
+		if isHTTP(previous.Scheme) && !isHTTP(req.URL.Scheme) {
+			return fmt.Errorf("refusing redirect from %s to %s", previous.Redacted(), req.URL.Redacted())
+		}
+		return nil
+	}
+}
+
+func isHTTP(scheme string) bool {
+	return strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")
+}
+
+// ClientBuilder assembles a RealHttpClient step by step, starting from
+// DefaultTransportConfig:
+//
+//	client, err := clients.NewClientBuilder().
+//		ConnectTimeout(5 * time.Second).
+//		MaxIdleConnsPerHost(4).
+//		HTTP2(false).
+//		Build()
+type ClientBuilder struct {
+	config     TransportConfig
+	middleware []Middleware
+	protocols  map[string]Protocol
+}
+
+// NewClientBuilder starts from the default settings.
+func NewClientBuilder() *ClientBuilder {
+	return &ClientBuilder{config: DefaultTransportConfig()}
+}
+
+// Config replaces all settings at once.
+func (b *ClientBuilder) Config(config TransportConfig) *ClientBuilder {
+	b.config = config
+	return b
+}
+
+func (b *ClientBuilder) ConnectTimeout(d time.Duration) *ClientBuilder {
+	b.config.ConnectTimeout = d
+	return b
+}
+
+func (b *ClientBuilder) TLSHandshakeTimeout(d time.Duration) *ClientBuilder {
+	b.config.TLSHandshakeTimeout = d
+	return b
+}
+
+func (b *ClientBuilder) ResponseHeaderTimeout(d time.Duration) *ClientBuilder {
+	b.config.ResponseHeaderTimeout = d
+	return b
+}
+
+func (b *ClientBuilder) IdleConnTimeout(d time.Duration) *ClientBuilder {
+	b.config.IdleConnTimeout = d
+	return b
+}
+
+func (b *ClientBuilder) MaxIdleConns(n int) *ClientBuilder {
+	b.config.MaxIdleConns = n
+	return b
+}
+
+func (b *ClientBuilder) MaxIdleConnsPerHost(n int) *ClientBuilder {
+	b.config.MaxIdleConnsPerHost = n
+	return b
+}
+
+func (b *ClientBuilder) KeepAlive(d time.Duration) *ClientBuilder {
+	b.config.KeepAlive = d
+	return b
+}
+
+// HTTP2 enables or disables HTTP/2.
+func (b *ClientBuilder) HTTP2(enabled bool) *ClientBuilder {
+	b.config.DisableHTTP2 = !enabled
+	return b
+}
+
+// KeepAlives enables or disables reusing connections.
+func (b *ClientBuilder) KeepAlives(enabled bool) *ClientBuilder {
+	b.config.DisableKeepAlives = !enabled
+	return b
+}
+
+// Compression enables or disables asking for compressed responses.
+func (b *ClientBuilder) Compression(enabled bool) *ClientBuilder {
+	b.config.DisableCompression = !enabled
+	return b
+}
+
+func (b *ClientBuilder) MaxRedirects(n int) *ClientBuilder {
+	b.config.MaxRedirects = n
+	return b
+}
+
+// Use adds middleware that every request goes through, in the order
+// added, before it reaches the transport.
+func (b *ClientBuilder) Use(middleware ...Middleware) *ClientBuilder {
+	b.middleware = append(b.middleware, middleware...)
+	return b
+}
+
+// Proxy sends requests through proxies as configured.
+func (b *ClientBuilder) Proxy(proxy ProxyConfig) *ClientBuilder {
+	b.config.Proxy = &proxy
+	return b
+}
+
+// Protocol serves URLs with the given scheme from protocol, instead of the
+// one registered for it. Local protocols, such as file, are only served by
+// clients they are given to this way. Middleware still sees those
+// requests.
+func (b *ClientBuilder) Protocol(scheme string, protocol Protocol) *ClientBuilder {
+	if b.protocols == nil {
+		b.protocols = map[string]Protocol{}
+	}
+	b.protocols[strings.ToLower(scheme)] = protocol
+	return b
+}
+
+// Build returns a client with the settings so far, failing if a proxy is
+// invalid. Every call builds a new connection pool, so build once and share
+// the client.
+func (b *ClientBuilder) Build() (*RealHttpClient, error) {
+	client, err := b.config.newClient(b.protocols)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = Chain(client.Transport, b.middleware...)
+	return &RealHttpClient{Client: client}, nil
+}
@@ -0,0 +1,96 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mustBuild builds a client, failing the test on error.
+func mustBuild(t *testing.T, builder *ClientBuilder) *RealHttpClient {
+	client, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Expected no error building the client, got %v", err)
+	}
+	return client
+}
+
+func TestClientBuilder_Build(t *testing.T) {
+	client := mustBuild(t, NewClientBuilder().
+		ConnectTimeout(time.Second).
+		TLSHandshakeTimeout(2*time.Second).
+		ResponseHeaderTimeout(3*time.Second).
+		IdleConnTimeout(4*time.Second).
+		MaxIdleConnsPerHost(2).
+		KeepAlives(false).
+		Compression(false).
+		HTTP2(false))
+
+	transport, ok := client.Client.Transport.(*http.Transport)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 3*time.Second, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 4*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 2, transport.MaxIdleConnsPerHost)
+	assert.True(t, transport.DisableKeepAlives)
+	assert.True(t, transport.DisableCompression)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+}
+
+func TestClientBuilder_HTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, enabled := range []bool{true, false} {
+		builder := NewClientBuilder().HTTP2(enabled)
+		client := mustBuild(t, builder)
+		client.Client.Transport.(*http.Transport).TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+
+		resp, err := client.Get(ts.URL)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		resp.Body.Close()
+		assert.Equal(t, enabled, resp.ProtoMajor == 2, "HTTP/2 enabled: %v, got %s", enabled, resp.Proto)
+	}
+}
+
+func TestClientBuilder_MaxRedirects(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next := map[string]string{"/": "/1", "/1": "/2", "/2": "/3"}[r.URL.Path]
+		http.Redirect(w, r, ts.URL+next, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	resp, err := mustBuild(t, NewClientBuilder().MaxRedirects(3)).Get(ts.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	_, err = mustBuild(t, NewClientBuilder().MaxRedirects(2)).Get(ts.URL)
+	assert.ErrorContains(t, err, "stopped after 2 redirects")
+
+	_, err = mustBuild(t, NewClientBuilder().MaxRedirects(-1)).Get(ts.URL)
+	assert.ErrorContains(t, err, "stopped after 0 redirects")
+}
+
+func TestRealHttpClient_SharesDefaultClient(t *testing.T) {
+	first, second := &RealHttpClient{}, &RealHttpClient{}
+	assert.Same(t, first.client(), second.client())
+}
@@ -2,7 +2,11 @@ package clients
 
 import (
 	"GoDownload/helpers"
+	"context"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
 )
 
 // URLProvider is an interface to provide URLs.
@@ -10,9 +14,111 @@ type URLProvider interface {
 	GetURLs() ([]string, error)
 }
 
-// FileURLProvider provides URLs from a file.
+// EntryProvider is implemented by URL providers that carry per-URL options
+// along with each URL.
+type EntryProvider interface {
+	URLProvider
+	GetEntries() ([]DownloadEntry, error)
+}
+
+// Entries returns the download entries of provider. Providers that only know
+// about URLs get one entry per URL with no options set.
+func Entries(provider URLProvider) ([]DownloadEntry, error) {
+	if entryProvider, ok := provider.(EntryProvider); ok {
+		return entryProvider.GetEntries()
+	}
+
+	urls, err := provider.GetURLs()
+	entries := make([]DownloadEntry, len(urls))
+	for i, u := range urls {
+		entries[i] = DownloadEntry{URL: u}
+	}
+	return entries, err
+}
+
+// FileURLProvider provides URLs from a file. Filename may also be a URL,
+// which is read with the protocol registered for its scheme.
 type FileURLProvider struct {
 	Filename string
+	// Stdin is read instead of a file when Filename is "-". It defaults to
+	// os.Stdin.
+	Stdin io.Reader
+	// Client, if set, fetches Filename when it is a URL instead of the
+	// registered protocol, so that its settings and middleware apply.
+	Client HttpClient
+}
+
+// GetEntries parses the input file. See ParseInputFile for the format.
+func (f *FileURLProvider) GetEntries() ([]DownloadEntry, error) {
+	var entries []DownloadEntry
+	err := f.scan(context.Background(), func(entry DownloadEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// StreamEntries parses the input file while it is being consumed, so only
+// the entries in flight are held in memory.
+func (f *FileURLProvider) StreamEntries(ctx context.Context) (<-chan DownloadEntry, <-chan error) {
+	return ProduceEntries(ctx, func(emit func(DownloadEntry) error) error {
+		return f.scan(ctx, emit)
+	})
+}
+
+// scan opens the input file and calls emit for each entry in it.
+func (f *FileURLProvider) scan(ctx context.Context, emit func(DownloadEntry) error) error {
+	file, err := f.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := scanInputFile(file, emit); err != nil {
+		return fmt.Errorf("%s: %w", f.Filename, err)
+	}
+	return nil
+}
+
+// open opens stdin, the local file or the URL named by Filename.
+func (f *FileURLProvider) open(ctx context.Context) (io.ReadCloser, error) {
+	if f.Filename == "-" {
+		stdin := f.Stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		return io.NopCloser(stdin), nil
+	}
+
+	// Single letters are Windows drives rather than schemes
+	if u, err := url.Parse(f.Filename); err == nil && len(u.Scheme) > 1 && helpers.IsValidURL(f.Filename) {
+		protocol, ok := LookupProtocol(u.Scheme)
+		// The list itself may be a local file, as its name comes from the
+		// user rather than a server
+		if f.Client != nil && !isLocalProtocol(u.Scheme) {
+			protocol, ok = &HTTPProtocol{Client: f.Client}, true
+		}
+		if ok {
+			return protocol.Open(ctx, u, 0, -1)
+		}
+	}
+	return os.Open(f.Filename)
+}
+
+// GetURLs returns the primary URL of every entry in the input file.
+func (f *FileURLProvider) GetURLs() ([]string, error) {
+	entries, err := f.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return urls, nil
 }
 
 type StaticURLProvider struct {
@@ -30,3 +136,84 @@ func (s *StaticURLProvider) GetURLs() ([]string, error) {
 	}
 	return validURLs, nil
 }
+
+// MultiURLProvider concatenates the entries of several providers, e.g. URLs
+// given on the command line and an input file.
+type MultiURLProvider []URLProvider
+
+func (m MultiURLProvider) GetEntries() ([]DownloadEntry, error) {
+	var all []DownloadEntry
+	for _, provider := range m {
+		entries, err := Entries(provider)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, entries...)
+	}
+	return all, nil
+}
+
+// StreamEntries streams the providers one after another.
+func (m MultiURLProvider) StreamEntries(ctx context.Context) (<-chan DownloadEntry, <-chan error) {
+	return ProduceEntries(ctx, func(emit func(DownloadEntry) error) error {
+		for _, provider := range m {
+			entries, errc := Stream(provider).StreamEntries(ctx)
+			for entry := range entries {
+				if err := emit(entry); err != nil {
+					return err
+				}
+			}
+			if err := <-errc; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m MultiURLProvider) GetURLs() ([]string, error) {
+	entries, err := m.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return urls, nil
+}
+
+// MappedURLProvider passes every entry of Provider through Map, e.g. to fill
+// in options that come from elsewhere.
+type MappedURLProvider struct {
+	Provider URLProvider
+	Map      func(DownloadEntry) DownloadEntry
+}
+
+func (m *MappedURLProvider) GetEntries() ([]DownloadEntry, error) {
+	entries, err := Entries(m.Provider)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		entries[i] = m.Map(entry)
+	}
+	return entries, nil
+}
+
+// StreamEntries maps the entries of Provider as they are streamed.
+func (m *MappedURLProvider) StreamEntries(ctx context.Context) (<-chan DownloadEntry, <-chan error) {
+	return ProduceEntries(ctx, func(emit func(DownloadEntry) error) error {
+		entries, errc := Stream(m.Provider).StreamEntries(ctx)
+		for entry := range entries {
+			if err := emit(m.Map(entry)); err != nil {
+				return err
+			}
+		}
+		return <-errc
+	})
+}
+
+func (m *MappedURLProvider) GetURLs() ([]string, error) {
+	return m.Provider.GetURLs()
+}
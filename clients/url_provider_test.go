@@ -1,7 +1,11 @@
 package clients
 
 import (
+	"context"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestStaticURLProvider_GetURLs(t *testing.T) {
@@ -17,3 +21,53 @@ func TestStaticURLProvider_GetURLs(t *testing.T) {
 		t.Fatalf("Expected error, got none")
 	}
 }
+
+func TestEntries_WrapsPlainURLs(t *testing.T) {
+	provider := &StaticURLProvider{URLs: []string{"https://example.com/a"}}
+
+	entries, err := Entries(provider)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(entries) != 1 || entries[0].URL != "https://example.com/a" {
+		t.Fatalf("Expected a single entry for the URL, got %+v", entries)
+	}
+}
+
+func TestMultiURLProvider_GetURLs(t *testing.T) {
+	provider := MultiURLProvider{
+		&StaticURLProvider{URLs: []string{"https://example.com/a"}},
+		&FileURLProvider{Filename: "-", Stdin: strings.NewReader("https://example.com/b\n")},
+	}
+
+	urls, err := provider.GetURLs()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(urls) != 2 || urls[0] != "https://example.com/a" || urls[1] != "https://example.com/b" {
+		t.Fatalf("Expected URLs of both providers in order, got %v", urls)
+	}
+}
+
+func TestMappedURLProvider(t *testing.T) {
+	provider := &MappedURLProvider{
+		Provider: &StaticURLProvider{URLs: []string{"https://example.com/a", "https://example.com/b"}},
+		Map: func(entry DownloadEntry) DownloadEntry {
+			entry.Out = "mapped-" + entry.URL[len(entry.URL)-1:]
+			return entry
+		},
+	}
+
+	entries, err := Entries(provider)
+	assert.NoError(t, err)
+	assert.Equal(t, "mapped-a", entries[0].Out)
+	assert.Equal(t, "mapped-b", entries[1].Out)
+
+	stream, errc := provider.StreamEntries(context.Background())
+	var streamed []DownloadEntry
+	for entry := range stream {
+		streamed = append(streamed, entry)
+	}
+	assert.NoError(t, <-errc)
+	assert.Equal(t, entries, streamed)
+}
@@ -0,0 +1,187 @@
+package crawl
+
+import (
+	"GoDownload/clients"
+	"GoDownload/helpers"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxPageSize is the most read of a single page or listing.
+const maxPageSize = 32 << 20
+
+// IndexProvider mirrors a tree of HTML directory listings, such as those
+// generated by Apache's mod_autoindex or nginx's autoindex. Starting at
+// Root, it follows links to subdirectories and yields every file below
+// Root, with Dir set to recreate the remote layout under the download
+// directory.
+type IndexProvider struct {
+	Client clients.HttpClient
+	// Root is the listing the crawl starts at. Links outside of it, such
+	// as the parent directory, are not followed.
+	Root *url.URL
+	// MaxDepth is how many levels of listings are read, counting Root as
+	// the first. Zero means no limit.
+	MaxDepth int
+	// Filter selects files by their path relative to Root.
+	Filter helpers.GlobFilter
+}
+
+// NewIndexProvider crawls the listing at root through client.
+func NewIndexProvider(client clients.HttpClient, root string) (*IndexProvider, error) {
+	u, err := url.Parse(root)
+	if err != nil || !helpers.IsValidURL(root) {
+		return nil, fmt.Errorf("invalid listing URL %q", root)
+	}
+	u.Fragment, u.RawFragment = "", ""
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return &IndexProvider{Client: client, Root: u}, nil
+}
+
+func (p *IndexProvider) GetEntries() ([]clients.DownloadEntry, error) {
+	var entries []clients.DownloadEntry
+	err := p.crawl(context.Background(), func(entry clients.DownloadEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// StreamEntries yields the files of a listing while the listings below it
+// are still being read.
+func (p *IndexProvider) StreamEntries(ctx context.Context) (<-chan clients.DownloadEntry, <-chan error) {
+	return clients.ProduceEntries(ctx, func(emit func(clients.DownloadEntry) error) error {
+		return p.crawl(ctx, emit)
+	})
+}
+
+func (p *IndexProvider) GetURLs() ([]string, error) {
+	entries, err := p.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return urls, nil
+}
+
+// listing is a directory or page waiting to be read.
+type listing struct {
+	url   *url.URL
+	depth int
+}
+
+// crawl reads the listings breadth first and calls emit for every selected
+// file.
+func (p *IndexProvider) crawl(ctx context.Context, emit func(clients.DownloadEntry) error) error {
+	queue := []listing{{url: p.Root, depth: 1}}
+	seen := map[string]bool{p.Root.Path: true}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		page, err := fetchPage(ctx, p.Client, current.url)
+		if err != nil {
+			return err
+		}
+		if !page.html {
+			return fmt.Errorf("%s is not a directory listing", current.url.Redacted())
+		}
+		for _, link := range page.links {
+			if link.Tag != "a" || !p.below(link.URL) || seen[link.URL.Path] {
+				continue
+			}
+			seen[link.URL.Path] = true
+
+			if strings.HasSuffix(link.URL.Path, "/") {
+				if p.MaxDepth <= 0 || current.depth < p.MaxDepth {
+					queue = append(queue, listing{url: link.URL, depth: current.depth + 1})
+				}
+				continue
+			}
+			relative := strings.TrimPrefix(link.URL.Path, p.Root.Path)
+			if !p.Filter.Match(relative) {
+				continue
+			}
+			entry := clients.DownloadEntry{
+				URL: link.URL.String(),
+				Dir: helpers.SanitizeRelativePath(path.Dir(relative)),
+				Out: helpers.SanitizeFileName(path.Base(relative)),
+			}
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// below reports whether u is inside Root. Links with a query, such as the
+// column sorting links of listings, are left out.
+func (p *IndexProvider) below(u *url.URL) bool {
+	return u.Scheme == p.Root.Scheme && u.Host == p.Root.Host && u.RawQuery == "" &&
+		len(u.Path) > len(p.Root.Path) && strings.HasPrefix(u.Path, p.Root.Path)
+}
+
+// page is a fetched document and the links in it.
+type page struct {
+	// url is where the document was found, after redirects.
+	url *url.URL
+	// mediaType is the type of the document, without parameters.
+	mediaType string
+	html      bool
+	links     []Link
+}
+
+// fetchPage gets u and extracts the links if it is HTML.
+func fetchPage(ctx context.Context, client clients.HttpClient, u *url.URL) (*page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status)
+	}
+
+	result := &page{url: u}
+	if resp.Request != nil && resp.Request.URL != nil {
+		result.url = resp.Request.URL
+	}
+	result.mediaType = mediaType(resp)
+	if result.mediaType != "text/html" && result.mediaType != "application/xhtml+xml" {
+		return result, nil
+	}
+	result.html = true
+	result.links, err = ExtractLinks(result.url, io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", u.Redacted(), err)
+	}
+	return result, nil
+}
+
+// mediaType returns the Content-Type of resp without parameters.
+func mediaType(resp *http.Response) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType
+}
@@ -0,0 +1,182 @@
+package crawl
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"GoDownload/helpers"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// autoindex serves files under /pub/ with listings in the style of
+// Apache's mod_autoindex, and records the listings read.
+type autoindex struct {
+	*httptest.Server
+	files map[string]string
+
+	mu       sync.Mutex
+	listings []string
+}
+
+func newAutoindex(t *testing.T, files map[string]string) *autoindex {
+	s := &autoindex{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *autoindex) serve(w http.ResponseWriter, r *http.Request) {
+	if content, ok := s.files[r.URL.Path]; ok {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(content))
+		return
+	}
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		// Like Apache, directories without their slash are redirected
+		for name := range s.files {
+			if strings.HasPrefix(name, dir+"/") {
+				http.Redirect(w, r, dir+"/", http.StatusMovedPermanently)
+				return
+			}
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	children := map[string]bool{}
+	for name := range s.files {
+		if rest, ok := strings.CutPrefix(name, dir); ok {
+			child, _, isDir := strings.Cut(rest, "/")
+			if isDir {
+				child += "/"
+			}
+			children[child] = true
+		}
+	}
+	if len(children) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.listings = append(s.listings, dir)
+	s.mu.Unlock()
+
+	names := make([]string, 0, len(children))
+	for child := range children {
+		names = append(names, child)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	fmt.Fprintf(w, `<html><head><title>Index of %s</title></head><body><h1>Index of %s</h1><table>
+<tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th></tr>
+<tr><td><a href="%s">Parent Directory</a></td></tr>
+`, dir, dir, path.Dir(strings.TrimSuffix(dir, "/"))+"/")
+	for _, name := range names {
+		fmt.Fprintf(w, "<tr><td><a href=\"%s\">%s</a></td></tr>\n", strings.ReplaceAll(name, " ", "%20"), name)
+	}
+	fmt.Fprint(w, "</table></body></html>")
+}
+
+var treeFiles = map[string]string{
+	"/pub/readme.txt":              "readme",
+	"/pub/isos/disk one.iso":       "disk one",
+	"/pub/isos/beta/disk2.iso":     "disk two",
+	"/pub/isos/beta/notes.txt":     "notes",
+	"/pub/src/deep/er/main.go":     "package main",
+	"/outside/secret.txt":          "secret",
+	"/pub-other/not-below-pub.txt": "sibling",
+}
+
+func entryPaths(entries []clients.DownloadEntry) []string {
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, path.Join(entry.Dir, entry.Out))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestIndexProvider_GetEntries(t *testing.T) {
+	server := newAutoindex(t, treeFiles)
+
+	provider, err := NewIndexProvider(&clients.RealHttpClient{}, server.URL+"/pub")
+	assert.NoError(t, err)
+	entries, err := provider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"isos/beta/disk2.iso", "isos/beta/notes.txt", "isos/disk one.iso", "readme.txt", "src/deep/er/main.go"}, entryPaths(entries))
+	for _, entry := range entries {
+		if entry.Out == "disk one.iso" {
+			assert.Equal(t, server.URL+"/pub/isos/disk%20one.iso", entry.URL)
+		}
+	}
+	// Every listing is read once
+	assert.Len(t, server.listings, 6)
+}
+
+func TestIndexProvider_DepthAndFilter(t *testing.T) {
+	server := newAutoindex(t, treeFiles)
+
+	provider, err := NewIndexProvider(&clients.RealHttpClient{}, server.URL+"/pub/")
+	assert.NoError(t, err)
+	provider.MaxDepth = 2
+	entries, err := provider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"isos/disk one.iso", "readme.txt"}, entryPaths(entries))
+	assert.ElementsMatch(t, []string{"/pub/", "/pub/isos/", "/pub/src/"}, server.listings)
+
+	provider.MaxDepth = 0
+	provider.Filter = helpers.GlobFilter{Include: []string{"*.iso"}, Exclude: []string{"isos/beta/**"}}
+	entries, err = provider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"isos/disk one.iso"}, entryPaths(entries))
+}
+
+func TestIndexProvider_Errors(t *testing.T) {
+	server := newAutoindex(t, treeFiles)
+
+	_, err := NewIndexProvider(&clients.RealHttpClient{}, "not a url")
+	assert.Error(t, err)
+
+	provider, _ := NewIndexProvider(&clients.RealHttpClient{}, server.URL+"/missing/")
+	_, err = provider.GetEntries()
+	assert.ErrorContains(t, err, "404")
+
+	provider, _ = NewIndexProvider(&clients.RealHttpClient{}, server.URL+"/pub/readme.txt")
+	_, err = provider.GetEntries()
+	assert.Error(t, err)
+}
+
+func TestIndexProvider_Mirror(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+	server := newAutoindex(t, treeFiles)
+
+	provider, err := NewIndexProvider(&clients.RealHttpClient{}, server.URL+"/pub/")
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	assert.NoError(t, downloader.New(&clients.RealHttpClient{}).DownloadStream(provider, dir, 2, ctx))
+
+	for name, content := range treeFiles {
+		relative, below := strings.CutPrefix(name, "/pub/")
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(relative)))
+		if below {
+			assert.NoError(t, err, relative)
+			assert.Equal(t, content, string(got), relative)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package crawl finds files to download by following the links of HTML
+// pages: IndexProvider mirrors trees of directory listings and Spider
+// harvests the files linked from web pages.
+package crawl
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// linkAttributes lists the attributes holding links, by element.
+var linkAttributes = map[string][]string{
+	"a":      {"href"},
+	"area":   {"href"},
+	"link":   {"href"},
+	"img":    {"src"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"embed":  {"src"},
+	"source": {"src"},
+	"track":  {"src"},
+	"audio":  {"src"},
+	"video":  {"src", "poster"},
+	"object": {"data"},
+}
+
+// Link is a reference found in an HTML page.
+type Link struct {
+	URL *url.URL
+	// Tag is the element the link was found in, such as a or img.
+	Tag string
+}
+
+// ExtractLinks returns the links of the HTML document in r, resolved
+// against base, or against the <base> of the document if it has one.
+// Fragments are dropped; links that do not parse are skipped.
+func ExtractLinks(base *url.URL, r io.Reader) ([]Link, error) {
+	var links []Link
+	baseSet := false
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return links, err
+			}
+			return links, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "base" && !baseSet {
+				if href, ok := attribute(token, "href"); ok {
+					if resolved, err := base.Parse(href); err == nil {
+						base, baseSet = resolved, true
+					}
+				}
+				continue
+			}
+			for _, name := range linkAttributes[token.Data] {
+				value, ok := attribute(token, name)
+				if !ok || value == "" {
+					continue
+				}
+				resolved, err := base.Parse(value)
+				if err != nil {
+					continue
+				}
+				resolved.Fragment, resolved.RawFragment = "", ""
+				links = append(links, Link{URL: resolved, Tag: token.Data})
+			}
+		}
+	}
+}
+
+// attribute returns the trimmed value of the attribute of token with the
+// given name.
+func attribute(token html.Token, name string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return strings.TrimSpace(attr.Val), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,51 @@
+package crawl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := url.Parse("https://example.com/docs/index.html")
+	document := `<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="style.css">
+<script src="/js/app.js"></script>
+</head><body>
+<a href="guide.html#intro">Guide</a>
+<a href=" ../about/ ">About</a>
+<a>No link</a>
+<img src="https://cdn.example.com/logo.png"/>
+<video src="clip.mp4" poster="clip.jpg"></video>
+<a href="http://[::1">Broken</a>
+</body></html>`
+
+	links, err := ExtractLinks(base, strings.NewReader(document))
+	assert.NoError(t, err)
+	var got []string
+	for _, link := range links {
+		got = append(got, link.Tag+" "+link.URL.String())
+	}
+	assert.Equal(t, []string{
+		"link https://example.com/docs/style.css",
+		"script https://example.com/js/app.js",
+		"a https://example.com/docs/guide.html",
+		"a https://example.com/about/",
+		"img https://cdn.example.com/logo.png",
+		"video https://example.com/docs/clip.mp4",
+		"video https://example.com/docs/clip.jpg",
+	}, got)
+}
+
+func TestExtractLinks_Base(t *testing.T) {
+	base, _ := url.Parse("https://example.com/page.html")
+	document := `<head><base href="https://mirror.example.com/files/"></head><a href="data.csv">Data</a>`
+
+	links, err := ExtractLinks(base, strings.NewReader(document))
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+	assert.Equal(t, "https://mirror.example.com/files/data.csv", links[0].URL.String())
+}
@@ -0,0 +1,142 @@
+package crawl
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots holds the rules of a robots.txt file that apply to one user
+// agent, as specified by RFC 9309, and its Crawl-delay.
+type robots struct {
+	rules []robotsRule
+	delay time.Duration
+}
+
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// allowAll is used for sites without robots.txt.
+var allowAll = &robots{}
+
+// disallowAll is used for sites whose robots.txt cannot be read.
+var disallowAll = &robots{rules: []robotsRule{{pattern: "/"}}}
+
+// agentName returns the product name of a User-Agent, such as MyBot for
+// MyBot/1.0 (+https://example.com/bot), which robots.txt names agents by.
+func agentName(agent string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(agent), "/")
+	name, _, _ = strings.Cut(name, " ")
+	return name
+}
+
+// parseRobots reads the group of robots.txt meant for agent, or the one for
+// every agent (*) if there is none. Groups naming the same agent are
+// merged.
+func parseRobots(r io.Reader, agent string) *robots {
+	agent = strings.ToLower(agent)
+	var specific, general robots
+	var haveSpecific bool
+	// The groups the lines being read belong to
+	var inSpecific, inGeneral bool
+	// Consecutive user-agent lines start a single group
+	grouping := false
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if !grouping {
+				inSpecific, inGeneral = false, false
+				grouping = true
+			}
+			switch name := strings.ToLower(value); {
+			case name == "*":
+				inGeneral = true
+			case name == agent:
+				inSpecific, haveSpecific = true, true
+			}
+			continue
+		}
+		grouping = false
+
+		var targets []*robots
+		if inSpecific {
+			targets = append(targets, &specific)
+		}
+		if inGeneral {
+			targets = append(targets, &general)
+		}
+		for _, target := range targets {
+			switch key {
+			case "allow", "disallow":
+				// An empty Disallow allows everything
+				if value != "" {
+					target.rules = append(target.rules, robotsRule{pattern: value, allow: key == "allow"})
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					target.delay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+	if haveSpecific {
+		return &specific
+	}
+	return &general
+}
+
+// maxRobotsSize is the most read of a robots.txt file, as RFC 9309 allows.
+const maxRobotsSize = 500 << 10
+
+// allowed reports whether the path, with its query, may be fetched. The
+// longest matching rule decides, and Allow wins a tie.
+func (r *robots) allowed(pathAndQuery string) bool {
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !matchRobotsPattern(rule.pattern, pathAndQuery) {
+			continue
+		}
+		if length := len(rule.pattern); length > longest || (length == longest && rule.allow) {
+			allowed, longest = rule.allow, length
+		}
+	}
+	return allowed
+}
+
+// matchRobotsPattern matches a path against a rule, in which * stands for
+// any sequence of characters and a trailing $ anchors the end.
+func matchRobotsPattern(pattern string, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(target, parts[0]) {
+		return false
+	}
+	position := len(parts[0])
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(target[position:], part)
+		}
+		index := strings.Index(target[position:], part)
+		if index < 0 {
+			return false
+		}
+		position += index + len(part)
+	}
+	return !anchored || position == len(target)
+}
@@ -0,0 +1,102 @@
+package crawl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const robotsTxt = `# Comments and unknown lines are ignored
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.php$
+Disallow: /search?*q=
+Crawl-delay: 2
+
+User-agent: OtherBot
+User-agent: GoDownload
+Disallow: /
+Allow: /data/
+Crawl-delay: 0.5
+
+user-agent: godownload # groups for one agent are merged
+allow: /open/
+`
+
+func TestParseRobots(t *testing.T) {
+	general := parseRobots(strings.NewReader(robotsTxt), "SomeBot")
+	assert.Equal(t, 2*time.Second, general.delay)
+	for target, want := range map[string]bool{
+		"/":                         true,
+		"/private":                  true,
+		"/private/x":                false,
+		"/private/public/x":         true,
+		"/index.php":                false,
+		"/index.php?page=2":         true,
+		"/search?lang=en&q=go":      false,
+		"/search?lang=en":           true,
+		"/data/private/public.html": true,
+	} {
+		assert.Equal(t, want, general.allowed(target), target)
+	}
+
+	specific := parseRobots(strings.NewReader(robotsTxt), "GoDownload")
+	assert.Equal(t, 500*time.Millisecond, specific.delay)
+	for target, want := range map[string]bool{
+		"/":           false,
+		"/private/":   false,
+		"/data/a.csv": true,
+		"/open/b.csv": true,
+	} {
+		assert.Equal(t, want, specific.allowed(target), target)
+	}
+
+	empty := parseRobots(strings.NewReader("User-agent: *\nDisallow:\n"), "GoDownload")
+	assert.True(t, empty.allowed("/anything"))
+	assert.Zero(t, empty.delay)
+}
+
+func TestRobots_Allowed(t *testing.T) {
+	// The longest rule wins, and Allow wins a tie
+	rules := &robots{rules: []robotsRule{
+		{pattern: "/page", allow: false},
+		{pattern: "/*.html", allow: true},
+		{pattern: "/tie", allow: false},
+		{pattern: "/tie", allow: true},
+	}}
+	assert.False(t, rules.allowed("/page/list"))
+	assert.True(t, rules.allowed("/page/list.html"))
+	assert.True(t, rules.allowed("/tie"))
+
+	assert.True(t, allowAll.allowed("/x"))
+	assert.False(t, disallowAll.allowed("/x"))
+}
+
+func TestMatchRobotsPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		target  string
+		want    bool
+	}{
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.html", false},
+		{"/fish*", "/fishheads/yummy.html", true},
+		{"/fish/", "/fish", false},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php/", false},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/a*b*c$", "/a-b-c-c", true},
+		{"/a*b*c$", "/a-b-c-d", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+	} {
+		assert.Equal(t, test.want, matchRobotsPattern(test.pattern, test.target), "%s %s", test.pattern, test.target)
+	}
+}
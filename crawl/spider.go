@@ -0,0 +1,500 @@
+package crawl
+
+import (
+	"GoDownload/clients"
+	"GoDownload/helpers"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultUserAgent is the agent Spider sends and looks for in robots.txt
+// unless told otherwise.
+const DefaultUserAgent = "GoDownload"
+
+// pageTags are the elements whose links may lead to further pages. Links
+// of other elements, such as img or script, are only ever files.
+var pageTags = map[string]bool{"a": true, "area": true, "iframe": true, "frame": true}
+
+// pageExtensions are the extensions of paths that may be pages. Links to
+// paths with other extensions are taken for files and not read.
+var pageExtensions = map[string]bool{
+	"": true, ".htm": true, ".html": true, ".xhtml": true, ".shtml": true,
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".cgi": true,
+}
+
+// Spider harvests the files linked from web pages. Starting at Seeds, it
+// reads HTML pages breadth first, follows their links within Hosts and
+// Prefixes, and yields the linked files selected by Extensions and
+// MIMETypes. It obeys robots.txt, including Crawl-delay, and visits every
+// URL once, comparing them after normalising.
+//
+// Files are laid out as host/path under the download directory, and named
+// as the downloader would name them.
+type Spider struct {
+	Client clients.HttpClient
+	Seeds  []*url.URL
+	// Hosts limits pages and files to these hosts. A leading dot, as in
+	// .example.com, admits subdomains too. It defaults to the hosts of
+	// Seeds.
+	Hosts []string
+	// Prefixes limits pages and files to URL paths starting with one of
+	// them. Empty means any path.
+	Prefixes []string
+	// Extensions selects files by the end of their path, such as .csv or
+	// .tar.gz, without fetching them.
+	Extensions []string
+	// MIMETypes selects files by media type, such as text/csv or
+	// application/*, which takes a request for every candidate link. With
+	// neither Extensions nor MIMETypes, every linked resource that is not
+	// an HTML page is selected.
+	MIMETypes []string
+	// Filter selects files by their host and path, e.g. example.com/data/*.
+	Filter helpers.GlobFilter
+	// MaxDepth is how many levels of pages are read, counting Seeds as the
+	// first. Zero means no limit.
+	MaxDepth int
+	// MaxPages stops the crawl after reading this many pages. Zero means
+	// no limit.
+	MaxPages int
+	// Delay is the least time between requests to one host. A longer
+	// Crawl-delay in robots.txt takes precedence.
+	Delay time.Duration
+	// UserAgent, such as MyBot/1.0, is sent with every request that does
+	// not carry a User-Agent already, and the rules of robots.txt are
+	// looked up for its name, MyBot. It defaults to DefaultUserAgent.
+	UserAgent string
+	// IgnoreRobots skips robots.txt altogether.
+	IgnoreRobots bool
+}
+
+// NewSpider crawls from the seed pages through client.
+func NewSpider(client clients.HttpClient, seeds ...string) (*Spider, error) {
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("no pages to crawl")
+	}
+	spider := &Spider{Client: client}
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page URL %q", seed)
+		}
+		normalized, ok := normalizeURL(u)
+		if !ok {
+			return nil, fmt.Errorf("invalid page URL %q", seed)
+		}
+		spider.Seeds = append(spider.Seeds, normalized)
+	}
+	return spider, nil
+}
+
+func (s *Spider) GetEntries() ([]clients.DownloadEntry, error) {
+	var entries []clients.DownloadEntry
+	err := s.crawl(context.Background(), func(entry clients.DownloadEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// StreamEntries yields files as soon as they are found, while the crawl
+// goes on.
+func (s *Spider) StreamEntries(ctx context.Context) (<-chan clients.DownloadEntry, <-chan error) {
+	return clients.ProduceEntries(ctx, func(emit func(clients.DownloadEntry) error) error {
+		return s.crawl(ctx, emit)
+	})
+}
+
+func (s *Spider) GetURLs() ([]string, error) {
+	entries, err := s.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return urls, nil
+}
+
+// crawler is the state of one crawl.
+type crawler struct {
+	*Spider
+	ctx  context.Context
+	emit func(clients.DownloadEntry) error
+	// client is Client, sending agent as the User-Agent.
+	client clients.HttpClient
+	agent  string
+
+	hosts      []string
+	extensions []string
+	seen       map[string]bool
+	// robots holds the rules of every origin met, by scheme://host.
+	robots map[string]*robots
+	// last is when each host was last sent a request.
+	last  map[string]time.Time
+	pages int
+}
+
+// crawl reads the pages breadth first and calls emit for every selected
+// file. A seed that cannot be read fails the crawl; other pages are
+// skipped.
+func (s *Spider) crawl(ctx context.Context, emit func(clients.DownloadEntry) error) error {
+	c := &crawler{
+		Spider: s,
+		ctx:    ctx,
+		emit:   emit,
+		agent:  s.UserAgent,
+		hosts:  s.Hosts,
+		seen:   map[string]bool{},
+		robots: map[string]*robots{},
+		last:   map[string]time.Time{},
+	}
+	if c.agent == "" {
+		c.agent = DefaultUserAgent
+	}
+	c.client = clients.WithHeader(s.Client, http.Header{"User-Agent": {c.agent}})
+	if len(c.hosts) == 0 {
+		for _, seed := range s.Seeds {
+			c.hosts = append(c.hosts, seed.Hostname())
+		}
+	}
+	for _, extension := range s.Extensions {
+		extension = strings.ToLower(extension)
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		c.extensions = append(c.extensions, extension)
+	}
+
+	var queue []listing
+	for _, seed := range s.Seeds {
+		if !c.seen[seed.String()] {
+			c.seen[seed.String()] = true
+			queue = append(queue, listing{url: seed, depth: 1})
+		}
+	}
+	for len(queue) > 0 {
+		if s.MaxPages > 0 && c.pages >= s.MaxPages {
+			break
+		}
+		current := queue[0]
+		queue = queue[1:]
+
+		allowed, err := c.allowed(current.url)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			c.debug("Skipping page disallowed by robots.txt", current.url)
+			continue
+		}
+		if err := c.wait(current.url); err != nil {
+			return err
+		}
+		page, err := fetchPage(ctx, c.client, current.url)
+		if err != nil {
+			if ctx.Err() != nil || current.depth == 1 {
+				return err
+			}
+			c.warn("Skipping page", current.url, err)
+			continue
+		}
+		c.pages++
+
+		if !page.html {
+			// The link turned out to be a file
+			if c.wantType(page.mediaType) {
+				if err := c.file(current.url); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Pages redirected out of scope are left, unless they are seeds
+		final, ok := normalizeURL(page.url)
+		if !ok || (current.depth > 1 && !c.inScope(final)) {
+			continue
+		}
+		c.seen[final.String()] = true
+
+		for _, link := range page.links {
+			u, ok := normalizeURL(link.URL)
+			if !ok || c.seen[u.String()] {
+				continue
+			}
+			c.seen[u.String()] = true
+			if !c.inScope(u) {
+				continue
+			}
+
+			switch {
+			case c.wantExtension(u):
+				err = c.file(u)
+			case pageTags[link.Tag] && pageExtensions[strings.ToLower(path.Ext(u.Path))] &&
+				(s.MaxDepth <= 0 || current.depth < s.MaxDepth):
+				queue = append(queue, listing{url: u, depth: current.depth + 1})
+			case len(s.MIMETypes) > 0 || len(c.extensions) == 0:
+				err = c.probe(u)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file emits u if robots.txt and Filter allow it.
+func (c *crawler) file(u *url.URL) error {
+	relative := u.Host + u.Path
+	if !c.Filter.Match(relative) {
+		return nil
+	}
+	allowed, err := c.allowed(u)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		c.debug("Skipping file disallowed by robots.txt", u)
+		return nil
+	}
+	return c.emit(clients.DownloadEntry{
+		URL: u.String(),
+		Dir: helpers.SanitizeRelativePath(path.Join(u.Host, path.Dir(u.Path))),
+	})
+}
+
+// probe asks for the type of a link that is not read as a page, and emits
+// it if the type is wanted.
+func (c *crawler) probe(u *url.URL) error {
+	allowed, err := c.allowed(u)
+	if err != nil || !allowed {
+		return err
+	}
+	if err := c.wait(u); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(c.ctx, req)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+		c.warn("Skipping link", u, err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		c.warn("Skipping link", u, fmt.Errorf("%s", resp.Status))
+		return nil
+	}
+	if !c.wantType(mediaType(resp)) {
+		return nil
+	}
+	return c.file(u)
+}
+
+// inScope reports whether u is on an allowed host and below an allowed
+// path prefix.
+func (c *crawler) inScope(u *url.URL) bool {
+	host := u.Hostname()
+	hostAllowed := false
+	for _, allowed := range c.hosts {
+		allowed = strings.ToLower(allowed)
+		if host == strings.TrimPrefix(allowed, ".") || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			hostAllowed = true
+			break
+		}
+	}
+	if !hostAllowed {
+		return false
+	}
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *crawler) wantExtension(u *url.URL) bool {
+	lower := strings.ToLower(u.Path)
+	for _, extension := range c.extensions {
+		if strings.HasSuffix(lower, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantType reports whether a resource of the given media type is a file to
+// download.
+func (c *crawler) wantType(mediaType string) bool {
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return false
+	}
+	if len(c.MIMETypes) == 0 {
+		return len(c.extensions) == 0
+	}
+	for _, pattern := range c.MIMETypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "*" || pattern == "*/*":
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")):
+			return true
+		case pattern == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether robots.txt lets u be fetched, reading it on the
+// first visit to its origin.
+func (c *crawler) allowed(u *url.URL) (bool, error) {
+	if c.IgnoreRobots {
+		return true, nil
+	}
+	origin := u.Scheme + "://" + u.Host
+	rules, ok := c.robots[origin]
+	if !ok {
+		var err error
+		rules, err = c.fetchRobots(origin)
+		if err != nil {
+			return false, err
+		}
+		c.robots[origin] = rules
+	}
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	return rules.allowed(target), nil
+}
+
+// fetchRobots reads the robots.txt of origin. As RFC 9309 asks, a missing
+// file allows everything and one that cannot be read allows nothing.
+func (c *crawler) fetchRobots(origin string) (*robots, error) {
+	u, err := url.Parse(origin + "/robots.txt")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.wait(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(c.ctx, req)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return nil, c.ctx.Err()
+		}
+		c.warn("Cannot read robots.txt, skipping the site", u, err)
+		return disallowAll, nil
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return parseRobots(resp.Body, agentName(c.agent)), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return allowAll, nil
+	}
+	c.warn("Cannot read robots.txt, skipping the site", u, fmt.Errorf("%s", resp.Status))
+	return disallowAll, nil
+}
+
+// wait holds off until the next request to the host of u is due.
+func (c *crawler) wait(u *url.URL) error {
+	delay := c.Delay
+	if rules := c.robots[u.Scheme+"://"+u.Host]; rules != nil && rules.delay > delay {
+		delay = rules.delay
+	}
+	if last, ok := c.last[u.Host]; ok {
+		if remaining := time.Until(last.Add(delay)); remaining > 0 {
+			timer := time.NewTimer(remaining)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-c.ctx.Done():
+				return c.ctx.Err()
+			}
+		}
+	}
+	c.last[u.Host] = time.Now()
+	return nil
+}
+
+func (c *crawler) warn(message string, u *url.URL, err error) {
+	if sugar, ok := c.ctx.Value("sugar").(*zap.SugaredLogger); ok {
+		sugar.Warnw(message, "url", u.Redacted(), "error", err)
+	}
+}
+
+func (c *crawler) debug(message string, u *url.URL) {
+	if sugar, ok := c.ctx.Value("sugar").(*zap.SugaredLogger); ok {
+		sugar.Debugw(message, "url", u.Redacted())
+	}
+}
+
+// normalizeURL returns u in a canonical form, so that different spellings
+// of one address compare equal: the scheme and host are lower case,
+// default ports, fragments and dot segments are dropped, and the query is
+// sorted. Only http and https URLs are accepted.
+func normalizeURL(u *url.URL) (*url.URL, bool) {
+	normalized := *u
+	normalized.Scheme = strings.ToLower(u.Scheme)
+	if normalized.Scheme != "http" && normalized.Scheme != "https" || u.Opaque != "" {
+		return nil, false
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if host == "" {
+		return nil, false
+	}
+	if (normalized.Scheme == "http" && port == "80") || (normalized.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	normalized.Host = host
+	if port != "" {
+		normalized.Host += ":" + port
+	}
+	normalized.Fragment, normalized.RawFragment = "", ""
+
+	// Escaped slashes would be lost by cleaning, so such paths stay as they are
+	if !strings.Contains(strings.ToUpper(u.RawPath), "%2F") {
+		cleaned := path.Clean("/" + u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		normalized.Path, normalized.RawPath = cleaned, ""
+	}
+	if u.RawQuery != "" {
+		normalized.RawQuery = u.Query().Encode()
+	}
+	normalized.ForceQuery = false
+	return &normalized, true
+}
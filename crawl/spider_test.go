@@ -0,0 +1,355 @@
+package crawl
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"GoDownload/helpers"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// resource is a document served by a site.
+type resource struct {
+	contentType string
+	body        string
+}
+
+// site serves resources by path, with query, and records the requests.
+type site struct {
+	*httptest.Server
+	resources map[string]resource
+
+	mu       sync.Mutex
+	requests []string
+	agents   []string
+	times    []time.Time
+}
+
+func newSite(t *testing.T, resources map[string]resource) *site {
+	s := &site{resources: resources}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *site) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	s.agents = append(s.agents, r.UserAgent())
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+
+	if target, ok := strings.CutPrefix(r.URL.Path, "/redirect"); ok {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	resource, ok := s.resources[r.URL.RequestURI()]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if resource.contentType == "error" {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", resource.contentType)
+	w.Write([]byte(strings.ReplaceAll(resource.body, "SITE", s.URL)))
+}
+
+func (s *site) requested(method string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []string
+	for _, request := range s.requests {
+		if target, ok := strings.CutPrefix(request, method+" "); ok {
+			requests = append(requests, target)
+		}
+	}
+	sort.Strings(requests)
+	return requests
+}
+
+func htmlPage(body string) resource {
+	return resource{contentType: "text/html; charset=utf-8", body: "<html><body>" + body + "</body></html>"}
+}
+
+// datasetSite publishes files linked from pages, in several spellings.
+func datasetSite(other string) map[string]resource {
+	return map[string]resource{
+		"/robots.txt": {contentType: "text/plain", body: "User-agent: *\nDisallow: /private/\n"},
+		"/": htmlPage(`<a href="/datasets/">Datasets</a> <a href="/about.html#team">About</a>
+			<img src="/logo.png"> <a href="mailto:data@example.com">Mail</a>
+			<a href="` + other + `/elsewhere.csv">Mirror</a> <a href="/private/hidden.csv">Hidden</a>`),
+		"/about.html": htmlPage(`<a href="SITE/">Home</a> <a href="/datasets/./../datasets/">Datasets</a>`),
+		"/datasets/": htmlPage(`<a href="2023.csv">2023</a> <a href="2024.CSV#top">2024</a>
+			<a href="/datasets/2023.csv">Again</a> <a href="export?year=2024&format=csv">Export</a>
+			<a href="export?format=csv&year=2024">Same export</a> <a href="details/">Details</a>
+			<a href="archive.zip">Archive</a> <a href="missing.html">Broken</a>`),
+		"/datasets/details/":                    htmlPage(`<a href="readme.txt">Readme</a>`),
+		"/datasets/2023.csv":                    {contentType: "text/csv", body: "year,value\n2023,1\n"},
+		"/datasets/2024.CSV":                    {contentType: "text/csv", body: "year,value\n2024,2\n"},
+		"/datasets/export?format=csv&year=2024": {contentType: "text/csv", body: "export"},
+		"/datasets/archive.zip":                 {contentType: "application/zip", body: "zip"},
+		"/datasets/details/readme.txt":          {contentType: "text/plain", body: "readme"},
+		"/logo.png":                             {contentType: "image/png", body: "png"},
+		"/private/hidden.csv":                   {contentType: "text/csv", body: "hidden"},
+	}
+}
+
+func entryURLs(entries []clients.DownloadEntry, base string) []string {
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, strings.TrimPrefix(entry.URL, base))
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestSpider_Extensions(t *testing.T) {
+	other := newSite(t, map[string]resource{"/elsewhere.csv": {contentType: "text/csv"}})
+	// Hosts are told apart by name only
+	server := newSite(t, datasetSite(strings.Replace(other.URL, "127.0.0.1", "localhost", 1)))
+
+	spider, err := NewSpider(&clients.RealHttpClient{}, strings.ToUpper(server.URL[:4])+server.URL[4:]+"/#start")
+	assert.NoError(t, err)
+	spider.Extensions = []string{"csv"}
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/datasets/2023.csv", "/datasets/2024.CSV"}, entryURLs(entries, server.URL))
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, helpers.SanitizeRelativePath(host+"/datasets"), entries[0].Dir)
+
+	// Every page is read once, and files not at all
+	assert.Equal(t, []string{"/", "/about.html", "/datasets/", "/datasets/details/", "/datasets/export?format=csv&year=2024",
+		"/datasets/missing.html", "/robots.txt"}, server.requested("GET"))
+	assert.Empty(t, server.requested("HEAD"))
+	assert.Empty(t, other.requests)
+}
+
+func TestSpider_MIMETypes(t *testing.T) {
+	server := newSite(t, datasetSite("http://other.invalid"))
+
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL+"/")
+	assert.NoError(t, err)
+	spider.MIMETypes = []string{"text/csv", "application/*"}
+	spider.Hosts = []string{"127.0.0.1", ".invalid"}
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/datasets/2023.csv", "/datasets/2024.CSV", "/datasets/archive.zip",
+		"/datasets/export?format=csv&year=2024"}, entryURLs(entries, server.URL))
+	// Links that cannot be pages are asked for their type, not read
+	assert.Equal(t, []string{"/datasets/2023.csv", "/datasets/2024.CSV", "/datasets/archive.zip",
+		"/datasets/details/readme.txt", "/logo.png"}, server.requested("HEAD"))
+	assert.NotContains(t, server.requested("GET"), "/datasets/archive.zip")
+
+	// Without filters, every linked file is taken
+	spider.MIMETypes = nil
+	spider.MaxDepth = 2
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/datasets/2023.csv", "/datasets/2024.CSV", "/datasets/archive.zip",
+		"/datasets/export?format=csv&year=2024", "/logo.png"}, entryURLs(entries, server.URL))
+}
+
+func TestSpider_Scope(t *testing.T) {
+	server := newSite(t, datasetSite("http://other.invalid"))
+
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL)
+	assert.NoError(t, err)
+	spider.Extensions = []string{".csv", ".txt"}
+	spider.Prefixes = []string{"datasets/"}
+	spider.Filter = helpers.GlobFilter{Exclude: []string{"*/datasets/2023.*"}}
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/datasets/2024.CSV", "/datasets/details/readme.txt"}, entryURLs(entries, server.URL))
+
+	spider.MaxDepth = 1
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	spider.MaxDepth = 0
+	spider.MaxPages = 2
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/datasets/2024.CSV"}, entryURLs(entries, server.URL))
+}
+
+func TestSpider_Robots(t *testing.T) {
+	resources := datasetSite("http://other.invalid")
+	server := newSite(t, resources)
+
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL+"/redirect/")
+	assert.NoError(t, err)
+	spider.Extensions = []string{".csv"}
+	spider.IgnoreRobots = true
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Contains(t, entryURLs(entries, server.URL), "/private/hidden.csv")
+
+	// A robots.txt that cannot be read keeps the spider away
+	resources["/robots.txt"] = resource{contentType: "error"}
+	spider.IgnoreRobots = false
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// One that does not exist lets it in
+	delete(resources, "/robots.txt")
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Contains(t, entryURLs(entries, server.URL), "/private/hidden.csv")
+
+	resources["/robots.txt"] = resource{contentType: "text/plain", body: "User-agent: GoDownload\nDisallow: /\n"}
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	spider.UserAgent = "OtherBot"
+	entries, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestSpider_SendsUserAgent(t *testing.T) {
+	resources := datasetSite("http://other.invalid")
+	server := newSite(t, resources)
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL+"/")
+	assert.NoError(t, err)
+	spider.MIMETypes = []string{"text/csv"}
+
+	// The agent robots.txt is read for is the one the site sees
+	_, err = spider.GetEntries()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, server.agents)
+	for _, agent := range server.agents {
+		assert.Equal(t, DefaultUserAgent, agent)
+	}
+
+	resources["/robots.txt"] = resource{contentType: "text/plain", body: "User-agent: *\nDisallow: /\n\nUser-agent: MyBot\nDisallow: /private/\n"}
+	server.agents = nil
+	spider.UserAgent = "MyBot/1.0 (+https://example.com/bot)"
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Contains(t, entryURLs(entries, server.URL), "/datasets/2023.csv")
+	assert.NotContains(t, entryURLs(entries, server.URL), "/private/hidden.csv")
+	assert.NotEmpty(t, server.agents)
+	for _, agent := range server.agents {
+		assert.Equal(t, "MyBot/1.0 (+https://example.com/bot)", agent)
+	}
+}
+
+func TestSpider_CrawlDelay(t *testing.T) {
+	resources := map[string]resource{
+		"/robots.txt": {contentType: "text/plain", body: "User-agent: *\nCrawl-delay: 0.1\n"},
+		"/":           htmlPage(`<a href="a.html">A</a> <a href="b.html">B</a>`),
+		"/a.html":     htmlPage(`<a href="a.csv">A</a>`),
+		"/b.html":     htmlPage(`<a href="b.csv">B</a>`),
+	}
+	// gaps checks the time between requests, allowing for timer slack
+	gaps := func(server *site, least time.Duration) {
+		assert.Len(t, server.times, 4)
+		for i := 1; i < len(server.times); i++ {
+			assert.Greater(t, server.times[i].Sub(server.times[i-1]), least*9/10)
+		}
+	}
+
+	server := newSite(t, resources)
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL)
+	assert.NoError(t, err)
+	spider.Extensions = []string{".csv"}
+	spider.Delay = 20 * time.Millisecond
+	entries, err := spider.GetEntries()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	gaps(server, 100*time.Millisecond)
+
+	// Without Crawl-delay, Delay applies
+	resources["/robots.txt"] = resource{contentType: "text/plain", body: "User-agent: *\nDisallow:\n"}
+	server = newSite(t, resources)
+	spider.Seeds[0], _ = url.Parse(server.URL + "/")
+	spider.Delay = 50 * time.Millisecond
+	_, err = spider.GetEntries()
+	assert.NoError(t, err)
+	gaps(server, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream, errc := spider.StreamEntries(ctx)
+	for range stream {
+	}
+	assert.ErrorIs(t, <-errc, context.Canceled)
+}
+
+func TestSpider_Errors(t *testing.T) {
+	server := newSite(t, map[string]resource{})
+
+	_, err := NewSpider(&clients.RealHttpClient{})
+	assert.Error(t, err)
+	_, err = NewSpider(&clients.RealHttpClient{}, "ftp://example.com/")
+	assert.Error(t, err)
+	_, err = NewSpider(&clients.RealHttpClient{}, "not a url")
+	assert.Error(t, err)
+
+	spider, _ := NewSpider(&clients.RealHttpClient{}, server.URL+"/missing.html")
+	_, err = spider.GetEntries()
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestSpider_Download(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+	server := newSite(t, datasetSite("http://other.invalid"))
+
+	spider, err := NewSpider(&clients.RealHttpClient{}, server.URL)
+	assert.NoError(t, err)
+	spider.Extensions = []string{".csv", ".zip"}
+	dir := t.TempDir()
+	assert.NoError(t, downloader.New(&clients.RealHttpClient{}).DownloadStream(spider, dir, 2, ctx))
+
+	host := helpers.SanitizeFileName(strings.TrimPrefix(server.URL, "http://"))
+	for name, content := range map[string]string{
+		"datasets/2023.csv":    "year,value\n2023,1\n",
+		"datasets/2024.CSV":    "year,value\n2024,2\n",
+		"datasets/archive.zip": "zip",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, host, filepath.FromSlash(name)))
+		assert.NoError(t, err, name)
+		assert.Equal(t, content, string(got), name)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"HTTP://Example.COM:80/a/./b/../c?b=2&a=1#frag": "http://example.com/a/c?a=1&b=2",
+		"https://example.com:443":                       "https://example.com/",
+		"https://example.com:8443/dir/":                 "https://example.com:8443/dir/",
+		"http://example.com/%7Euser/a%20b":              "http://example.com/~user/a%20b",
+		"http://example.com/a%2Fb/../c":                 "http://example.com/a%2Fb/../c",
+		"http://[::1]:80/x?":                            "http://[::1]/x",
+	} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		normalized, ok := normalizeURL(u)
+		if assert.True(t, ok, raw) {
+			assert.Equal(t, want, normalized.String(), raw)
+		}
+	}
+	for _, raw := range []string{"mailto:someone@example.com", "javascript:void(0)", "ftp://example.com/", "http:///path"} {
+		u, err := url.Parse(raw)
+		assert.NoError(t, err)
+		_, ok := normalizeURL(u)
+		assert.False(t, ok, raw)
+	}
+}
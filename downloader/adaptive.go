@@ -0,0 +1,205 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"context"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrency lets the number of connections of a download run
+// follow the network: it grows by one while that keeps raising the total
+// throughput, and halves when requests start failing or slowing down.
+type AdaptiveConcurrency struct {
+	// Min and Max bound the number of connections.
+	Min int
+	Max int
+	// Interval is how often throughput is measured and the number of
+	// connections adjusted. It defaults to two seconds.
+	Interval time.Duration
+}
+
+const (
+	// maxErrorRate is the share of failing requests within an interval
+	// above which concurrency is cut.
+	maxErrorRate = 0.1
+	// maxLatencyGrowth is how much slower than the best interval responses
+	// may get before concurrency is cut.
+	maxLatencyGrowth = 2.0
+	// minGain is how much an extra connection must raise the throughput to
+	// be kept.
+	minGain = 0.05
+)
+
+// transferSample is what the connections of a download run achieved during
+// one interval.
+type transferSample struct {
+	bytes    int64
+	requests int
+	errors   int
+	latency  time.Duration
+}
+
+// rate returns the throughput of the sample in bytes per interval.
+func (s transferSample) rate() float64 {
+	return float64(s.bytes)
+}
+
+// averageLatency returns the mean time to response headers.
+func (s transferSample) averageLatency() time.Duration {
+	if s.requests == 0 {
+		return 0
+	}
+	return s.latency / time.Duration(s.requests)
+}
+
+// aimd decides the number of connections from one interval to the next.
+type aimd struct {
+	min, max int
+	// lastRate is the throughput of the previous interval and grew
+	// whether the limit was raised after it.
+	lastRate float64
+	grew     bool
+	// bestLatency is the lowest average latency seen so far.
+	bestLatency time.Duration
+}
+
+// next returns the number of connections to use after an interval with the
+// given sample, during which limit connections were allowed and saturated
+// tells whether all of them were in use.
+func (a *aimd) next(limit int, sample transferSample, saturated bool) int {
+	latency := sample.averageLatency()
+	if latency > 0 && (a.bestLatency == 0 || latency < a.bestLatency) {
+		a.bestLatency = latency
+	}
+
+	rate := sample.rate()
+	lastRate, grew := a.lastRate, a.grew
+	a.lastRate, a.grew = rate, false
+
+	switch {
+	case sample.requests > 0 && float64(sample.errors)/float64(sample.requests) > maxErrorRate,
+		latency > 0 && float64(latency) > maxLatencyGrowth*float64(a.bestLatency):
+		limit /= 2
+	case grew && rate < lastRate*(1+minGain):
+		// The last connection added did not pay off
+		limit--
+	case saturated && rate > 0:
+		limit++
+		a.grew = true
+	}
+
+	if limit < a.min {
+		limit = a.min
+	}
+	if limit > a.max {
+		limit = a.max
+	}
+	return limit
+}
+
+// adapt adjusts the limit of budget every interval according to what meter
+// measured, until ctx is cancelled.
+func (c *AdaptiveConcurrency) adapt(ctx context.Context, sugar *zap.SugaredLogger, budget *connBudget, meter *meteredClient) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	control := &aimd{min: c.Min, max: c.Max}
+	if control.min < 1 {
+		control.min = 1
+	}
+	if control.max < control.min {
+		control.max = control.min
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		limit := budget.currentLimit()
+		saturated := budget.available() <= 0
+		next := control.next(limit, meter.sample(), saturated)
+		if next != limit {
+			sugar.Infow("Adjusting concurrency", "from", limit, "to", next)
+			budget.setLimit(next)
+		}
+	}
+}
+
+// meteredClient measures the requests made through it and the bytes read
+// from their responses.
+type meteredClient struct {
+	client clients.HttpClient
+
+	mu      sync.Mutex
+	current transferSample
+}
+
+func newMeteredClient(client clients.HttpClient) *meteredClient {
+	return &meteredClient{client: client}
+}
+
+// sample returns what was measured since the last call.
+func (c *meteredClient) sample() transferSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sample := c.current
+	c.current = transferSample{}
+	return sample
+}
+
+func (c *meteredClient) Get(url string) (*http.Response, error) {
+	return c.measure(func() (*http.Response, error) { return c.client.Get(url) })
+}
+
+func (c *meteredClient) Head(url string) (*http.Response, error) {
+	return c.client.Head(url)
+}
+
+func (c *meteredClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return c.measure(func() (*http.Response, error) { return c.client.Do(ctx, req) })
+}
+
+// measure makes a request through fn and records its outcome. Throttling
+// and server errors count as failures.
+func (c *meteredClient) measure(fn func() (*http.Response, error)) (*http.Response, error) {
+	started := time.Now()
+	resp, err := fn()
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+
+	c.mu.Lock()
+	c.current.requests++
+	c.current.latency += time.Since(started)
+	if failed {
+		c.current.errors++
+	}
+	c.mu.Unlock()
+
+	if err == nil {
+		resp.Body = &meteredBody{ReadCloser: resp.Body, client: c}
+	}
+	return resp, err
+}
+
+// meteredBody counts the bytes read from a response.
+type meteredBody struct {
+	io.ReadCloser
+	client *meteredClient
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.client.mu.Lock()
+	b.client.current.bytes += int64(n)
+	b.client.mu.Unlock()
+	return n, err
+}
@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"GoDownload/hostlimit"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAIMD(t *testing.T) {
+	control := &aimd{min: 2, max: 6}
+	ok := transferSample{bytes: 1000, requests: 10, latency: 10 * 10 * time.Millisecond}
+
+	// Saturated connections grow one at a time
+	assert.Equal(t, 5, control.next(4, ok, true))
+	// An extra connection that does not raise throughput is dropped again
+	assert.Equal(t, 4, control.next(5, ok, true))
+	// Idle connections are not added to
+	assert.Equal(t, 4, control.next(4, ok, false))
+
+	// Growth that pays off is kept going, up to the maximum
+	assert.Equal(t, 5, control.next(4, ok, true))
+	faster := ok
+	faster.bytes = 2000
+	assert.Equal(t, 6, control.next(5, faster, true))
+	faster.bytes = 4000
+	assert.Equal(t, 6, control.next(6, faster, true))
+
+	// Failures halve the connections, down to the minimum
+	failing := ok
+	failing.errors = 2
+	assert.Equal(t, 3, control.next(6, failing, true))
+	assert.Equal(t, 2, control.next(3, failing, true))
+
+	// So do responses getting much slower
+	slow := ok
+	slow.latency *= 3
+	assert.Equal(t, 3, control.next(6, slow, true))
+}
+
+func TestConnBudget_SetLimit(t *testing.T) {
+	budget := newConnBudget(2)
+	assert.Equal(t, 2, budget.tryAcquire(3))
+
+	budget.setLimit(1)
+	assert.True(t, budget.overLimit())
+	budget.release(1)
+	assert.False(t, budget.overLimit())
+	assert.Equal(t, 0, budget.available())
+
+	// Raising the limit wakes up waiters
+	acquired := make(chan bool)
+	go func() { acquired <- budget.acquire(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	budget.setLimit(2)
+	assert.True(t, <-acquired)
+}
+
+func TestMeteredClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Hello World"))
+	}))
+	defer ts.Close()
+
+	meter := newMeteredClient(&clients.RealHttpClient{})
+	resp, err := meter.Get(ts.URL)
+	assert.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp, err = meter.Get(ts.URL + "/busy")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	sample := meter.sample()
+	assert.Equal(t, int64(11), sample.bytes)
+	assert.Equal(t, 2, sample.requests)
+	assert.Equal(t, 1, sample.errors)
+	assert.Equal(t, transferSample{}, meter.sample())
+}
+
+func TestSegmentedDownload_ConnectionsFromBudget(t *testing.T) {
+	content := strings.Repeat("0123456789", 30)
+
+	tests := []struct {
+		name  string
+		limit int
+		max   int
+	}{
+		{"no spare connections", 1, 1},
+		{"spare connections", 3, 3},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		active, peak := 0, 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Method == http.MethodGet {
+				mu.Lock()
+				active++
+				if active > peak {
+					peak = active
+				}
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				defer func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+			}
+			http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+		}))
+
+		// The caller holds one connection of the budget already
+		budget := newConnBudget(tt.limit)
+		budget.tryAcquire(1)
+		downloader := &SegmentedDownloader{Client: &clients.RealHttpClient{}, SegmentManager: &PreallocatedSegmentManager{}, budget: budget}
+		destPath := filepath.Join(t.TempDir(), "file.bin")
+		err := downloader.downloadFileInSegments(context.Background(), ts.URL, destPath, 3, nil, nil)
+		ts.Close()
+
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.max, peak, tt.name)
+		assert.Equal(t, tt.limit-1, budget.available(), tt.name)
+	}
+}
+
+func TestSegmentedDownload_LeavesBudgetWhileHostIsFull(t *testing.T) {
+	content := strings.Repeat("0123456789", 30)
+	budget := newConnBudget(3)
+	// The caller holds one connection of the budget already
+	budget.tryAcquire(1)
+
+	var mu sync.Mutex
+	leastAvailable := budget.available()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == http.MethodGet {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			if available := budget.available(); available < leastAvailable {
+				leastAvailable = available
+			}
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	// The host only takes one connection at a time
+	client := hostlimit.NewClient(&clients.RealHttpClient{}, hostlimit.Config{MaxConnections: 1})
+	downloader := &SegmentedDownloader{Client: client, SegmentManager: &PreallocatedSegmentManager{}, budget: budget, hosts: client}
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	err := downloader.downloadFileInSegments(context.Background(), ts.URL, destPath, 3, nil, nil)
+	assert.NoError(t, err)
+
+	// No connection was held waiting for the host
+	assert.Equal(t, 2, leastAvailable)
+	assert.Equal(t, 2, budget.available())
+	got, _ := os.ReadFile(destPath)
+	assert.Equal(t, content, string(got))
+}
+
+func TestDownloadFiles_Adaptive(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+
+	var urls []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		urls = append(urls, ts.URL+"/"+name+".txt")
+	}
+	dl := New(&clients.RealHttpClient{})
+	dl.Adaptive = &AdaptiveConcurrency{Min: 1, Max: 4, Interval: time.Millisecond}
+	bars := dl.DownloadFiles(&clients.StaticURLProvider{URLs: urls}, dir, 2, ctx)
+
+	assert.Len(t, bars, len(urls))
+	for _, url := range urls {
+		name := url[strings.LastIndex(url, "/"):]
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, name, string(content))
+	}
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+// connBudget limits the number of connections open at once across all files
+// and segments of a download run. The limit can change while connections
+// are held; lowering it below the number in use only stops new ones from
+// being handed out until enough have been released.
+type connBudget struct {
+	mu    sync.Mutex
+	limit int
+	inUse int
+	// changed is closed and replaced whenever a connection may have
+	// become available.
+	changed chan struct{}
+}
+
+func newConnBudget(size int) *connBudget {
+	if size < 1 {
+		size = 1
+	}
+	return &connBudget{limit: size, changed: make(chan struct{})}
+}
+
+// acquire waits for a connection, returning false if ctx is cancelled first.
+func (b *connBudget) acquire(ctx context.Context) bool {
+	for {
+		b.mu.Lock()
+		if b.inUse < b.limit {
+			b.inUse++
+			b.mu.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// tryAcquire takes up to n connections that are free right now and returns
+// how many it got.
+func (b *connBudget) tryAcquire(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if free := b.limit - b.inUse; n > free {
+		n = free
+	}
+	if n < 0 {
+		n = 0
+	}
+	b.inUse += n
+	return n
+}
+
+// available returns the number of connections free right now.
+func (b *connBudget) available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit - b.inUse
+}
+
+// overLimit reports whether more connections are held than the limit
+// allows, in which case holders that can should give one back.
+func (b *connBudget) overLimit() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inUse > b.limit
+}
+
+// release gives back n connections.
+func (b *connBudget) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inUse -= n
+	b.notify()
+}
+
+// currentLimit returns the number of connections allowed at once.
+func (b *connBudget) currentLimit() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+// setLimit changes the number of connections allowed at once.
+func (b *connBudget) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	b.notify()
+}
+
+// notify wakes everyone waiting in acquire. The caller holds b.mu.
+func (b *connBudget) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,24 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnBudget(t *testing.T) {
+	budget := newConnBudget(3)
+	assert.True(t, budget.acquire(context.Background()))
+	assert.Equal(t, 2, budget.tryAcquire(5))
+	assert.Equal(t, 0, budget.tryAcquire(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, budget.acquire(ctx))
+
+	budget.release(2)
+	assert.Equal(t, 1, budget.tryAcquire(1))
+	assert.Equal(t, 1, budget.tryAcquire(1))
+}
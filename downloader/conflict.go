@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Action is what was done about a download.
+type Action string
+
+const (
+	// ActionDownloaded means the file did not exist and was downloaded.
+	ActionDownloaded Action = "downloaded"
+	// ActionSkipped means an existing file was left alone.
+	ActionSkipped Action = "skipped"
+	// ActionSkippedIdentical means an existing file matched the remote one.
+	ActionSkippedIdentical Action = "skipped-identical"
+	// ActionOverwritten means an existing file was replaced.
+	ActionOverwritten Action = "overwritten"
+	// ActionRenamed means the file was saved under a new name because the
+	// destination existed.
+	ActionRenamed Action = "renamed"
+	// ActionResumed means an existing file was completed.
+	ActionResumed Action = "resumed"
+	// ActionFailed means the download did not succeed.
+	ActionFailed Action = "failed"
+)
+
+// Result reports what happened to one download.
+type Result struct {
+	URL    string
+	Path   string
+	Action Action
+	Err    error
+}
+
+// ResolveConflict decides what to do about destPath according to policy,
+// returning the action and the path to download to, which is "" when the
+// download should be skipped. head is the response to the download's HEAD
+// request; skip-if-identical treats a nil head as a different file. names,
+// if set, keeps renamed paths clear of other downloads of the batch. Under
+// the resume policy the existing file becomes the partial file that the
+// download continues from.
+func ResolveConflict(policy clients.ConflictPolicy, destPath string, url string, head *http.Response, names *PathReserver) (Action, string, error) {
+	info, err := os.Stat(destPath)
+	if os.IsNotExist(err) {
+		return ActionDownloaded, destPath, nil
+	}
+	if err != nil {
+		return ActionFailed, "", err
+	}
+	if info.IsDir() {
+		return ActionFailed, "", fmt.Errorf("%s is a directory", destPath)
+	}
+
+	switch policy {
+	case clients.ConflictOverwrite:
+		return ActionOverwritten, destPath, nil
+	case clients.ConflictRename:
+		for i := 1; ; i++ {
+			candidate := numberedPath(destPath, i)
+			if _, err := os.Stat(candidate); !os.IsNotExist(err) {
+				continue
+			}
+			if names == nil || names.TryReserve(candidate) {
+				return ActionRenamed, candidate, nil
+			}
+		}
+	case clients.ConflictResume:
+		if head != nil && head.ContentLength >= 0 && info.Size() == head.ContentLength {
+			return ActionSkipped, "", nil
+		}
+		return ActionResumed, destPath, adoptAsPartial(destPath, url)
+	case clients.ConflictSkipIdentical:
+		if identical(destPath, info, head) {
+			return ActionSkippedIdentical, "", nil
+		}
+		return ActionOverwritten, destPath, nil
+	default:
+		return ActionSkipped, "", nil
+	}
+}
+
+// adoptAsPartial turns a complete-looking file into the partial file of a
+// download. The manifest is marked unvalidated since there is nothing to
+// tell which version of the remote file the bytes came from.
+func adoptAsPartial(destPath string, url string) error {
+	partPath := PartialPath(destPath)
+	os.Remove(partPath)
+	if err := os.Rename(destPath, partPath); err != nil {
+		return err
+	}
+	manifest := &Manifest{URL: url, Size: -1, Unvalidated: true}
+	return manifest.Save(ManifestPath(destPath))
+}
+
+// identical reports whether the file at destPath is the one head describes:
+// the sizes match and so does the ETag recorded when the file was
+// downloaded or, failing that, the modification time.
+func identical(destPath string, info os.FileInfo, head *http.Response) bool {
+	if head == nil || head.ContentLength < 0 || info.Size() != head.ContentLength {
+		return false
+	}
+	if etag := head.Header.Get("ETag"); etag != "" {
+		if local := loadETag(destPath); local != "" {
+			return local == etag
+		}
+	}
+	lastModified, err := http.ParseTime(head.Header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return info.ModTime().Truncate(time.Second).Equal(lastModified)
+}
+
+// applyRemoteMetadata gives a finished download the modification time of
+// the remote file and records its ETag, so skip-if-identical can later
+// compare the two.
+func applyRemoteMetadata(destPath string, etag string, lastModified string) {
+	if modTime, err := http.ParseTime(lastModified); err == nil {
+		os.Chtimes(destPath, modTime, modTime)
+	}
+	storeETag(destPath, etag)
+}
@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var remoteModTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newFileServer(t *testing.T, content string, etag string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		http.ServeContent(w, r, "file.txt", remoteModTime, strings.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// downloadWithPolicy downloads url into dir under policy and returns the
+// reported result.
+func downloadWithPolicy(t *testing.T, url string, dir string, policy clients.ConflictPolicy) Result {
+	var mu sync.Mutex
+	var results []Result
+	dl := New(&clients.RealHttpClient{})
+	dl.OnConflict = policy
+	dl.OnResult = func(result Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+	}
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{url}}, dir, 1, ctx)
+
+	if !assert.Len(t, results, 1) {
+		t.FailNow()
+	}
+	return results[0]
+}
+
+func TestConflict_Skip(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := newFileServer(t, "new content", `"v2"`)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("old"), 0644)
+
+	result := downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictSkip)
+	assert.Equal(t, ActionSkipped, result.Action)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.Equal(t, "old", string(content))
+}
+
+func TestConflict_Overwrite(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := newFileServer(t, "new content", `"v2"`)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("old"), 0644)
+
+	result := downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictOverwrite)
+	assert.Equal(t, ActionOverwritten, result.Action)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.Equal(t, "new content", string(content))
+}
+
+func TestConflict_Rename(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := newFileServer(t, "new content", `"v2"`)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("old"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "file.1.txt"), []byte("older"), 0644)
+
+	result := downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictRename)
+	assert.Equal(t, ActionRenamed, result.Action)
+	assert.Equal(t, filepath.Join(dir, "file.2.txt"), result.Path)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "file.2.txt"))
+	assert.Equal(t, "new content", string(content))
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.Equal(t, "old", string(content))
+}
+
+func TestConflict_Resume(t *testing.T) {
+	setupOnce.Do(setup)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "file.txt", remoteModTime, strings.NewReader("Hello World"))
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("Hello"), 0644)
+
+	result := downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictResume)
+	assert.Equal(t, ActionResumed, result.Action)
+	assert.Equal(t, []string{"bytes=5-"}, ranges)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.Equal(t, "Hello World", string(content))
+
+	// A complete file is left alone
+	result = downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictResume)
+	assert.Equal(t, ActionSkipped, result.Action)
+	assert.Len(t, ranges, 1)
+}
+
+func TestConflict_SkipIfIdentical(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := newFileServer(t, "new content", "")
+	dir := t.TempDir()
+
+	// The first download records the remote modification time
+	result := downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictSkipIdentical)
+	assert.Equal(t, ActionDownloaded, result.Action)
+	info, _ := os.Stat(filepath.Join(dir, "file.txt"))
+	assert.True(t, info.ModTime().Equal(remoteModTime))
+
+	result = downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictSkipIdentical)
+	assert.Equal(t, ActionSkippedIdentical, result.Action)
+
+	// Same size, different time: downloaded again
+	os.Chtimes(filepath.Join(dir, "file.txt"), time.Now(), time.Now())
+	result = downloadWithPolicy(t, ts.URL+"/file.txt", dir, clients.ConflictSkipIdentical)
+	assert.Equal(t, ActionOverwritten, result.Action)
+}
+
+func TestConflict_SkipIfIdenticalByETag(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	ioutil.WriteFile(path, []byte("content"), 0644)
+	storeETag(path, `"v1"`)
+	if loadETag(path) == "" {
+		t.Skip("file system does not support extended attributes")
+	}
+
+	head := &http.Response{ContentLength: 7, Header: http.Header{"Etag": {`"v1"`}}}
+	action, newPath, err := ResolveConflict(clients.ConflictSkipIdentical, path, "http://example.com/file.txt", head, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionSkippedIdentical, action)
+	assert.Equal(t, "", newPath)
+
+	head.Header.Set("ETag", `"v2"`)
+	action, newPath, _ = ResolveConflict(clients.ConflictSkipIdentical, path, "http://example.com/file.txt", head, nil)
+	assert.Equal(t, ActionOverwritten, action)
+	assert.Equal(t, path, newPath)
+}
+
+func TestConflict_EntryOverridesPolicy(t *testing.T) {
+	setupOnce.Do(setup)
+	ts := newFileServer(t, "new content", `"v2"`)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("old"), 0644)
+
+	input := ts.URL + "/file.txt\n  conflict=overwrite\n"
+	dl := New(&clients.RealHttpClient{})
+	dl.OnConflict = clients.ConflictSkip
+	dl.DownloadFiles(&clients.FileURLProvider{Filename: "-", Stdin: strings.NewReader(input)}, dir, 1, ctx)
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.Equal(t, "new content", string(content))
+}
+
+func TestResolveConflict_NoFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	action, newPath, err := ResolveConflict(clients.ConflictSkip, path, "http://example.com/file.txt", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionDownloaded, action)
+	assert.Equal(t, path, newPath)
+}
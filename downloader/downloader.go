@@ -1,8 +1,8 @@
 package downloader
 
 import (
+	"GoDownload/checksum"
 	"GoDownload/clients"
-	"GoDownload/helpers"
 	"context"
 	"errors"
 	"fmt"
@@ -12,7 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
+	"path/filepath"
 	"sync"
 )
 
@@ -20,6 +20,7 @@ import (
 type DownloaderInterface interface {
 	DownloadFile(url string, destPath string, bar *pb.ProgressBar, ctx context.Context) error
 	DownloadFiles(provider clients.URLProvider, dir string, threads int, ctx context.Context) []*pb.ProgressBar
+	DownloadStream(provider clients.StreamingURLProvider, dir string, threads int, ctx context.Context) error
 }
 
 // Ensure Downloader implements DownloaderInterface
@@ -28,8 +29,54 @@ var _ DownloaderInterface = &Downloader{}
 // Downloader is responsible for downloading files.
 type Downloader struct {
 	Client clients.HttpClient
+	// Retry decides whether failed downloads are tried again. Retries
+	// continue from the partial file. Nil disables retries.
+	Retry *clients.RetryPolicy
+	// Quarantine is where files failing checksum verification are moved.
+	// They are deleted when it is empty.
+	Quarantine string
+	// OnConflict decides what happens when a destination file already
+	// exists. Entries may override it. It defaults to skipping the file.
+	OnConflict clients.ConflictPolicy
+	// OnResult, if set, is called with the outcome of every download made
+	// by DownloadFiles and DownloadStream.
+	OnResult func(Result)
+	// Segments is the most connections DownloadFiles and DownloadStream
+	// use for a single file; smaller files get fewer. Files are only split
+	// when the server accepts byte ranges and they are at least
+	// SegmentThreshold bytes long. Values below 2 disable segmented
+	// downloads.
+	Segments int
+	// SegmentThreshold is the smallest file size split into segments.
+	SegmentThreshold int64
+	// SegmentManager writes segmented downloads. It defaults to a
+	// PreallocatedSegmentManager.
+	SegmentManager SegmentManager
+	// Adaptive, if set, lets DownloadFiles and DownloadStream vary the
+	// number of connections within its bounds, starting from the number
+	// of threads they are given.
+	Adaptive *AdaptiveConcurrency
+	// Middleware is applied, in order, to every request made through
+	// Client, including those of segmented downloads.
+	Middleware []clients.Middleware
+
+	// hosts, if set, is the Client of a run before any wrapping, which
+	// holds back requests to busy hosts.
+	hosts HostWaiter
+}
+
+// withMiddleware returns a copy of d whose Client applies d.Middleware.
+func (d *Downloader) withMiddleware() *Downloader {
+	run := *d
+	run.Client = clients.Use(d.Client, d.Middleware...)
+	run.Middleware = nil
+	return &run
 }
 
+// DefaultSegmentThreshold is the smallest file worth splitting into segments
+// unless configured otherwise.
+const DefaultSegmentThreshold = 16 << 20
+
 func New(client clients.HttpClient) *Downloader {
 	return &Downloader{Client: client}
 }
@@ -40,123 +87,610 @@ type DownloaderFactory interface {
 }
 
 // RealDownloaderFactory is the real implementation of DownloaderFactory.
-type RealDownloaderFactory struct{}
+type RealDownloaderFactory struct {
+	Retry      *clients.RetryPolicy
+	Quarantine string
+	OnConflict clients.ConflictPolicy
+	OnResult   func(Result)
+
+	Segments         int
+	SegmentThreshold int64
+	Adaptive         *AdaptiveConcurrency
+	Middleware       []clients.Middleware
+}
 
 func (rdf *RealDownloaderFactory) NewDownloader(client clients.HttpClient) DownloaderInterface {
-	return New(client)
+	d := New(client)
+	d.Retry = rdf.Retry
+	d.Quarantine = rdf.Quarantine
+	d.OnConflict = rdf.OnConflict
+	d.OnResult = rdf.OnResult
+	d.Segments = rdf.Segments
+	d.SegmentThreshold = rdf.SegmentThreshold
+	d.Adaptive = rdf.Adaptive
+	d.Middleware = rdf.Middleware
+	return d
 }
 
+// DownloadFile downloads url to destPath. Data is written to a partial file
+// first; if an earlier attempt left one behind, the download continues from
+// where it stopped instead of starting over. An existing destPath is dealt
+// with according to OnConflict.
 func (d *Downloader) DownloadFile(url string, destPath string, bar *pb.ProgressBar, ctx context.Context) error {
+	sugar, ok := ctx.Value("sugar").(*zap.SugaredLogger)
+	if !ok {
+		panic("error getting logger")
+	}
+	if len(d.Middleware) > 0 {
+		return d.withMiddleware().DownloadFile(url, destPath, bar, ctx)
+	}
+
+	entry := clients.DownloadEntry{URL: url}
+	var head *http.Response
+	if d.conflictPolicy(entry) == clients.ConflictSkipIdentical {
+		if _, err := os.Stat(destPath); err == nil {
+			if head, err = headWithRetry(ctx, d.Retry, d.Client, url); err == nil {
+				head.Body.Close()
+			}
+		}
+	}
+
+	action, path, err := ResolveConflict(d.conflictPolicy(entry), destPath, url, head, nil)
+	if err != nil || path == "" {
+		if err == nil {
+			sugar.Infow("File already exists, not downloading", "destPath", destPath, "action", action)
+		}
+		return err
+	}
+	return d.downloadEntry(ctx, entry, path, bar)
+}
+
+// conflictPolicy returns the policy applying to entry.
+func (d *Downloader) conflictPolicy(entry clients.DownloadEntry) clients.ConflictPolicy {
+	if entry.OnConflict != "" {
+		return entry.OnConflict
+	}
+	if d.OnConflict != "" {
+		return d.OnConflict
+	}
+	return clients.ConflictSkip
+}
+
+// downloadEntry downloads entry to destPath with the entry's headers, trying
+// its mirrors in order until one succeeds. When the entry has a checksum the
+// download is verified as it streams; a mirror serving a file that does not
+// match is treated like a failing one. An existing destPath is replaced
+// once the download is complete.
+func (d *Downloader) downloadEntry(ctx context.Context, entry clients.DownloadEntry, destPath string, bar *pb.ProgressBar) error {
 
 	sugar, ok := ctx.Value("sugar").(*zap.SugaredLogger)
 	if !ok {
 		panic("error getting logger")
 	}
 
-	// Check if file already exists
-	if _, err := os.Stat(destPath); err == nil {
-		sugar.Errorw("File %s already exists. Skipping download.", "destPath", destPath)
-		return nil
-	} else if !os.IsNotExist(err) {
+	sum, err := entryChecksum(entry)
+	if err != nil {
 		return err
 	}
 
-	resp, err := d.Client.Get(url)
+	client := clients.WithHeader(d.Client, entry.Header)
+	for i, url := range entry.URLs() {
+		err = d.Retry.Do(ctx, func(attempt int) error {
+			if attempt > 1 {
+				sugar.Infow("Retrying download", "url", url, "attempt", attempt)
+			}
+			return d.fetch(ctx, sugar, client, url, destPath, sum, bar)
+		})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, checksum.ErrMismatch) {
+			sugar.Errorw("Checksum mismatch", "url", url, "err", err)
+		}
+		if i < len(entry.Mirrors) {
+			sugar.Infow("Download failed, trying next mirror", "url", url, "err", err)
+		}
+	}
+	return err
+}
+
+// fetch downloads a single URL to destPath, resuming from a partial file
+// when an earlier attempt of the same URL left one behind. If sum is set
+// the data is hashed while it is written and checked before the partial
+// file is moved into place.
+func (d *Downloader) fetch(ctx context.Context, sugar *zap.SugaredLogger, client clients.HttpClient, url string, destPath string, sum *checksum.Checksum, bar *pb.ProgressBar) error {
+	partPath := PartialPath(destPath)
+	manifestPath := ManifestPath(destPath)
+
+	// Only resume when the previous attempt recorded a validator, otherwise
+	// we have no way to tell whether the remote file changed in between.
+	var offset int64
+	manifest, _ := LoadManifest(manifestPath)
+	// A segmented download leaves a partial file of the full size, holes
+	// included, so its size says nothing about what was fetched
+	if manifest != nil && len(manifest.Segments) > 0 {
+		sugar.Infow("Partial file was written in segments, restarting", "url", url)
+		manifest = nil
+	}
+	if manifest != nil && manifest.URL == url && (manifest.Validator() != "" || manifest.Unvalidated) {
+		if info, err := os.Stat(partPath); err == nil {
+			offset = info.Size()
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator := manifest.Validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	resp, err := client.Do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(fmt.Sprintf("failed to download file: %s", resp.Status))
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		start, _, rangeErr := parseContentRange(resp.Header.Get("Content-Range"))
+		if rangeErr != nil {
+			return rangeErr
+		}
+		if start != offset {
+			return fmt.Errorf("server resumed at byte %d, expected %d", start, offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		if manifest.Unvalidated {
+			manifest.ETag, manifest.LastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		}
+		sugar.Infow("Resuming download", "url", url, "offset", offset)
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already hold the whole resource.
+		_, total, _ := parseContentRange(resp.Header.Get("Content-Range"))
+		if total == offset {
+			if bar != nil {
+				bar.SetCurrent(offset)
+			}
+			if h := newHash(sum); h != nil {
+				if err := hashPrefix(h, partPath, offset); err != nil {
+					return err
+				}
+				if err := verify(sum, h, partPath, destPath, d.Quarantine); err != nil {
+					os.Remove(manifestPath)
+					return err
+				}
+			}
+			return d.finishPartial(partPath, manifestPath, destPath, manifest)
+		}
+		sugar.Infow("Partial file does not match remote, restarting", "url", url)
+		os.Remove(partPath)
+		os.Remove(manifestPath)
+		resp.Body.Close()
+		return d.fetch(ctx, sugar, client, url, destPath, sum, bar)
+	case resp.StatusCode == http.StatusOK:
+		// Either a fresh download, or the server ignored the range or the
+		// resource changed since the first attempt: start from byte zero.
+		if offset > 0 {
+			sugar.Infow("Server did not resume, restarting", "url", url)
+		}
+		offset = 0
+		manifest = NewManifest(url, resp)
+		if err := manifest.Save(manifestPath); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("failed to download file: %w", clients.NewStatusError(resp))
 	}
 
-	out, err := os.Create(destPath)
+	out, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	progressReader := bar.NewProxyReader(resp.Body)
-	_, err = io.Copy(out, progressReader)
-	return err
+	// Hash what an earlier attempt wrote, then everything we write
+	var dst io.Writer = out
+	h := newHash(sum)
+	if h != nil {
+		if offset > 0 {
+			if err := hashPrefix(h, partPath, offset); err != nil {
+				return err
+			}
+		}
+		dst = io.MultiWriter(out, h)
+	}
+
+	var body io.Reader = resp.Body
+	if bar != nil {
+		bar.SetCurrent(offset)
+		body = bar.NewProxyReader(resp.Body)
+	}
+	if _, err = io.Copy(dst, body); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err := verify(sum, h, partPath, destPath, d.Quarantine); err != nil {
+		os.Remove(manifestPath)
+		return err
+	}
+	return d.finishPartial(partPath, manifestPath, destPath, manifest)
+}
+
+// finishPartial moves a completed partial file into place, gives it the
+// remote file's metadata recorded in manifest and drops the manifest.
+func (d *Downloader) finishPartial(partPath, manifestPath, destPath string, manifest *Manifest) error {
+	if err := os.Rename(partPath, destPath); err != nil {
+		return err
+	}
+	applyRemoteMetadata(destPath, manifest.ETag, manifest.LastModified)
+	os.Remove(manifestPath)
+	return nil
+}
+
+// EntryPath returns where entry is saved when downloading into dir, judging
+// by its URL and options alone.
+func EntryPath(dir string, entry clients.DownloadEntry) string {
+	return ResponsePath(dir, entry, nil)
+}
+
+// ResponsePath returns where entry is saved when downloading into dir, also
+// taking the response to its HEAD request into account. See
+// ResolveFileName.
+func ResponsePath(dir string, entry clients.DownloadEntry, resp *http.Response) string {
+	if entry.Dir != "" {
+		if filepath.IsAbs(entry.Dir) {
+			dir = entry.Dir
+		} else {
+			dir = filepath.Join(dir, entry.Dir)
+		}
+	}
+	return filepath.Join(dir, ResolveFileName(entry, resp))
 }
 
+// DownloadFiles downloads every URL of provider into dir and returns the
+// progress bar of each, in provider order. A URL whose HEAD request fails
+// gets a nil bar.
 func (d *Downloader) DownloadFiles(provider clients.URLProvider, dir string, threads int, logCtx context.Context) []*pb.ProgressBar {
-	urls, getUrlErr := provider.GetURLs()
 	sugar, ok := logCtx.Value("sugar").(*zap.SugaredLogger)
 	if !ok {
 		panic("error getting logger")
 	}
 
-	if getUrlErr != nil {
-		sugar.Errorw("Failed to execute someFunction", "error", getUrlErr)
+	var mu sync.Mutex
+	var bars []*pb.ProgressBar
+	err := d.downloadAll(clients.Stream(provider), dir, threads, logCtx, func(i int, bar *pb.ProgressBar) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(bars) <= i {
+			bars = append(bars, nil)
+		}
+		bars[i] = bar
+	})
+	if err != nil {
+		sugar.Errorw("Failed to get URLs", "error", err)
+	}
+	return bars
+}
+
+// DownloadStream downloads every entry of provider into dir. Entries are
+// only pulled from the provider as download slots free up and progress bars
+// are dropped once finished, so memory use does not grow with the length of
+// the list.
+func (d *Downloader) DownloadStream(provider clients.StreamingURLProvider, dir string, threads int, logCtx context.Context) error {
+	return d.downloadAll(provider, dir, threads, logCtx, nil)
+}
+
+// downloadAll runs downloads using at most threads connections at a time,
+// counting every segment of a segmented download as a connection, or a
+// varying number of connections if Adaptive is set. onBar, if set, is
+// called with the index and progress bar of each entry once it is done.
+func (d *Downloader) downloadAll(provider clients.StreamingURLProvider, dir string, threads int, logCtx context.Context, onBar func(int, *pb.ProgressBar)) error {
+	sugar, ok := logCtx.Value("sugar").(*zap.SugaredLogger)
+	if !ok {
+		panic("error getting logger")
 	}
 
-	// Create a context that can be canceled
-	ctx, cancel := context.WithCancel(context.Background())
+	// Create a context that can be canceled. Cancelling it interrupts
+	// in-flight downloads, leaving their partial files to resume from.
+	ctx, cancel := context.WithCancel(logCtx)
 	defer cancel()
 
 	// Listen for interrupt signals
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
 	go func() {
-		<-sigs
-		cancel()
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
 
-	// Create a progress pool
-	pool, err := pb.StartPool()
-	if err != nil {
-		sugar.Errorw("Error starting progress pool:", "err", err)
-		return nil
-	}
-	defer pool.Stop()
+	// Draw progress bars while the downloads run
+	board := startProgressBoard(os.Stderr)
+	defer board.Stop()
 
-	bars := make([]*pb.ProgressBar, len(urls))
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, threads)
+	run := *d.withMiddleware()
+	budget := newConnBudget(threads)
+	if d.Adaptive != nil {
+		budget.setLimit(clampThreads(threads, d.Adaptive.Min, d.Adaptive.Max))
+		meter := newMeteredClient(run.Client)
+		run.Client = meter
+		go d.Adaptive.adapt(ctx, sugar, budget, meter)
+	}
+
+	// Names are reserved and conflicts resolved in entry order, whatever
+	// order the HEAD requests complete in, so that clashing names are
+	// resolved the same way on every run and interrupted downloads find
+	// their partial files again.
+	names := NewPathReserver()
+	prevTurn := make(chan struct{})
+	close(prevTurn)
 
-	for i, eachUrl := range urls {
-		resp, respErr := d.Client.Head(eachUrl)
-		if respErr != nil {
-			sugar.Errorw("Error making HEAD request", "url", eachUrl, "err", err)
-			continue
+	// A client holding back requests to busy hosts lets entries queue up
+	// beyond the connections that are free, so that entries for other
+	// hosts can overtake them. Queued entries only take a connection once
+	// their host is ready and their name is resolved. Rather than hold up
+	// the names of the entries after it, an entry waiting for its host
+	// gives up its place in the naming order.
+	waiter, _ := d.Client.(HostWaiter)
+	run.hosts = waiter
+	var queued chan struct{}
+	if waiter != nil {
+		queued = make(chan struct{}, queuedEntries(threads))
+	}
+
+	entries, errc := provider.StreamEntries(ctx)
+	i := 0
+	for entry := range entries {
+		if queued != nil {
+			select {
+			case queued <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		} else if !budget.acquire(ctx) {
+			break
 		}
-		contentLength := resp.ContentLength
-		resp.Body.Close()
 
-		bars[i] = pb.New64(contentLength)
-		pool.Add(bars[i])
+		prev, turn := prevTurn, make(chan struct{})
+		var once sync.Once
+		inTurn := func(fn func()) {
+			once.Do(func() {
+				<-prev
+				fn()
+				close(turn)
+			})
+		}
 
 		wg.Add(1)
-		go func(url string, bar *pb.ProgressBar, logCtx context.Context) {
+		go func(i int, entry clients.DownloadEntry, inTurn func(func())) {
 			defer wg.Done()
-
-			select {
-			case <-ctx.Done():
-				bar.Finish()
-				return
-			default:
+			if queued != nil {
+				defer func() { <-queued }()
+			} else {
+				defer budget.release(1)
 			}
+			// Pass the turn on even if the entry never got to a name
+			defer inTurn(func() {})
 
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			destPath := path.Join(dir, helpers.GetFileNameFromURL(url))
-			if _, pathErr := os.Stat(destPath); os.IsNotExist(pathErr) {
-				downloadErr := d.DownloadFile(url, destPath, bar, logCtx)
-				if downloadErr != nil {
-					fmt.Printf("Error downloading %s: %v\n", url, downloadErr)
-					sugar.Errorw("Error downloading", "url", url, "err", downloadErr)
-				} else {
-					sugar.Infow("URL Downloaded", "url", url, "destPath", destPath)
+			if waiter != nil && !waiter.HostReady(entry.URL) {
+				inTurn(func() {})
+				if err := waiter.WaitHost(ctx, entry.URL); err != nil {
+					return
 				}
+				inTurn = func(fn func()) { fn() }
 			}
-			bar.Finish()
-		}(eachUrl, bars[i], logCtx)
+			bar := run.downloadOne(ctx, sugar, board, budget, queued == nil, dir, entry, names, inTurn)
+			if onBar != nil {
+				onBar(i, bar)
+			}
+		}(i, entry, inTurn)
+		prevTurn = turn
+		i++
 	}
 
 	wg.Wait()
-	return bars
+	return <-errc
+}
+
+// downloadOne downloads a single entry, returning its progress bar or nil
+// if the HEAD request failed. The entry's path is reserved in names and its
+// conflict resolved within inTurn, so entries claim paths in order, and
+// released once the download is over. Unless
+// held is set, a connection of budget is taken for the download once that
+// is done. Large files are split into segments when more connections of
+// budget are free.
+func (d *Downloader) downloadOne(ctx context.Context, sugar *zap.SugaredLogger, board *progressBoard, budget *connBudget, held bool, dir string, entry clients.DownloadEntry, names *PathReserver, inTurn func(func())) *pb.ProgressBar {
+	resp, respErr := headWithRetry(ctx, d.Retry, clients.WithHeader(d.Client, entry.Header), entry.URL)
+	if respErr != nil {
+		sugar.Errorw("Error making HEAD request", "url", entry.URL, "err", respErr)
+		d.report(sugar, Result{URL: entry.URL, Action: ActionFailed, Err: respErr})
+		return nil
+	}
+	contentLength := resp.ContentLength
+	segmentable := d.segmentable(resp)
+	resp.Body.Close()
+
+	var action Action
+	var reserved, destPath string
+	var conflictErr error
+	inTurn(func() {
+		reserved = names.Reserve(ResponsePath(dir, entry, resp))
+		action, destPath, conflictErr = ResolveConflict(d.conflictPolicy(entry), reserved, entry.URL, resp, names)
+	})
+	defer names.Release(reserved)
+	if action == ActionRenamed {
+		defer names.Release(destPath)
+	}
+
+	bar := pb.New64(contentLength)
+	board.Add(bar)
+	defer bar.Finish()
+
+	if ctx.Err() != nil {
+		return bar
+	}
+	if conflictErr != nil {
+		d.report(sugar, Result{URL: entry.URL, Action: ActionFailed, Err: conflictErr})
+		return bar
+	}
+	if destPath == "" {
+		bar.SetCurrent(contentLength)
+		d.report(sugar, Result{URL: entry.URL, Path: ResponsePath(dir, entry, resp), Action: action})
+		return bar
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(destPath), 0755); mkdirErr != nil {
+		sugar.Errorw("Error creating directory", "url", entry.URL, "err", mkdirErr)
+		d.report(sugar, Result{URL: entry.URL, Path: destPath, Action: ActionFailed, Err: mkdirErr})
+		return bar
+	}
+
+	if !held {
+		if !budget.acquire(ctx) {
+			return bar
+		}
+		defer budget.release(1)
+	}
+
+	var downloadErr error
+	if connections := connectionCount(contentLength, d.Segments); segmentable && action != ActionResumed && connections > 1 &&
+		(budget.available() > 0 || resumesInSegments(destPath)) {
+		sugar.Infow("Downloading in segments", "url", entry.URL, "connections", connections)
+		downloadErr = d.downloadInSegments(ctx, entry, destPath, connections, budget, bar)
+	} else {
+		downloadErr = d.downloadEntry(ctx, entry, destPath, bar)
+	}
+	// The failure reaches the user through OnResult, so it is only logged
+	// here
+	if downloadErr != nil {
+		sugar.Errorw("Error downloading", "url", entry.URL, "err", downloadErr)
+		action = ActionFailed
+	}
+	d.report(sugar, Result{URL: entry.URL, Path: destPath, Action: action, Err: downloadErr})
+	return bar
+}
+
+// resumesInSegments reports whether an earlier segmented download of
+// destPath left progress behind, which only a segmented download can
+// continue, even over a single connection.
+func resumesInSegments(destPath string) bool {
+	manifest, err := LoadManifest(ManifestPath(destPath))
+	return err == nil && len(manifest.Segments) > 0
+}
+
+// HostWaiter is implemented by clients that hold back requests to busy or
+// failing hosts. HostReady reports whether a request to the host of url
+// would be sent right away, and WaitHost waits until it would.
+type HostWaiter interface {
+	HostReady(url string) bool
+	WaitHost(ctx context.Context, url string) error
+}
+
+// queuedEntries returns how many entries may wait for their host when
+// downloading over the given number of connections.
+func queuedEntries(threads int) int {
+	if threads < 4 {
+		threads = 4
+	}
+	return 4 * threads
+}
+
+// clampThreads keeps threads within min and max, where zero means no bound.
+func clampThreads(threads, min, max int) int {
+	if max > 0 && threads > max {
+		threads = max
+	}
+	if threads < min {
+		threads = min
+	}
+	return threads
+}
+
+// segmentable reports whether the file described by the HEAD response resp
+// is worth downloading in segments.
+func (d *Downloader) segmentable(resp *http.Response) bool {
+	threshold := d.SegmentThreshold
+	if threshold <= 0 {
+		threshold = DefaultSegmentThreshold
+	}
+	return d.Segments > 1 && resp.StatusCode == http.StatusOK &&
+		resp.Header.Get("Accept-Ranges") == "bytes" && resp.ContentLength >= threshold
+}
+
+// downloadInSegments downloads entry to destPath over up to the given number
+// of connections, all but the first of which are taken from budget as they
+// become free.
+func (d *Downloader) downloadInSegments(ctx context.Context, entry clients.DownloadEntry, destPath string, connections int, budget *connBudget, bar *pb.ProgressBar) error {
+	manager := d.SegmentManager
+	if manager == nil {
+		manager = &PreallocatedSegmentManager{}
+	}
+	segmented := &SegmentedDownloader{
+		Client:         d.Client,
+		SegmentManager: manager,
+		Retry:          d.Retry,
+		Quarantine:     d.Quarantine,
+		budget:         budget,
+		hosts:          d.hosts,
+	}
+	return segmented.downloadEntryInSegments(ctx, entry, destPath, connections, bar)
+}
+
+// report logs the outcome of a download and hands it to OnResult.
+func (d *Downloader) report(sugar *zap.SugaredLogger, result Result) {
+	if result.Err == nil {
+		sugar.Infow("Download finished", "url", result.URL, "destPath", result.Path, "action", result.Action)
+	}
+	if d.OnResult != nil {
+		d.OnResult(result)
+	}
+}
+
+// headWithRetry makes a HEAD request, retrying transport errors and
+// retryable status codes according to policy. Only the status of a response
+// other than 2xx is returned, without the headers or the URL it was
+// redirected to, so that an error page is never taken for the file: its
+// size is unknown and the file is named after url.
+func headWithRetry(ctx context.Context, policy *clients.RetryPolicy, client clients.HttpClient, url string) (*http.Response, error) {
+	var resp *http.Response
+	err := policy.Do(ctx, func(attempt int) error {
+		var err error
+		resp, err = client.Head(url)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return clients.NewStatusError(resp)
+		}
+		return nil
+	})
+	var statusErr *clients.StatusError
+	if errors.As(err, &statusErr) {
+		return &http.Response{
+			Status:        statusErr.Status,
+			StatusCode:    statusErr.StatusCode,
+			Header:        http.Header{},
+			ContentLength: -1,
+			Body:          http.NoBody,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
@@ -1,28 +1,53 @@
 package downloader
 
 import (
+	"GoDownload/checksum"
 	"GoDownload/clients"
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/cheggaaa/pb/v3"
+	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 var setupOnce sync.Once
 var ctx context.Context
 var sugar *zap.SugaredLogger
 
+// requestMatcher matches an *http.Request by method and URL.
+type requestMatcher struct {
+	method string
+	url    string
+}
+
+func (m requestMatcher) Matches(x interface{}) bool {
+	req, ok := x.(*http.Request)
+	return ok && req.Method == m.method && req.URL.String() == m.url
+}
+
+func (m requestMatcher) String() string {
+	return fmt.Sprintf("is a %s request for %s", m.method, m.url)
+}
+
+func getRequest(url string) gomock.Matcher {
+	return requestMatcher{method: http.MethodGet, url: url}
+}
+
 func setup() {
 	// This code will be run once, regardless of how many tests call the setup function.
 	logger, _ := zap.NewDevelopment()
@@ -38,7 +63,7 @@ func TestDownloader_DownloadFile_Success(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockClient := clients.NewMockHttpClient(ctrl)
-	mockClient.EXPECT().Get("https://www.example.com").Return(&http.Response{
+	mockClient.EXPECT().Do(gomock.Any(), getRequest("https://www.example.com")).Return(&http.Response{
 		StatusCode: http.StatusOK,
 		Body:       io.NopCloser(bytes.NewBufferString("Hello World")),
 	}, nil).Times(1)
@@ -75,7 +100,7 @@ func TestDownloadFile_FileAlreadyExists(t *testing.T) {
 		StatusCode: http.StatusOK,
 		Body:       ioutil.NopCloser(strings.NewReader("file content")),
 	}
-	mockClient.EXPECT().Get("https://example.com/file.txt").Return(mockResponse, nil).Times(0) // Expect no call
+	mockClient.EXPECT().Do(gomock.Any(), getRequest("https://example.com/file.txt")).Return(mockResponse, nil).Times(0) // Expect no call
 	bar := pb.New64(100)
 
 	// Create a temporary directory
@@ -111,7 +136,7 @@ func TestDownloader_DownloadFile_HttpError(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockClient := clients.NewMockHttpClient(ctrl)
-	mockClient.EXPECT().Get("https://www.example.com/notfound").Return(&http.Response{
+	mockClient.EXPECT().Do(gomock.Any(), getRequest("https://www.example.com/notfound")).Return(&http.Response{
 		StatusCode: http.StatusNotFound,
 		Status:     "404 Not Found",
 		Body:       io.NopCloser(bytes.NewBufferString("Not Found")),
@@ -132,7 +157,7 @@ func TestDownloader_DownloadFile_ClientError(t *testing.T) {
 	defer ctrl.Finish()
 
 	mockClient := clients.NewMockHttpClient(ctrl)
-	mockClient.EXPECT().Get("https://www.example.com").Return(nil, errors.New("client error")).Times(1)
+	mockClient.EXPECT().Do(gomock.Any(), getRequest("https://www.example.com")).Return(nil, errors.New("client error")).Times(1)
 
 	downloader := New(mockClient)
 	bar := pb.New64(100)
@@ -163,7 +188,7 @@ func TestDownloadFiles(t *testing.T) {
 		StatusCode: http.StatusOK,
 		Body:       ioutil.NopCloser(bytes.NewReader([]byte("file content"))),
 	}
-	mockHttpClient.EXPECT().Get("https://example.com/file.txt").Return(mockGetResponse, nil).Times(1)
+	mockHttpClient.EXPECT().Do(gomock.Any(), getRequest("https://example.com/file.txt")).Return(mockGetResponse, nil).Times(1)
 
 	// Create a downloader instance with the mock HTTP client
 	dl := New(mockHttpClient)
@@ -258,7 +283,7 @@ func TestDownloadFile_ErrorCreatingFile(t *testing.T) {
 		StatusCode: http.StatusOK,
 		Body:       ioutil.NopCloser(bytes.NewReader([]byte("file content"))),
 	}
-	mockHttpClient.EXPECT().Get("https://example.com/file.txt").Return(mockResponse, nil).Times(1)
+	mockHttpClient.EXPECT().Do(gomock.Any(), getRequest("https://example.com/file.txt")).Return(mockResponse, nil).Times(1)
 
 	// Create a downloader instance with the mock HTTP client
 	dl := New(mockHttpClient)
@@ -270,7 +295,683 @@ func TestDownloadFile_ErrorCreatingFile(t *testing.T) {
 	err := dl.DownloadFile("https://example.com/file.txt", invalidPath, nil, ctx)
 
 	// Assertion: Check if there's an error when trying to create the file
-	if err == nil || !strings.Contains(err.Error(), "open /invalid_path/file.txt: no such file or directory") {
+	if err == nil || !strings.Contains(err.Error(), "/invalid_path/file.txt") || !strings.Contains(err.Error(), "no such file or directory") {
 		t.Fatalf("Expected error when creating file, got: %v", err)
 	}
 }
+
+// newRangeServer serves content with ServeContent, which implements Range and
+// If-Range, and records the Range header of every request it receives.
+func newRangeServer(t *testing.T, content string, etag string, ranges *[]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// writePartial simulates an interrupted earlier attempt.
+func writePartial(t *testing.T, destPath string, url string, etag string, data string) {
+	if err := ioutil.WriteFile(PartialPath(destPath), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+	manifest := &Manifest{URL: url, ETag: etag}
+	if err := manifest.Save(ManifestPath(destPath)); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+}
+
+func TestDownloadFile_ResumesPartial(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	writePartial(t, destPath, ts.URL, `"v1"`, "Hello")
+
+	bar := pb.New64(11)
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, bar, ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+	assert.Equal(t, []string{"bytes=5-"}, ranges)
+	assert.Equal(t, int64(11), bar.Current())
+
+	// The partial file and manifest are cleaned up once the file is complete
+	_, err = os.Stat(PartialPath(destPath))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(ManifestPath(destPath))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadFile_RestartsWhenResourceChanged(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v2"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	writePartial(t, destPath, ts.URL, `"v1"`, "Stale")
+
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+	assert.Equal(t, []string{"bytes=5-"}, ranges)
+}
+
+func TestDownloadFile_RestartsWhenRangeIgnored(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("Hello World"))
+	}))
+	defer ts.Close()
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	writePartial(t, destPath, ts.URL, `"v1"`, "Hello")
+
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+}
+
+func TestDownloadFile_PartialAlreadyComplete(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	writePartial(t, destPath, ts.URL, `"v1"`, "Hello World")
+
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+	assert.Equal(t, []string{"bytes=11-"}, ranges)
+}
+
+func TestDownloadFile_IgnoresSegmentedPartial(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	// An interrupted segmented download of the same file, whose partial
+	// file is preallocated to the full size
+	writePartial(t, destPath, ts.URL, `"v1"`, strings.Repeat("\x00", 11))
+	manifest := &Manifest{URL: ts.URL, Size: 11, ETag: `"v1"`, Segments: newSegments(11, 2)}
+	manifest.Segments[0].SetCompleted(3)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+	assert.Equal(t, []string{""}, ranges)
+}
+
+func TestDownloadFiles_ContinuesSegmentedPartial(t *testing.T) {
+	setupOnce.Do(setup)
+	defer func(size int64) { minSegmentSize = size }(minSegmentSize)
+	minSegmentSize = 12
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	ts, ranges := newSegmentServer(t, content, nil)
+	dir := t.TempDir()
+	destPath := filepath.Join(dir, "file.bin")
+
+	// The first of three segments made it to disk before an interruption
+	partial := []byte(content[:12] + strings.Repeat("\x00", 24))
+	assert.NoError(t, ioutil.WriteFile(PartialPath(destPath), partial, 0644))
+	manifest := &Manifest{URL: ts.URL + "/file.bin", Size: 36, ETag: `"v1"`, Segments: newSegments(36, 3)}
+	manifest.Segments[0].SetCompleted(12)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+
+	// A single connection leaves none to spare, yet the segments continue
+	dl := New(&clients.RealHttpClient{})
+	dl.Segments = 4
+	dl.SegmentThreshold = 10
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{ts.URL + "/file.bin"}}, dir, 1, ctx)
+
+	got, err := ioutil.ReadFile(destPath)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(got))
+	assert.ElementsMatch(t, []string{"bytes=12-23", "bytes=24-35"}, *ranges)
+}
+
+func TestDownloadFile_KeepsPartialOnInterruption(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "11")
+		w.Write([]byte("Hello"))
+		// Returning early makes the client see an unexpected EOF
+	}))
+	defer ts.Close()
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+
+	err := New(&clients.RealHttpClient{}).DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.Error(t, err)
+
+	partial, _ := ioutil.ReadFile(PartialPath(destPath))
+	assert.Equal(t, "Hello", string(partial))
+	manifest, err := LoadManifest(ManifestPath(destPath))
+	assert.NoError(t, err)
+	assert.Equal(t, `"v1"`, manifest.ETag)
+}
+
+func TestEntryPath(t *testing.T) {
+	tests := []struct {
+		entry    clients.DownloadEntry
+		expected string
+	}{
+		{clients.DownloadEntry{URL: "https://example.com/a/file.txt"}, "/data/file.txt"},
+		{clients.DownloadEntry{URL: "https://example.com/file.txt", Out: "renamed.txt"}, "/data/renamed.txt"},
+		{clients.DownloadEntry{URL: "https://example.com/file.txt", Dir: "sub/dir"}, "/data/sub/dir/file.txt"},
+		{clients.DownloadEntry{URL: "https://example.com/file.txt", Dir: "/elsewhere"}, "/elsewhere/file.txt"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, EntryPath("/data", tt.entry))
+	}
+}
+
+func TestDownloadFiles_EntryOptions(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var mirrorHeader string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHeader = r.Header.Get("Authorization")
+		w.Write([]byte("mirrored"))
+	}))
+	defer mirror.Close()
+
+	tempDir := t.TempDir()
+	input := primary.URL + "/file.txt " + mirror.URL + "/file.txt\n  out=renamed.txt\n  dir=sub\n  header=Authorization: Bearer secret\n"
+	provider := &clients.FileURLProvider{Filename: "-", Stdin: strings.NewReader(input)}
+
+	New(&clients.RealHttpClient{}).DownloadFiles(provider, tempDir, 1, ctx)
+
+	content, err := ioutil.ReadFile(filepath.Join(tempDir, "sub", "renamed.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mirrored", string(content))
+	assert.Equal(t, "Bearer secret", mirrorHeader)
+}
+
+func TestDownloadStream_BoundsConcurrency(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	var input strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&input, "%s/file-%d\n", ts.URL, i)
+	}
+	provider := &clients.FileURLProvider{Filename: "-", Stdin: strings.NewReader(input.String())}
+	tempDir := t.TempDir()
+
+	err := New(&clients.RealHttpClient{}).DownloadStream(provider, tempDir, 3, ctx)
+	assert.NoError(t, err)
+
+	files, _ := ioutil.ReadDir(tempDir)
+	assert.Len(t, files, 40)
+	assert.LessOrEqual(t, maxInFlight, 3)
+}
+
+func TestDownloadStream_ReturnsProviderError(t *testing.T) {
+	setupOnce.Do(setup)
+
+	provider := &clients.FileURLProvider{Filename: "-", Stdin: strings.NewReader("not-a-url\n")}
+	err := New(&clients.RealHttpClient{}).DownloadStream(provider, t.TempDir(), 1, ctx)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestDownloadFile_RetriesTransientErrors(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var mu sync.Mutex
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		attempt := len(ranges)
+		mu.Unlock()
+
+		w.Header().Set("ETag", `"v1"`)
+		switch attempt {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Cut the body short half way through
+			w.Header().Set("Content-Length", "11")
+			w.Write([]byte("Hello"))
+		default:
+			http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("Hello World"))
+		}
+	}))
+	defer ts.Close()
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Retry = clients.DefaultRetryPolicy()
+	dl.Retry.InitialBackoff = time.Millisecond
+	err := dl.DownloadFile(ts.URL, destPath, pb.New64(11), ctx)
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+	// The third attempt resumes after the five bytes the second one wrote
+	assert.Equal(t, []string{"", "", "bytes=5-"}, ranges)
+}
+
+func TestDownloadFile_DoesNotRetryPermanentErrors(t *testing.T) {
+	setupOnce.Do(setup)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Retry = clients.DefaultRetryPolicy()
+	err := dl.DownloadFile(ts.URL, filepath.Join(t.TempDir(), "file.txt"), pb.New64(11), ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestDownloadFiles_HeadAlwaysUnavailable(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var mu sync.Mutex
+	var heads int
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodHead {
+			heads++
+			// An error page that looks like a large, segmentable file
+			w.Header().Set("Content-Disposition", `attachment; filename="error.html"`)
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", "100000")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Write([]byte("Hello World"))
+	}))
+	defer ts.Close()
+	tempDir := t.TempDir()
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Retry = clients.DefaultRetryPolicy()
+	dl.Retry.InitialBackoff = time.Millisecond
+	dl.Segments = 4
+	dl.SegmentThreshold = 1
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{ts.URL + "/file.txt"}}, tempDir, 4, ctx)
+
+	assert.Equal(t, dl.Retry.MaxAttempts, heads)
+	// Neither the name nor the size of the error page was used
+	content, err := ioutil.ReadFile(filepath.Join(tempDir, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", string(content))
+	assert.Equal(t, []string{""}, ranges)
+	assert.NoFileExists(t, filepath.Join(tempDir, "error.html"))
+}
+
+const helloWorldSHA256 = "sha-256=a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e"
+
+func TestDownloadEntry_VerifiesChecksum(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: helloWorldSHA256}
+	err := New(&clients.RealHttpClient{}).downloadEntry(ctx, entry, destPath, pb.New64(11))
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+}
+
+func TestDownloadEntry_VerifiesResumedChecksum(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	writePartial(t, destPath, ts.URL, `"v1"`, "Hello")
+
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: helloWorldSHA256}
+	err := New(&clients.RealHttpClient{}).downloadEntry(ctx, entry, destPath, pb.New64(11))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes=5-"}, ranges)
+}
+
+func TestDownloadEntry_ChecksumMismatchDeletesFile(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World!", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: helloWorldSHA256}
+	err := New(&clients.RealHttpClient{}).downloadEntry(ctx, entry, destPath, pb.New64(12))
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+
+	for _, path := range []string{destPath, PartialPath(destPath), ManifestPath(destPath)} {
+		_, statErr := os.Stat(path)
+		assert.True(t, os.IsNotExist(statErr), "%s should have been removed", path)
+	}
+}
+
+func TestDownloadEntry_ChecksumMismatchQuarantinesFile(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var ranges []string
+	ts := newRangeServer(t, "Hello World!", `"v1"`, &ranges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Quarantine = quarantine
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: helloWorldSHA256}
+	err := dl.downloadEntry(ctx, entry, destPath, pb.New64(12))
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+
+	content, _ := ioutil.ReadFile(filepath.Join(quarantine, "file.txt"))
+	assert.Equal(t, "Hello World!", string(content))
+	_, statErr := os.Stat(destPath)
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestDownloadEntry_ChecksumMismatchTriesMirror(t *testing.T) {
+	setupOnce.Do(setup)
+
+	var badRanges, goodRanges []string
+	bad := newRangeServer(t, "Hello World!", `"v1"`, &badRanges)
+	good := newRangeServer(t, "Hello World", `"v1"`, &goodRanges)
+	destPath := filepath.Join(t.TempDir(), "file.txt")
+
+	entry := clients.DownloadEntry{URL: bad.URL, Mirrors: []string{good.URL}, Checksum: helloWorldSHA256}
+	err := New(&clients.RealHttpClient{}).downloadEntry(ctx, entry, destPath, pb.New64(11))
+	assert.NoError(t, err)
+
+	content, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, "Hello World", string(content))
+}
+
+func TestDownloadFiles_ReportsChecksumMismatch(t *testing.T) {
+	var ranges []string
+	ts := newRangeServer(t, "Hello World!", `"v1"`, &ranges)
+	core, logs := observer.New(zap.ErrorLevel)
+	logCtx := context.WithValue(context.Background(), "sugar", zap.New(core).Sugar())
+
+	// Failures belong in the results, not on stdout next to them
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var results []Result
+	dl := New(&clients.RealHttpClient{})
+	dl.OnResult = func(result Result) { results = append(results, result) }
+	provider := &clients.MappedURLProvider{
+		Provider: &clients.StaticURLProvider{URLs: []string{ts.URL + "/file.txt"}},
+		Map: func(entry clients.DownloadEntry) clients.DownloadEntry {
+			entry.Checksum = helloWorldSHA256
+			return entry
+		},
+	}
+	dl.DownloadFiles(provider, t.TempDir(), 1, logCtx)
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+
+	assert.Empty(t, string(printed))
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, ActionFailed, results[0].Action)
+		assert.ErrorIs(t, results[0].Err, checksum.ErrMismatch)
+	}
+	assert.Equal(t, 1, logs.FilterMessage("Error downloading").Len())
+}
+
+func TestDownloadEntry_InvalidChecksum(t *testing.T) {
+	setupOnce.Do(setup)
+
+	entry := clients.DownloadEntry{URL: "http://example.com/file.txt", Checksum: "crc32=deadbeef"}
+	err := New(&clients.RealHttpClient{}).downloadEntry(ctx, entry, filepath.Join(t.TempDir(), "file.txt"), nil)
+	assert.ErrorContains(t, err, "unsupported checksum algorithm")
+}
+
+// rangeServer serves content, optionally advertising range support, and
+// counts the range requests it receives.
+func rangeServer(t *testing.T, content []byte, acceptRanges bool) (*httptest.Server, *int32) {
+	var mu sync.Mutex
+	var ranged int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptRanges {
+			w.Write(content)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranged++
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &ranged
+}
+
+func TestDownloadFiles_SegmentsLargeFiles(t *testing.T) {
+	setupOnce.Do(setup)
+	defer func(size int64) { minSegmentSize = size }(minSegmentSize)
+	minSegmentSize = 1000
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	ts, ranged := rangeServer(t, content, true)
+	dir := t.TempDir()
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Segments = 4
+	dl.SegmentThreshold = 1000
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{ts.URL + "/big.bin"}}, dir, 4, ctx)
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.Equal(t, int32(4), *ranged)
+	_, err = os.Stat(ManifestPath(filepath.Join(dir, "big.bin")))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadFiles_AppliesMiddlewareToSegments(t *testing.T) {
+	setupOnce.Do(setup)
+	defer func(size int64) { minSegmentSize = size }(minSegmentSize)
+	minSegmentSize = 1000
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	var mu sync.Mutex
+	var requests, tagged int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		if r.Header.Get("X-Run") == "nightly" {
+			tagged++
+		}
+		mu.Unlock()
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+
+	dl := New(&clients.RealHttpClient{})
+	dl.Segments = 4
+	dl.SegmentThreshold = 1000
+	dl.Middleware = []clients.Middleware{clients.SetHeader("X-Run", "nightly")}
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{ts.URL + "/big.bin"}}, dir, 4, ctx)
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.Greater(t, requests, 4)
+	assert.Equal(t, requests, tagged)
+}
+
+func TestDownloadFiles_StreamsWhenNotSegmentable(t *testing.T) {
+	setupOnce.Do(setup)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	tests := []struct {
+		name         string
+		acceptRanges bool
+		threshold    int64
+		threads      int
+	}{
+		{"below threshold", true, int64(len(content)) + 1, 4},
+		{"no range support", false, 1000, 4},
+		{"no spare connections", true, 1000, 1},
+	}
+	for _, tt := range tests {
+		ts, ranged := rangeServer(t, content, tt.acceptRanges)
+		dir := t.TempDir()
+
+		dl := New(&clients.RealHttpClient{})
+		dl.Segments = 4
+		dl.SegmentThreshold = tt.threshold
+		dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{ts.URL + "/big.bin"}}, dir, tt.threads, ctx)
+
+		got, err := ioutil.ReadFile(filepath.Join(dir, "big.bin"))
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, content, got, tt.name)
+		assert.Equal(t, int32(0), *ranged, tt.name)
+	}
+}
+
+// pausedHost is a HostWaiter that holds back one host until released.
+type pausedHost struct {
+	clients.HttpClient
+	host    string
+	resumed chan struct{}
+}
+
+func (p *pausedHost) HostReady(rawURL string) bool {
+	if !strings.Contains(rawURL, p.host) {
+		return true
+	}
+	select {
+	case <-p.resumed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pausedHost) WaitHost(ctx context.Context, rawURL string) error {
+	if !strings.Contains(rawURL, p.host) {
+		return nil
+	}
+	select {
+	case <-p.resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestDownloadFiles_OtherHostsOvertakePausedHost(t *testing.T) {
+	setupOnce.Do(setup)
+	paused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("paused"))
+	}))
+	defer paused.Close()
+	ready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ready"))
+	}))
+	defer ready.Close()
+	dir := t.TempDir()
+
+	client := &pausedHost{HttpClient: &clients.RealHttpClient{}, host: paused.Listener.Addr().String(), resumed: make(chan struct{})}
+	var mu sync.Mutex
+	var order []string
+	dl := New(client)
+	dl.OnResult = func(result Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, filepath.Base(result.Path))
+		if len(order) == 2 {
+			close(client.resumed)
+		}
+	}
+	urls := []string{paused.URL + "/a.txt", ready.URL + "/b.txt", ready.URL + "/c.txt"}
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: urls}, dir, 1, ctx)
+
+	assert.Equal(t, []string{"b.txt", "c.txt", "a.txt"}, order)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "a.txt"))
+	assert.Equal(t, "paused", string(content))
+}
+
+func TestDownloadFiles_RegisteredSchemes(t *testing.T) {
+	setupOnce.Do(setup)
+	defer func(size int64) { minSegmentSize = size }(minSegmentSize)
+	minSegmentSize = 1000
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	source := filepath.Join(t.TempDir(), "big.bin")
+	assert.NoError(t, os.WriteFile(source, content, 0644))
+	dir := t.TempDir()
+
+	// Local files are only served by clients opting in
+	client, err := clients.NewClientBuilder().Protocol(clients.FileScheme, &clients.FileProtocol{}).Build()
+	assert.NoError(t, err)
+	dl := New(client)
+	dl.Segments = 4
+	dl.SegmentThreshold = 1000
+	urls := []string{"file://" + filepath.ToSlash(source), "data:text/plain,inline%20text"}
+	bars := dl.DownloadFiles(&clients.StaticURLProvider{URLs: urls}, dir, 4, ctx)
+
+	assert.Len(t, bars, 2)
+	got, err := ioutil.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	got, err = ioutil.ReadFile(filepath.Join(dir, "index.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "inline text", string(got))
+}
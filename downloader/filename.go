@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"GoDownload/helpers"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// defaultFileName is used when neither the server nor the URL name the file.
+const defaultFileName = "index"
+
+// preferredExtensions pins the extension of common content types, since
+// mime.ExtensionsByType depends on the system's mime.types.
+var preferredExtensions = map[string]string{
+	"application/gzip":         ".gz",
+	"application/json":         ".json",
+	"application/octet-stream": "",
+	"application/pdf":          ".pdf",
+	"application/x-gzip":       ".gz",
+	"application/x-tar":        ".tar",
+	"application/xml":          ".xml",
+	"application/zip":          ".zip",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/svg+xml":            ".svg",
+	"text/css":                 ".css",
+	"text/csv":                 ".csv",
+	"text/html":                ".html",
+	"text/javascript":          ".js",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+}
+
+// Content-Disposition parameters that mime.ParseMediaType rejects, such as
+// unquoted names with spaces or filename* in a charset other than UTF-8.
+var (
+	extendedFilenameParam = regexp.MustCompile(`(?i)filename\*\s*=\s*([^';]+)'[^']*'([^;]+)`)
+	filenameParam         = regexp.MustCompile(`(?i)filename\s*=\s*(?:"((?:[^"\\]|\\.)*)"|([^;]+))`)
+)
+
+// ResolveFileName picks the name to save entry as, given the response to
+// its HEAD request, which may be nil. In order of preference it uses the
+// entry's out option, the Content-Disposition header, the last path segment
+// of the final URL after redirects and of the entry's URL. Every name, the
+// out option included, is sanitised, and an extension matching the
+// Content-Type is added when a name other than out has none.
+func ResolveFileName(entry clients.DownloadEntry, resp *http.Response) string {
+	if name := helpers.SanitizeFileName(entry.Out); name != "" {
+		return name
+	}
+
+	var candidates []string
+	if resp != nil {
+		candidates = append(candidates, contentDispositionName(resp.Header.Get("Content-Disposition")))
+		if resp.Request != nil && resp.Request.URL != nil {
+			candidates = append(candidates, helpers.GetFileNameFromURL(resp.Request.URL.String()))
+		}
+	}
+	candidates = append(candidates, helpers.GetFileNameFromURL(entry.URL))
+
+	name := ""
+	for _, candidate := range candidates {
+		if name = helpers.SanitizeFileName(candidate); name != "" {
+			break
+		}
+	}
+	if name == "" {
+		name = defaultFileName
+	}
+
+	if resp != nil && filepath.Ext(name) == "" {
+		name += contentTypeExtension(resp.Header.Get("Content-Type"))
+	}
+	return name
+}
+
+// contentDispositionName returns the file name suggested by a
+// Content-Disposition header, preferring the RFC 5987 filename* parameter.
+func contentDispositionName(header string) string {
+	if header == "" {
+		return ""
+	}
+	if _, params, err := mime.ParseMediaType(header); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+
+	if match := extendedFilenameParam.FindStringSubmatch(header); match != nil {
+		if name, ok := decodeExtendedValue(match[1], strings.TrimSpace(match[2])); ok {
+			return name
+		}
+	}
+	if match := filenameParam.FindStringSubmatch(header); match != nil {
+		if match[1] != "" {
+			return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(match[1])
+		}
+		return strings.TrimSpace(match[2])
+	}
+	return ""
+}
+
+// decodeExtendedValue decodes the percent-encoded value of an RFC 5987
+// parameter in the given charset.
+func decodeExtendedValue(charset string, value string) (string, bool) {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "utf-8", "us-ascii":
+		return decoded, true
+	case "iso-8859-1":
+		// Latin-1 bytes are the first 256 code points
+		runes := make([]rune, len(decoded))
+		for i := 0; i < len(decoded); i++ {
+			runes[i] = rune(decoded[i])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// contentTypeExtension returns the usual extension for a Content-Type, or
+// "" if there is none.
+func contentTypeExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// PathReserver makes sure no two downloads of a batch are saved to the same
+// path. Later claims on a taken path get a numbered variant, "file.1.txt",
+// "file.2.txt" and so on, so the outcome only depends on the order in which
+// paths are reserved. Only paths in use are held: a download releases its
+// path once it is over, so that a long stream does not grow the reserver
+// without bound, and a later claim on that path gets it back, leaving the
+// file found there to the conflict policy.
+type PathReserver struct {
+	mu    sync.Mutex
+	taken map[string]bool
+}
+
+func NewPathReserver() *PathReserver {
+	return &PathReserver{taken: map[string]bool{}}
+}
+
+// Reserve claims path, or the first free numbered variant of it, and
+// returns the claimed path.
+func (r *PathReserver) Reserve(path string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	candidate := path
+	for i := 1; r.taken[candidate]; i++ {
+		candidate = numberedPath(path, i)
+	}
+	r.taken[candidate] = true
+	return candidate
+}
+
+// TryReserve claims path if it is free, reporting whether it was.
+func (r *PathReserver) TryReserve(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.taken[path] {
+		return false
+	}
+	r.taken[path] = true
+	return true
+}
+
+// Release gives up a path claimed by Reserve or TryReserve.
+func (r *PathReserver) Release(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.taken, path)
+}
+
+// numberedPath inserts n before the extension of path: file.txt becomes
+// file.n.txt.
+func numberedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
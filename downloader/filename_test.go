@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func response(finalURL string, header map[string]string) *http.Response {
+	resp := &http.Response{Header: http.Header{}}
+	if finalURL != "" {
+		u, _ := url.Parse(finalURL)
+		resp.Request = &http.Request{URL: u}
+	}
+	for name, value := range header {
+		resp.Header.Set(name, value)
+	}
+	return resp
+}
+
+func TestResolveFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    clients.DownloadEntry
+		resp     *http.Response
+		expected string
+	}{
+		{"url only", clients.DownloadEntry{URL: "https://example.com/a/file.txt"}, nil, "file.txt"},
+		{"query stripped", clients.DownloadEntry{URL: "https://example.com/download?id=7"}, nil, "download"},
+		{"percent decoded", clients.DownloadEntry{URL: "https://example.com/my%20file.txt"}, nil, "my file.txt"},
+		{"trailing slash", clients.DownloadEntry{URL: "https://example.com/dir/"}, nil, "index"},
+		{"out wins", clients.DownloadEntry{URL: "https://example.com/file.txt", Out: "renamed.txt"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename="server.txt"`}), "renamed.txt"},
+		{"traversal in out", clients.DownloadEntry{URL: "https://example.com/file.txt", Out: "../../.bashrc"}, nil, "_.._.bashrc"},
+		{"unusable out ignored", clients.DownloadEntry{URL: "https://example.com/file.txt", Out: ".."}, nil, "file.txt"},
+		{"content disposition", clients.DownloadEntry{URL: "https://example.com/download?id=7"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename="report.pdf"`}), "report.pdf"},
+		{"rfc 5987 preferred", clients.DownloadEntry{URL: "https://example.com/download"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename="EUR rates.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`}), "€ rates.txt"},
+		{"rfc 5987 latin-1", clients.DownloadEntry{URL: "https://example.com/download"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename*=iso-8859-1'en'%A3%20rates.txt`}), "£ rates.txt"},
+		{"unquoted with spaces", clients.DownloadEntry{URL: "https://example.com/download"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename=my report.pdf`}), "my report.pdf"},
+		{"traversal in header", clients.DownloadEntry{URL: "https://example.com/download"},
+			response("", map[string]string{"Content-Disposition": `attachment; filename="../../.bashrc"`}), "_.._.bashrc"},
+		{"final url after redirect", clients.DownloadEntry{URL: "https://example.com/latest"},
+			response("https://cdn.example.com/releases/app-1.2.3.tar.gz?token=abc", nil), "app-1.2.3.tar.gz"},
+		{"extension from content type", clients.DownloadEntry{URL: "https://example.com/export?format=json"},
+			response("https://example.com/export?format=json", map[string]string{"Content-Type": "application/json; charset=utf-8"}), "export.json"},
+		{"index with extension", clients.DownloadEntry{URL: "https://example.com/"},
+			response("https://example.com/", map[string]string{"Content-Type": "text/html"}), "index.html"},
+		{"octet stream adds nothing", clients.DownloadEntry{URL: "https://example.com/blob"},
+			response("", map[string]string{"Content-Type": "application/octet-stream"}), "blob"},
+		{"existing extension kept", clients.DownloadEntry{URL: "https://example.com/data.csv"},
+			response("", map[string]string{"Content-Type": "text/plain"}), "data.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ResolveFileName(tt.entry, tt.resp))
+		})
+	}
+}
+
+func TestPathReserver(t *testing.T) {
+	names := NewPathReserver()
+	assert.Equal(t, "/data/file.txt", names.Reserve("/data/file.txt"))
+	assert.Equal(t, "/data/file.1.txt", names.Reserve("/data/file.txt"))
+	assert.Equal(t, "/data/file.2.txt", names.Reserve("/data/file.txt"))
+	assert.Equal(t, "/other/file.txt", names.Reserve("/other/file.txt"))
+	assert.Equal(t, "/data/README.1", names.Reserve("/data/README.1"))
+	assert.Equal(t, "/data/README", names.Reserve("/data/README"))
+	assert.Equal(t, "/data/README.2", names.Reserve("/data/README"))
+
+	names.Release("/data/file.txt")
+	names.Release("/data/file.1.txt")
+	assert.Equal(t, "/data/file.txt", names.Reserve("/data/file.txt"))
+	assert.Equal(t, "/data/file.1.txt", names.Reserve("/data/file.txt"))
+	assert.Equal(t, "/data/file.3.txt", names.Reserve("/data/file.txt"))
+	assert.True(t, names.TryReserve("/data/released"))
+	names.Release("/data/released")
+	assert.True(t, names.TryReserve("/data/released"))
+}
+
+func TestDownloadFiles_ResolvesCollisionsInOrder(t *testing.T) {
+	setupOnce.Do(setup)
+
+	// The first server answers last, yet its file keeps the plain name
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			<-time.After(100 * time.Millisecond)
+		}
+		w.Write([]byte("first"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	}))
+	defer fast.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fast.URL+"/file.txt?signature=xyz", http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	tempDir := t.TempDir()
+	provider := &clients.StaticURLProvider{URLs: []string{slow.URL + "/file.txt", redirect.URL + "/latest"}}
+	New(&clients.RealHttpClient{}).DownloadFiles(provider, tempDir, 2, ctx)
+
+	first, _ := ioutil.ReadFile(filepath.Join(tempDir, "file.txt"))
+	second, _ := ioutil.ReadFile(filepath.Join(tempDir, "file.1.txt"))
+	assert.Equal(t, "first", string(first))
+	assert.Equal(t, "second", string(second))
+}
@@ -5,11 +5,11 @@
 package downloader
 
 import (
-	"GoDownload/clients"
+	clients "GoDownload/clients"
 	context "context"
 	reflect "reflect"
 
-	v3 "github.com/cheggaaa/pb/v3"
+	pb "github.com/cheggaaa/pb/v3"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -37,7 +37,7 @@ func (m *MockDownloaderInterface) EXPECT() *MockDownloaderInterfaceMockRecorder
 }
 
 // DownloadFile mocks base method.
-func (m *MockDownloaderInterface) DownloadFile(url, destPath string, bar *v3.ProgressBar, ctx context.Context) error {
+func (m *MockDownloaderInterface) DownloadFile(url, destPath string, bar *pb.ProgressBar, ctx context.Context) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DownloadFile", url, destPath, bar, ctx)
 	ret0, _ := ret[0].(error)
@@ -51,10 +51,10 @@ func (mr *MockDownloaderInterfaceMockRecorder) DownloadFile(url, destPath, bar,
 }
 
 // DownloadFiles mocks base method.
-func (m *MockDownloaderInterface) DownloadFiles(provider clients.URLProvider, dir string, threads int, ctx context.Context) []*v3.ProgressBar {
+func (m *MockDownloaderInterface) DownloadFiles(provider clients.URLProvider, dir string, threads int, ctx context.Context) []*pb.ProgressBar {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DownloadFiles", provider, dir, threads, ctx)
-	ret0, _ := ret[0].([]*v3.ProgressBar)
+	ret0, _ := ret[0].([]*pb.ProgressBar)
 	return ret0
 }
 
@@ -64,6 +64,20 @@ func (mr *MockDownloaderInterfaceMockRecorder) DownloadFiles(provider, dir, thre
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFiles", reflect.TypeOf((*MockDownloaderInterface)(nil).DownloadFiles), provider, dir, threads, ctx)
 }
 
+// DownloadStream mocks base method.
+func (m *MockDownloaderInterface) DownloadStream(provider clients.StreamingURLProvider, dir string, threads int, ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadStream", provider, dir, threads, ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadStream indicates an expected call of DownloadStream.
+func (mr *MockDownloaderInterfaceMockRecorder) DownloadStream(provider, dir, threads, ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadStream", reflect.TypeOf((*MockDownloaderInterface)(nil).DownloadStream), provider, dir, threads, ctx)
+}
+
 // MockDownloaderFactory is a mock of DownloaderFactory interface.
 type MockDownloaderFactory struct {
 	ctrl     *gomock.Controller
@@ -36,31 +36,31 @@ func (m *MockSegmentManager) EXPECT() *MockSegmentManagerMockRecorder {
 }
 
 // DownloadSegment mocks base method.
-func (m *MockSegmentManager) DownloadSegment(ctx context.Context, client clients.HttpClient, url string, start, end int64, destPath string) error {
+func (m *MockSegmentManager) DownloadSegment(ctx context.Context, client clients.HttpClient, url string, segment *Segment, destPath string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DownloadSegment", ctx, client, url, start, end, destPath)
+	ret := m.ctrl.Call(m, "DownloadSegment", ctx, client, url, segment, destPath)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DownloadSegment indicates an expected call of DownloadSegment.
-func (mr *MockSegmentManagerMockRecorder) DownloadSegment(ctx, client, url, start, end, destPath interface{}) *gomock.Call {
+func (mr *MockSegmentManagerMockRecorder) DownloadSegment(ctx, client, url, segment, destPath interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadSegment", reflect.TypeOf((*MockSegmentManager)(nil).DownloadSegment), ctx, client, url, start, end, destPath)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadSegment", reflect.TypeOf((*MockSegmentManager)(nil).DownloadSegment), ctx, client, url, segment, destPath)
 }
 
 // MergeSegments mocks base method.
-func (m *MockSegmentManager) MergeSegments(destPath string, segmentCount int) error {
+func (m *MockSegmentManager) MergeSegments(destPath string, segments []*Segment) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "MergeSegments", destPath, segmentCount)
+	ret := m.ctrl.Call(m, "MergeSegments", destPath, segments)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // MergeSegments indicates an expected call of MergeSegments.
-func (mr *MockSegmentManagerMockRecorder) MergeSegments(destPath, segmentCount interface{}) *gomock.Call {
+func (mr *MockSegmentManagerMockRecorder) MergeSegments(destPath, segments interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeSegments", reflect.TypeOf((*MockSegmentManager)(nil).MergeSegments), destPath, segmentCount)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeSegments", reflect.TypeOf((*MockSegmentManager)(nil).MergeSegments), destPath, segments)
 }
 
 // MockSegmentManagerFactory is a mock of SegmentManagerFactory interface.
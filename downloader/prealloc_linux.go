@@ -0,0 +1,22 @@
+//go:build linux
+
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk space for file, so that writing
+// segments out of order neither fragments it nor fails half way for lack of
+// space. File systems without fallocate get a sparse file instead.
+func preallocate(file *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return file.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package downloader
+
+import "os"
+
+// preallocate sizes file to size bytes, leaving it sparse where the file
+// system allows.
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Preallocator is implemented by segment managers that write every segment
+// into one file allocated up front. Preallocate is called before any
+// segment is downloaded and reports whether the data of an earlier attempt
+// was kept; if not, the segments start over.
+type Preallocator interface {
+	Preallocate(destPath string, size int64) (kept bool, err error)
+}
+
+// PreallocatedSegmentManager writes each segment straight to its offset in
+// a partial file the size of the whole download, which is renamed into
+// place once all segments are complete. Unlike FileSegmentManager, no data
+// is copied after downloading.
+type PreallocatedSegmentManager struct{}
+
+var _ Preallocator = &PreallocatedSegmentManager{}
+
+// Preallocate reserves size bytes for the partial file of destPath. A
+// partial file of exactly that size is left as it is, so that an
+// interrupted download can continue writing into it.
+func (m *PreallocatedSegmentManager) Preallocate(destPath string, size int64) (bool, error) {
+	partPath := PartialPath(destPath)
+	if info, err := os.Stat(partPath); err == nil && info.Mode().IsRegular() && info.Size() == size {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if err := preallocate(file, size); err != nil {
+		return false, err
+	}
+	return false, file.Close()
+}
+
+// DownloadSegment fetches the bytes of segment that are not on disk yet and
+// writes them at their offset in the partial file.
+func (m *PreallocatedSegmentManager) DownloadSegment(ctx context.Context, client clients.HttpClient, url string, segment *Segment, destPath string) error {
+	if segment.Done() {
+		return nil
+	}
+
+	file, err := os.OpenFile(PartialPath(destPath), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start, end := segment.Remaining()
+	req, err := newRangeRequest(url, segment, start, end)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkRangeResponse(req, resp, segment); err != nil {
+		return err
+	}
+
+	// The segment only counts bytes once they have been written
+	if _, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(file, start), segmentWriter{segment}), newSegmentReader(segment, resp.Body)); err != nil {
+		return err
+	}
+	if !segment.Done() {
+		return io.ErrUnexpectedEOF
+	}
+	return file.Close()
+}
+
+// MergeSegments moves the completed partial file into place.
+func (m *PreallocatedSegmentManager) MergeSegments(destPath string, segments []*Segment) error {
+	for _, segment := range segments {
+		if !segment.Done() {
+			return fmt.Errorf("segment %d of %s is incomplete", segment.Index, destPath)
+		}
+	}
+	return os.Rename(PartialPath(destPath), destPath)
+}
@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPreallocatedDownloader() *SegmentedDownloader {
+	return &SegmentedDownloader{Client: &clients.RealHttpClient{}, SegmentManager: &PreallocatedSegmentManager{}}
+}
+
+func TestPreallocated_Download(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts, ranges := newSegmentServer(t, content, nil)
+
+	err := newPreallocatedDownloader().DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bytes=0-11", "bytes=12-23", "bytes=24-35"}, *ranges)
+
+	got, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(got))
+	for _, leftover := range []string{PartialPath(destPath), ManifestPath(destPath), segmentPath(destPath, 0)} {
+		_, err = os.Stat(leftover)
+		assert.True(t, os.IsNotExist(err), leftover)
+	}
+}
+
+func TestPreallocated_ResumesAfterFailedSegment(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	failStart := int64(12)
+	ts, ranges := newSegmentServer(t, content, &failStart)
+
+	downloader := newPreallocatedDownloader()
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.Error(t, err)
+
+	// The partial file keeps its full size, holes included
+	info, err := os.Stat(PartialPath(destPath))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+
+	failStart = -1
+	*ranges = nil
+	err = downloader.DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes=12-23"}, *ranges)
+
+	got, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(got))
+}
+
+func TestPreallocated_RestartsWithoutPartialFile(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts, ranges := newSegmentServer(t, content, nil)
+
+	// The manifest claims progress the partial file does not hold
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), ETag: `"v1"`, Segments: newSegments(int64(len(content)), 2)}
+	manifest.Segments[0].Add(5)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+
+	err := newPreallocatedDownloader().DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bytes=0-17", "bytes=18-35"}, *ranges)
+
+	got, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(got))
+}
+
+func TestPreallocated_RestartsOnOtherVersion(t *testing.T) {
+	content := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	// The server ignores If-Range, but its ranges carry the new ETag
+	ts, ifRanges := newChangedServer(t, content, false)
+
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), ETag: `"v1"`, Segments: newSegments(int64(len(content)), 3)}
+	manifest.Segments[0].Add(12)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+	stale := make([]byte, len(content))
+	copy(stale, "0123456789ab")
+	assert.NoError(t, ioutil.WriteFile(PartialPath(destPath), stale, 0644))
+
+	err := newPreallocatedDownloader().DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.NoError(t, err)
+	assert.Contains(t, *ifRanges, `"v1"`)
+
+	// Nothing of the earlier version is left
+	got, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(got))
+}
+
+func TestPreallocated_VerifiesChecksum(t *testing.T) {
+	ts, _ := newSegmentServer(t, "Hello World", nil)
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: "sha-256=a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e"}
+	err := newPreallocatedDownloader().DownloadEntryInSegments(entry, destPath, 3)
+	assert.NoError(t, err)
+}
+
+func TestPreallocated_MergeIncompleteSegments(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	manager := &PreallocatedSegmentManager{}
+	_, err := manager.Preallocate(destPath, 10)
+	assert.NoError(t, err)
+
+	err = manager.MergeSegments(destPath, newSegments(10, 2))
+	assert.Error(t, err)
+	_, err = os.Stat(destPath)
+	assert.True(t, os.IsNotExist(err))
+}
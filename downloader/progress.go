@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"fmt"
+	"github.com/cheggaaa/pb/v3"
+	"github.com/cheggaaa/pb/v3/termutil"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressRefreshRate is how often the progress board redraws.
+const progressRefreshRate = 200 * time.Millisecond
+
+// progressBoard draws the bars of the downloads in flight. Unlike pb.Pool it
+// lets go of a bar once it has finished and been drawn for the last time,
+// so memory stays bounded however many downloads pass through it. When out
+// is not a terminal nothing is drawn.
+type progressBoard struct {
+	out       io.Writer
+	mu        sync.Mutex
+	bars      []*pb.ProgressBar
+	lastLines int
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// startProgressBoard starts drawing to out until Stop is called.
+func startProgressBoard(out io.Writer) *progressBoard {
+	board := &progressBoard{out: out, stop: make(chan struct{}), stopped: make(chan struct{})}
+	if _, err := termutil.TerminalWidth(); err != nil {
+		close(board.stopped)
+		return board
+	}
+
+	go func() {
+		defer close(board.stopped)
+		ticker := time.NewTicker(progressRefreshRate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				board.draw()
+			case <-board.stop:
+				board.draw()
+				return
+			}
+		}
+	}()
+	return board
+}
+
+// Add starts bar and shows it on the board.
+func (b *progressBoard) Add(bar *pb.ProgressBar) {
+	bar.Set(pb.Static, true)
+	bar.Start()
+
+	b.mu.Lock()
+	b.bars = append(b.bars, bar)
+	b.mu.Unlock()
+}
+
+// Stop draws the final state of every bar and stops the board.
+func (b *progressBoard) Stop() {
+	select {
+	case <-b.stopped:
+		return
+	default:
+	}
+	close(b.stop)
+	<-b.stopped
+}
+
+// draw redraws the bars in place. Finished bars are drawn one last time at
+// the top, where they scroll out of the redrawn area, and then forgotten.
+func (b *progressBoard) draw() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	width, err := termutil.TerminalWidth()
+	if err != nil {
+		width = 100
+	}
+
+	var out strings.Builder
+	if b.lastLines > 0 {
+		fmt.Fprintf(&out, "\033[%dA", b.lastLines)
+	}
+
+	var finished, active []*pb.ProgressBar
+	for _, bar := range b.bars {
+		if bar.IsFinished() {
+			finished = append(finished, bar)
+		} else {
+			active = append(active, bar)
+		}
+	}
+	for _, bar := range append(finished, active...) {
+		bar.SetWidth(width)
+		line := bar.String()
+		if pad := width - pb.CellCount(line); pad > 0 {
+			line += strings.Repeat(" ", pad)
+		}
+		fmt.Fprintf(&out, "\r%s\n", line)
+	}
+
+	fmt.Fprint(b.out, out.String())
+	b.bars = active
+	b.lastLines = len(active)
+}
@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Manifest records what is needed to resume an interrupted download.
+// It is stored as JSON next to the destination file. Segmented downloads
+// also record their byte ranges and how far each of them got.
+type Manifest struct {
+	URL          string     `json:"url"`
+	Size         int64      `json:"size"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"last_modified,omitempty"`
+	Segments     []*Segment `json:"segments,omitempty"`
+	// Unvalidated is set when an existing file was adopted as the partial
+	// file, see ResolveConflict. It is resumed without If-Range.
+	Unvalidated bool `json:"unvalidated,omitempty"`
+}
+
+// PartialPath returns the path a download is written to until it completes.
+func PartialPath(destPath string) string {
+	return destPath + ".part"
+}
+
+// ManifestPath returns the path of the resume manifest for destPath.
+func ManifestPath(destPath string) string {
+	return destPath + ".godl"
+}
+
+// NewManifest builds a manifest from the response of the first attempt.
+func NewManifest(url string, resp *http.Response) *Manifest {
+	return &Manifest{
+		URL:          url,
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// LoadManifest reads a manifest previously written by Save.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("corrupt manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to path, replacing it atomically so that a crash
+// never leaves a truncated manifest behind.
+func (m *Manifest) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Validator returns the value to send in an If-Range header, or an empty
+// string when the first attempt gave us nothing to validate against.
+// If-Range only accepts strong ETags, so a weak one falls back to
+// Last-Modified.
+func (m *Manifest) Validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// Matches reports whether resp describes the same remote file the manifest
+// was written for. A manifest without validators never matches, since there
+// is no way to tell whether the file changed.
+func (m *Manifest) Matches(url string, resp *http.Response) bool {
+	if m.URL != url || m.Size != resp.ContentLength || m.Validator() == "" {
+		return false
+	}
+	return m.ETag == resp.Header.Get("ETag") && m.LastModified == resp.Header.Get("Last-Modified")
+}
+
+// MatchesSize reports whether resp describes a file of the size the manifest
+// was written for, at the same URL, when neither of them carries a
+// validator. That is all there is to check with servers sending neither
+// ETag nor Last-Modified, and a changed file of the same size goes
+// unnoticed.
+func (m *Manifest) MatchesSize(url string, resp *http.Response) bool {
+	return m.URL == url && m.Size == resp.ContentLength && m.ETag == "" && m.LastModified == "" &&
+		resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == ""
+}
+
+// SameVersion reports whether resp carries the validators the manifest was
+// written with. Validators missing on either side are not compared.
+func (m *Manifest) SameVersion(resp *http.Response) bool {
+	if etag := resp.Header.Get("ETag"); m.ETag != "" && etag != "" && etag != m.ETag {
+		return false
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	return m.LastModified == "" || lastModified == "" || lastModified == m.LastModified
+}
+
+// Completed returns the number of bytes written across all segments.
+func (m *Manifest) Completed() int64 {
+	var total int64
+	for _, segment := range m.Segments {
+		total += segment.Completed()
+	}
+	return total
+}
+
+// parseContentRange extracts the first byte position and the complete length
+// from a Content-Range header such as "bytes 100-199/200" or "bytes */200".
+// An unknown length ("*") is reported as -1.
+func parseContentRange(value string) (start int64, total int64, err error) {
+	var rangePart, totalPart string
+	if _, err = fmt.Sscanf(strings.Replace(value, "/", " ", 1), "bytes %s %s", &rangePart, &totalPart); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+
+	total = -1
+	if totalPart != "*" {
+		if _, err = fmt.Sscanf(totalPart, "%d", &total); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+		}
+	}
+
+	start = -1
+	if rangePart != "*" {
+		if _, err = fmt.Sscanf(rangePart, "%d-", &start); err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+		}
+	}
+	return start, total, nil
+}
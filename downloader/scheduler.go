@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+// maxChunkSize is the largest range a fresh segmented download hands to a
+// connection at once. Larger files are split into more segments than there
+// are connections, which are then worked through in order.
+var maxChunkSize int64 = 64 << 20
+
+// minStealSize is the smallest range an idle connection takes over from a
+// busy one.
+var minStealSize int64 = 1 << 20
+
+// minStealTime is how long the busy connection must still be expected to
+// need for its range before another connection takes over half of it.
+var minStealTime = 2 * time.Second
+
+// minSegmentSize is the least each connection of a segmented download should
+// have to fetch for the extra connection to be worth opening.
+var minSegmentSize int64 = 4 << 20
+
+// connectionCount returns how many connections, up to max, a file of size
+// bytes is worth downloading over.
+func connectionCount(size int64, max int) int {
+	count := size / minSegmentSize
+	if count > int64(max) {
+		count = int64(max)
+	}
+	if count < 1 {
+		count = 1
+	}
+	return int(count)
+}
+
+// chunkCount returns how many segments a fresh download of size bytes over
+// the given number of connections is split into.
+func chunkCount(size int64, connections int) int {
+	count := connections
+	if chunks := (size + maxChunkSize - 1) / maxChunkSize; chunks > int64(count) {
+		count = int(chunks)
+	}
+	return count
+}
+
+// segmentScheduler hands the segments of a manifest to connections. Once
+// every segment has been started, an idle connection takes over the second
+// half of the range that is expected to finish last, judging by the
+// throughput of the connection working on it, so that a single slow
+// connection does not hold up the whole download.
+type segmentScheduler struct {
+	mu        sync.Mutex
+	manifest  *Manifest
+	pending   []*Segment
+	running   map[*Segment]*segmentRun
+	nextIndex int
+}
+
+// segmentRun tracks the throughput of a running segment.
+type segmentRun struct {
+	started time.Time
+	from    int64
+}
+
+// newSegmentScheduler schedules every segment of manifest, in order.
+func newSegmentScheduler(manifest *Manifest) *segmentScheduler {
+	s := &segmentScheduler{
+		manifest: manifest,
+		pending:  append([]*Segment(nil), manifest.Segments...),
+		running:  map[*Segment]*segmentRun{},
+	}
+	for _, segment := range manifest.Segments {
+		if segment.Index >= s.nextIndex {
+			s.nextIndex = segment.Index + 1
+		}
+	}
+	return s
+}
+
+// next returns the segment a connection should work on next, or nil once
+// there is nothing left worth taking on.
+func (s *segmentScheduler) next() *Segment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) > 0 {
+		segment := s.pending[0]
+		s.pending = s.pending[1:]
+		s.start(segment)
+		return segment
+	}
+
+	victim := s.slowest()
+	if victim == nil {
+		return nil
+	}
+	tail := victim.Split(s.nextIndex, minStealSize)
+	if tail == nil {
+		return nil
+	}
+	s.nextIndex++
+	s.manifest.Segments = append(s.manifest.Segments, tail)
+	s.start(tail)
+	return tail
+}
+
+// hasWork reports whether a connection asking for a segment now would get
+// one.
+func (s *segmentScheduler) hasWork() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) > 0 || s.slowest() != nil
+}
+
+// start records segment as running. The caller holds s.mu.
+func (s *segmentScheduler) start(segment *Segment) {
+	s.running[segment] = &segmentRun{started: time.Now(), from: segment.Completed()}
+}
+
+// slowest returns the running segment expected to finish last, or nil if
+// every running segment will be done within minStealTime. The caller holds
+// s.mu.
+func (s *segmentScheduler) slowest() *Segment {
+	var victim *Segment
+	var victimLeft time.Duration
+	for segment, run := range s.running {
+		left := segment.unfetched()
+		if left < 2*minStealSize {
+			continue
+		}
+
+		// A connection that has not delivered anything yet may be stuck
+		var eta time.Duration = 1<<63 - 1
+		elapsed := time.Since(run.started)
+		if done := segment.Completed() - run.from; done > 0 {
+			eta = time.Duration(float64(left) / float64(done) * float64(elapsed))
+		} else if elapsed < minStealTime {
+			continue
+		}
+		if eta >= minStealTime && (victim == nil || eta > victimLeft) {
+			victim, victimLeft = segment, eta
+		}
+	}
+	return victim
+}
+
+// finish records that a connection stopped working on segment.
+func (s *segmentScheduler) finish(segment *Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, segment)
+}
+
+// save writes the manifest to path.
+func (s *segmentScheduler) save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.manifest.Save(path)
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkCount(t *testing.T) {
+	defer func(size int64) { maxChunkSize = size }(maxChunkSize)
+	maxChunkSize = 100
+
+	assert.Equal(t, 4, chunkCount(50, 4))
+	assert.Equal(t, 4, chunkCount(400, 4))
+	assert.Equal(t, 5, chunkCount(401, 4))
+}
+
+func TestConnectionCount(t *testing.T) {
+	defer func(size int64) { minSegmentSize = size }(minSegmentSize)
+	minSegmentSize = 100
+
+	assert.Equal(t, 1, connectionCount(50, 8))
+	assert.Equal(t, 3, connectionCount(350, 8))
+	assert.Equal(t, 8, connectionCount(5000, 8))
+}
+
+func TestSegment_Split(t *testing.T) {
+	segment := &Segment{Index: 0, Start: 100, End: 199}
+	segment.Add(20)
+
+	tail := segment.Split(1, 10)
+	assert.Equal(t, int64(160), tail.Start)
+	assert.Equal(t, int64(199), tail.End)
+	assert.Equal(t, int64(159), segment.End)
+
+	// Bytes being read are not handed over
+	reader := newSegmentReader(segment, strings.NewReader(strings.Repeat("x", 100)))
+	io.CopyN(io.Discard, reader, 30)
+	tail = segment.Split(2, 10)
+	assert.Nil(t, tail, "only 10 bytes are left unread")
+
+	tail = segment.Split(2, 5)
+	assert.Equal(t, int64(155), tail.Start)
+	assert.Equal(t, int64(154), segment.End)
+
+	// The reader stops at the new end
+	rest, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Len(t, rest, 5)
+}
+
+func TestSegmentedDownload_StealsFromSlowConnection(t *testing.T) {
+	defer func(size int64, wait time.Duration, read int) {
+		minStealSize, minStealTime, segmentReadSize = size, wait, read
+	}(minStealSize, minStealTime, segmentReadSize)
+	minStealSize, minStealTime, segmentReadSize = 100, 10*time.Millisecond, 100
+
+	content := bytes.Repeat([]byte("0123456789"), 2000)
+	var mu sync.Mutex
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		rangeHeader := r.Header.Get("Range")
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, rangeHeader)
+			mu.Unlock()
+		}
+		if !strings.HasPrefix(rangeHeader, "bytes=0-") {
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		// The first range trickles in slowly
+		var start, end int
+		fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", "bytes "+rangeHeader[len("bytes="):]+"/"+strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		for offset := start; offset <= end; offset += 200 {
+			stop := offset + 200
+			if stop > end+1 {
+				stop = end + 1
+			}
+			if _, err := w.Write(content[offset:stop]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	downloader := &SegmentedDownloader{Client: &clients.RealHttpClient{}, SegmentManager: &PreallocatedSegmentManager{}}
+	started := time.Now()
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.NoError(t, err)
+
+	got, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, got)
+	assert.Greater(t, len(ranges), 2, "idle connection should take over part of the slow range")
+	// Trickling the whole first half would take a second
+	assert.Less(t, time.Since(started), 900*time.Millisecond)
+}
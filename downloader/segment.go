@@ -0,0 +1,269 @@
+package downloader
+
+import (
+	"GoDownload/clients"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Segment is a contiguous byte range of a segmented download together with
+// how many of its bytes have been written so far. It is safe for concurrent
+// use, so the manifest can be flushed while the segment is downloading. End
+// moves down when another connection takes over the tail of the segment,
+// see Split; it may only be read directly while no download is running.
+type Segment struct {
+	Index int
+	Start int64
+	End   int64
+
+	mu        sync.Mutex
+	completed int64
+	// claimed counts the bytes read off the network by the running
+	// attempt, some of which may not be written yet.
+	claimed    int64
+	onProgress func(n int64)
+	// remote is the manifest of the download, whose validators every
+	// request for the segment is made and checked against.
+	remote *Manifest
+}
+
+// segmentState is the on-disk representation of a Segment.
+type segmentState struct {
+	Index     int   `json:"index"`
+	Start     int64 `json:"start"`
+	End       int64 `json:"end"`
+	Completed int64 `json:"completed"`
+}
+
+// newSegments splits fileSize bytes into count equal ranges, the last one
+// absorbing the remainder.
+func newSegments(fileSize int64, count int) []*Segment {
+	if int64(count) > fileSize {
+		count = int(fileSize)
+	}
+	if count < 1 {
+		count = 1
+	}
+	segmentSize := fileSize / int64(count)
+
+	segments := make([]*Segment, count)
+	for i := 0; i < count; i++ {
+		start := int64(i) * segmentSize
+		end := start + segmentSize - 1
+		if i == count-1 {
+			end = fileSize - 1
+		}
+		segments[i] = &Segment{Index: i, Start: start, End: end}
+	}
+	return segments
+}
+
+// segmentPath returns the file a FileSegmentManager writes segment index to.
+func segmentPath(destPath string, index int) string {
+	return fmt.Sprintf("%s.part%d", destPath, index)
+}
+
+// Length returns the number of bytes in the segment.
+func (s *Segment) Length() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.End - s.Start + 1
+}
+
+// Completed returns the number of bytes written so far.
+func (s *Segment) Completed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed
+}
+
+// Done reports whether every byte of the segment has been written.
+func (s *Segment) Done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed >= s.End-s.Start+1
+}
+
+// Remaining returns the byte range that still has to be fetched.
+func (s *Segment) Remaining() (start, end int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Start + s.completed, s.End
+}
+
+// Split hands the second half of what the segment still has to fetch to a
+// new segment with the given index, provided both halves are at least
+// minSize bytes. Bytes already on their way are never handed over.
+func (s *Segment) Split(index int, minSize int64) *Segment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursor := s.Start + s.completed
+	if s.Start+s.claimed > cursor {
+		cursor = s.Start + s.claimed
+	}
+	left := s.End - cursor + 1
+	if left < 2*minSize {
+		return nil
+	}
+	tail := &Segment{Index: index, Start: cursor + left/2, End: s.End, onProgress: s.onProgress, remote: s.remote}
+	s.End = tail.Start - 1
+	return tail
+}
+
+// unfetched returns the number of bytes nobody has read yet.
+func (s *Segment) unfetched() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.End - s.Start + 1 - s.claimed
+}
+
+// Add records n more bytes as written.
+func (s *Segment) Add(n int64) {
+	s.mu.Lock()
+	s.completed += n
+	onProgress := s.onProgress
+	s.mu.Unlock()
+
+	if onProgress != nil {
+		onProgress(n)
+	}
+}
+
+// SetCompleted overrides the number of bytes written, e.g. when the data on
+// disk turns out to be shorter than the manifest claims.
+func (s *Segment) SetCompleted(n int64) {
+	s.mu.Lock()
+	delta := n - s.completed
+	s.completed = n
+	onProgress := s.onProgress
+	s.mu.Unlock()
+
+	if onProgress != nil && delta != 0 {
+		onProgress(delta)
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s *Segment) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	state := segmentState{
+		Index:     s.Index,
+		Start:     s.Start,
+		End:       s.End,
+		Completed: s.completed,
+	}
+	s.mu.Unlock()
+	return json.Marshal(state)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Segment) UnmarshalJSON(data []byte) error {
+	var state segmentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.Index, s.Start, s.End = state.Index, state.Start, state.End
+	s.mu.Lock()
+	s.completed = state.Completed
+	s.mu.Unlock()
+	return nil
+}
+
+// ErrRemoteChanged is returned when the remote file is no longer the one
+// the bytes fetched so far by a segmented download came from.
+var ErrRemoteChanged = errors.New("remote file changed")
+
+// newRangeRequest builds the request for the bytes start to end of segment.
+// It carries an If-Range header with the validator of the segment's
+// manifest, so that a changed file is sent whole rather than spliced into
+// the old one.
+func newRangeRequest(url string, segment *Segment, start, end int64) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if segment.remote != nil {
+		if validator := segment.remote.Validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	return req, nil
+}
+
+// checkRangeResponse makes sure resp holds part of the file the segment's
+// manifest describes, returning ErrRemoteChanged when the server answered
+// the If-Range with the whole file or sent the range of another version.
+func checkRangeResponse(req *http.Request, resp *http.Response, segment *Segment) error {
+	if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+		return ErrRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected partial content status but got %w", clients.NewStatusError(resp))
+	}
+	if segment.remote != nil && !segment.remote.SameVersion(resp) {
+		return ErrRemoteChanged
+	}
+	return nil
+}
+
+// segmentWriter counts the bytes written through it towards a segment.
+type segmentWriter struct {
+	segment *Segment
+}
+
+func (w segmentWriter) Write(p []byte) (int, error) {
+	w.segment.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// segmentReadSize caps how much a segment reads at a time. Bytes being read
+// cannot be handed to another connection, see Split.
+var segmentReadSize = 32 << 10
+
+// segmentReader reads the body of a range request up to the current end of
+// a segment, so a download stops where the segment was split.
+type segmentReader struct {
+	segment *Segment
+	r       io.Reader
+}
+
+// newSegmentReader starts an attempt at segment, reading from r.
+func newSegmentReader(segment *Segment, r io.Reader) *segmentReader {
+	segment.mu.Lock()
+	segment.claimed = segment.completed
+	segment.mu.Unlock()
+	return &segmentReader{segment: segment, r: r}
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	// Claim the bytes before reading them so Split leaves them alone
+	s := r.segment
+	s.mu.Lock()
+	room := s.End - s.Start + 1 - s.claimed
+	if room < 0 {
+		room = 0
+	}
+	if room > int64(segmentReadSize) {
+		room = int64(segmentReadSize)
+	}
+	if int64(len(p)) > room {
+		p = p[:room]
+	}
+	s.claimed += int64(len(p))
+	s.mu.Unlock()
+	if len(p) == 0 {
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p)
+	s.mu.Lock()
+	s.claimed -= int64(len(p) - n)
+	s.mu.Unlock()
+	return n, err
+}
@@ -1,26 +1,67 @@
 package downloader
 
 import (
+	"GoDownload/checksum"
 	"GoDownload/clients"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/cheggaaa/pb/v3"
+	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// ErrInterrupted is returned when a download is stopped by a signal.
+var ErrInterrupted = errors.New("download interrupted")
+
+// manifestFlushInterval is how often segmented downloads persist progress.
+const manifestFlushInterval = time.Second
+
 type SegmentedDownloader struct {
 	Client         clients.HttpClient
 	SegmentManager SegmentManager
+	// Retry decides whether a failed segment is tried again on its own,
+	// continuing from the bytes it already wrote. Nil disables retries.
+	Retry *clients.RetryPolicy
+	// Quarantine is where files failing checksum verification are moved.
+	// They are deleted when it is empty.
+	Quarantine string
+	// Middleware is applied, in order, to every request made through
+	// Client.
+	Middleware []clients.Middleware
+
+	// budget, if set, is where connections beyond the first are taken
+	// from. They are taken while there is work left and given back when
+	// the budget shrinks.
+	budget *connBudget
+	// hosts, if set, tells whether the host has room for another
+	// connection. Connections are only taken from the budget when it has,
+	// rather than held while waiting for the host, so that downloads from
+	// other hosts can use them.
+	hosts HostWaiter
+	// restarted is set once the download started over because the remote
+	// file changed, so that it does not do so again.
+	restarted bool
 }
 
 type SegmentManager interface {
-	DownloadSegment(ctx context.Context, client clients.HttpClient, url string, start, end int64, destPath string) error
-	MergeSegments(destPath string, segmentCount int) error
+	DownloadSegment(ctx context.Context, client clients.HttpClient, url string, segment *Segment, destPath string) error
+	MergeSegments(destPath string, segments []*Segment) error
+}
+
+// HashingMerger is implemented by segment managers that can copy the merged
+// file to a writer while merging, so it can be hashed without being read
+// again.
+type HashingMerger interface {
+	MergeSegmentsTo(destPath string, segments []*Segment, w io.Writer) error
 }
 
 type SegmentManagerFactory interface {
@@ -41,14 +82,42 @@ func NewSegmentedDownloader(client clients.HttpClient, factory SegmentManagerFac
 	}
 }
 
+// FileSegmentManager writes each segment into its own .partN file and
+// concatenates them once all segments are complete.
 type FileSegmentManager struct{}
 
-func (m *FileSegmentManager) DownloadSegment(ctx context.Context, client clients.HttpClient, url string, start, end int64, destPath string) error {
-	req, err := http.NewRequest("GET", url, nil)
+// DownloadSegment fetches the bytes of segment that are not on disk yet and
+// appends them to the segment's part file.
+func (m *FileSegmentManager) DownloadSegment(ctx context.Context, client clients.HttpClient, url string, segment *Segment, destPath string) error {
+	partFile, err := os.OpenFile(segmentPath(destPath, segment.Index), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+
+	// Only trust as many bytes as both the manifest and the disk agree on
+	info, err := partFile.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < segment.Completed() {
+		segment.SetCompleted(info.Size())
+	}
+	if err := partFile.Truncate(segment.Completed()); err != nil {
+		return err
+	}
+	if _, err := partFile.Seek(segment.Completed(), io.SeekStart); err != nil {
+		return err
+	}
+	if segment.Done() {
+		return nil
+	}
+
+	start, end := segment.Remaining()
+	req, err := newRangeRequest(url, segment, start, end)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
 	resp, err := client.Do(ctx, req)
 	if err != nil {
 		return err
@@ -56,120 +125,374 @@ func (m *FileSegmentManager) DownloadSegment(ctx context.Context, client clients
 	defer resp.Body.Close()
 
 	// Check if the request was successful
-	if resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("expected partial content status but got %s", resp.Status)
-	}
-
-	// Create a segment file
-	partFile, err := os.Create(fmt.Sprintf("%s.part%d", destPath, start))
-	if err != nil {
+	if err := checkRangeResponse(req, resp, segment); err != nil {
 		return err
 	}
-	defer partFile.Close()
 
-	// Write data directly to the segment file
-	_, err = io.Copy(partFile, resp.Body)
-	if err != nil {
+	// Write data directly to the segment file, counting it towards the
+	// segment, up to wherever the segment ends by now
+	if _, err := io.Copy(io.MultiWriter(partFile, segmentWriter{segment}), newSegmentReader(segment, resp.Body)); err != nil {
 		return err
 	}
+	if !segment.Done() {
+		return io.ErrUnexpectedEOF
+	}
 
 	return nil
 }
 
-func (m *FileSegmentManager) MergeSegments(destPath string, segmentCount int) error {
+// MergeSegments concatenates the part files of segments into destPath in
+// byte order and removes them.
+func (m *FileSegmentManager) MergeSegments(destPath string, segments []*Segment) error {
+	return m.MergeSegmentsTo(destPath, segments, io.Discard)
+}
+
+// MergeSegmentsTo merges like MergeSegments, also writing the merged data
+// to w.
+func (m *FileSegmentManager) MergeSegmentsTo(destPath string, segments []*Segment, w io.Writer) error {
+	ordered := make([]*Segment, len(segments))
+	copy(ordered, segments)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Start < ordered[j].Start })
+
 	mergedFile, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
 	defer mergedFile.Close()
 
-	for i := 0; i < segmentCount; i++ {
-		segmentPath := fmt.Sprintf("%s.part%d", destPath, i)
-		segmentFile, err := os.Open(segmentPath)
+	for _, segment := range ordered {
+		segmentFile, err := os.Open(segmentPath(destPath, segment.Index))
 		if err != nil {
 			return err
 		}
 
-		_, err = io.Copy(mergedFile, segmentFile)
+		_, err = io.Copy(io.MultiWriter(mergedFile, w), segmentFile)
 		segmentFile.Close()
 		if err != nil {
 			return err
 		}
+	}
 
-		// Remove the segment file after merging
-		os.Remove(segmentPath)
+	// Remove the segment files only once the merged file is complete
+	for _, segment := range ordered {
+		os.Remove(segmentPath(destPath, segment.Index))
 	}
 
 	return nil
 }
 
+// DownloadFileInSegments downloads url into destPath over the given number
+// of parallel connections, each working through byte ranges of the file and
+// taking over part of the slowest range once it runs out of work. Progress
+// is recorded in a manifest next to destPath, so a failed or interrupted
+// download picks up where it left off when run again. Should the remote file
+// change in the meantime, the download starts over once.
 func (d *SegmentedDownloader) DownloadFileInSegments(url string, destPath string, segments int) error {
+	ctx, stop := interruptible()
+	defer stop()
+	return d.withMiddleware().downloadFileInSegments(ctx, url, destPath, segments, nil, nil)
+}
+
+// withMiddleware returns a copy of d whose Client applies d.Middleware.
+func (d *SegmentedDownloader) withMiddleware() *SegmentedDownloader {
+	run := *d
+	run.Client = clients.Use(d.Client, d.Middleware...)
+	run.Middleware = nil
+	return &run
+}
+
+// interruptible returns a context that is cancelled on ctrl+c, so segments
+// stop and the manifest is flushed before exiting.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signalCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signalCh)
+		cancel()
+	}
+}
+
+// downloadFileInSegments downloads like DownloadFileInSegments until ctx is
+// cancelled and, if sum is set, verifies the merged file against it.
+// Progress goes to bar, or to a bar of its own if bar is nil.
+func (d *SegmentedDownloader) downloadFileInSegments(ctx context.Context, url string, destPath string, segments int, sum *checksum.Checksum, bar *pb.ProgressBar) error {
 	// Get the file size
-	resp, err := d.Client.Head(url)
+	resp, err := headWithRetry(ctx, d.Retry, d.Client, url)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	// Only the headers are needed; an open body would keep holding the
+	// connection a host limiter counts against the host
+	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("failed to get file info: %s", resp.Status)
 	}
+	if resp.ContentLength < 0 {
+		return fmt.Errorf("server did not report the size of %s", url)
+	}
 
 	fileSize := resp.ContentLength
-	segmentSize := fileSize / int64(segments)
-
-	// Create a progress bar
-	bar := pb.Start64(fileSize)
-	defer bar.Finish()
+	manifestPath := ManifestPath(destPath)
+	manifest := d.loadManifest(ctx, manifestPath, url, resp, segments)
+	if preallocator, ok := d.SegmentManager.(Preallocator); ok {
+		kept, err := preallocator.Preallocate(destPath, fileSize)
+		if err != nil {
+			return err
+		}
+		if !kept {
+			for _, segment := range manifest.Segments {
+				segment.SetCompleted(0)
+			}
+		}
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		return err
+	}
 
-	// Create a context for graceful exit
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Start at what earlier runs already fetched
+	if bar == nil {
+		bar = pb.Start64(fileSize)
+		defer bar.Finish()
+	}
+	bar.SetCurrent(manifest.Completed())
+	for _, segment := range manifest.Segments {
+		segment.onProgress = func(n int64) { bar.Add64(n) }
+		segment.remote = manifest
+	}
 
-	// Handle ctrl+c gracefully
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	// Flush the manifest periodically so a crash loses at most a few seconds
+	scheduler := newSegmentScheduler(manifest)
+	flushDone := make(chan struct{})
+	flushStopped := make(chan struct{})
 	go func() {
-		<-signalCh
-		cancel()
+		defer close(flushStopped)
+		ticker := time.NewTicker(manifestFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				scheduler.save(manifestPath)
+			case <-flushDone:
+				return
+			}
+		}
 	}()
 
+	// Once the remote file turns out to have changed, the other segments
+	// stop too
+	segmentCtx, stopSegments := context.WithCancel(ctx)
+	defer stopSegments()
 	var wg sync.WaitGroup
-	successCh := make(chan bool, segments)
-
-	for i := 0; i < segments; i++ {
+	var failed, changed atomic.Bool
+	var workers atomic.Int32
+
+	// Every segment goes to the manager, which knows whether its data is
+	// actually on disk and only requests what is missing. Each connection
+	// keeps taking segments until there are none left worth taking, or,
+	// for connections taken from the budget, until the budget shrinks or
+	// the host has no room for them.
+	//
+	// A host limiter only counts a connection once its request got through
+	// and holds the others back, so with one, a connection is only taken
+	// from the budget once the previous one got through and the host still
+	// has room. Otherwise it would hold a connection of the budget that
+	// downloads from other hosts could use while waiting for the host.
+	var work func(pooled bool)
+	var growing atomic.Bool
+	hostFull := func() bool {
+		return d.hosts != nil && !d.hosts.HostReady(url)
+	}
+	canGrow := func() bool {
+		return d.budget != nil && int(workers.Load()) < segments && scheduler.hasWork() && !hostFull()
+	}
+	grow := func() {
+		if d.hosts == nil {
+			for canGrow() && d.budget.tryAcquire(1) == 1 {
+				work(true)
+			}
+			return
+		}
+		if canGrow() && growing.CompareAndSwap(false, true) {
+			if d.budget.tryAcquire(1) == 1 {
+				work(true)
+			} else {
+				growing.Store(false)
+			}
+		}
+	}
+	work = func(pooled bool) {
 		wg.Add(1)
-		start := int64(i) * segmentSize
-		end := start + segmentSize - 1
-		if i == segments-1 {
-			end = fileSize - 1
+		workers.Add(1)
+		client := d.Client
+		var gotThrough sync.Once
+		if d.hosts != nil {
+			client = &admittedClient{HttpClient: d.Client, admitted: func() {
+				if pooled {
+					gotThrough.Do(func() { growing.Store(false) })
+				}
+				grow()
+			}}
 		}
-
-		go func(start, end int64, ctx context.Context) {
+		go func() {
 			defer wg.Done()
-			nErr := d.SegmentManager.DownloadSegment(ctx, d.Client, url, start, end, destPath)
-			//successCh <- (true)
-			successCh <- (nErr == nil)
-		}(start, end, ctx)
+			defer workers.Add(-1)
+			if pooled {
+				defer d.budget.release(1)
+				defer gotThrough.Do(func() { growing.Store(false) })
+			}
+			for segment := scheduler.next(); segment != nil && segmentCtx.Err() == nil; segment = scheduler.next() {
+				nErr := d.Retry.Do(segmentCtx, func(int) error {
+					return d.SegmentManager.DownloadSegment(segmentCtx, client, url, segment, destPath)
+				})
+				scheduler.finish(segment)
+				if errors.Is(nErr, ErrRemoteChanged) {
+					changed.Store(true)
+					stopSegments()
+				}
+				if nErr != nil {
+					failed.Store(true)
+				}
+				if pooled && (d.budget.overLimit() || hostFull()) {
+					return
+				}
+				if d.hosts == nil {
+					grow()
+				}
+			}
+		}()
+	}
+
+	if d.budget == nil {
+		for i := 0; i < segments; i++ {
+			work(false)
+		}
+	} else {
+		work(false)
+		if d.hosts == nil {
+			grow()
+		}
 	}
 
 	wg.Wait()
-	close(successCh)
+	close(flushDone)
+	<-flushStopped
+
+	if err := scheduler.save(manifestPath); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w, progress saved to %s", ErrInterrupted, manifestPath)
+	}
 
-	allSuccessful := true
-	for success := range successCh {
-		if !success {
-			allSuccessful = false
-			break
+	// The bytes fetched so far belong to another version of the file
+	if changed.Load() {
+		os.Remove(manifestPath)
+		if d.restarted {
+			return fmt.Errorf("%w during the download of %s", ErrRemoteChanged, url)
 		}
+		restart := *d
+		restart.restarted = true
+		return restart.downloadFileInSegments(ctx, url, destPath, segments, sum, bar)
 	}
 
-	if !allSuccessful {
+	if failed.Load() {
 		return fmt.Errorf("one or more segment downloads failed. Please retry")
 	}
 
-	// Merge the downloaded segments
-	return d.SegmentManager.MergeSegments(destPath, segments)
+	// Merge the downloaded segments, hashing them on the way if the
+	// manager allows it
+	h := newHash(sum)
+	if merger, ok := d.SegmentManager.(HashingMerger); ok && h != nil {
+		err = merger.MergeSegmentsTo(destPath, manifest.Segments, h)
+	} else {
+		err = d.SegmentManager.MergeSegments(destPath, manifest.Segments)
+		if err == nil && h != nil {
+			err = hashPrefix(h, destPath, fileSize)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(manifestPath)
+	if err := verify(sum, h, destPath, destPath, d.Quarantine); err != nil {
+		return err
+	}
+	applyRemoteMetadata(destPath, manifest.ETag, manifest.LastModified)
+	return nil
+}
+
+// DownloadEntryInSegments downloads entry into destPath in segments, sending
+// the entry's headers and falling back to its mirrors in order.
+func (d *SegmentedDownloader) DownloadEntryInSegments(entry clients.DownloadEntry, destPath string, segments int) error {
+	ctx, stop := interruptible()
+	defer stop()
+	return d.withMiddleware().downloadEntryInSegments(ctx, entry, destPath, segments, nil)
+}
+
+// downloadEntryInSegments downloads like DownloadEntryInSegments until ctx
+// is cancelled, reporting progress to bar if it is set.
+func (d *SegmentedDownloader) downloadEntryInSegments(ctx context.Context, entry clients.DownloadEntry, destPath string, segments int, bar *pb.ProgressBar) error {
+	sum, err := entryChecksum(entry)
+	if err != nil {
+		return err
+	}
+
+	withHeaders := *d
+	withHeaders.Client = clients.WithHeader(d.Client, entry.Header)
+
+	for _, url := range entry.URLs() {
+		if err = withHeaders.downloadFileInSegments(ctx, url, destPath, segments, sum, bar); err == nil || errors.Is(err, ErrInterrupted) {
+			return err
+		}
+	}
+	return err
+}
+
+// loadManifest returns the manifest of an earlier attempt at the same remote
+// file, or a fresh one split into segments for the requested number of
+// connections, see chunkCount. Without validators to go by, an earlier
+// attempt is continued if the size is the same, with a warning.
+func (d *SegmentedDownloader) loadManifest(ctx context.Context, manifestPath string, url string, resp *http.Response, segments int) *Manifest {
+	manifest, err := LoadManifest(manifestPath)
+	if err == nil && len(manifest.Segments) > 0 {
+		if manifest.Matches(url, resp) {
+			return manifest
+		}
+		if manifest.MatchesSize(url, resp) {
+			if sugar, ok := ctx.Value("sugar").(*zap.SugaredLogger); ok {
+				sugar.Warnw("Server sends no ETag or Last-Modified, resuming on the size alone", "url", url)
+			}
+			return manifest
+		}
+	}
+
+	manifest = NewManifest(url, resp)
+	manifest.Segments = newSegments(resp.ContentLength, chunkCount(resp.ContentLength, segments))
+	return manifest
+}
+
+// admittedClient calls admitted whenever a request got a response, by which
+// time a host limiter below counts its connection against the host.
+type admittedClient struct {
+	clients.HttpClient
+	admitted func()
+}
+
+func (c *admittedClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := c.HttpClient.Do(ctx, req)
+	if err == nil {
+		c.admitted()
+	}
+	return resp, err
 }
 
 // mergeSegments merges the downloaded segments into a single file.
@@ -1,18 +1,48 @@
 package downloader
 
 import (
+	"GoDownload/checksum"
 	"GoDownload/clients"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
+// segmentMatcher matches a *Segment by its byte range.
+type segmentMatcher struct {
+	start, end int64
+}
+
+func (m segmentMatcher) Matches(x interface{}) bool {
+	segment, ok := x.(*Segment)
+	return ok && segment.Start == m.start && segment.End == m.end
+}
+
+func (m segmentMatcher) String() string {
+	return fmt.Sprintf("is segment %d-%d", m.start, m.end)
+}
+
+func segmentRange(start, end int64) gomock.Matcher {
+	return segmentMatcher{start: start, end: end}
+}
+
 func TestSuccessfulSegmentDownloads(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -22,7 +52,7 @@ func TestSuccessfulSegmentDownloads(t *testing.T) {
 	mockSegmentManager := NewMockSegmentManager(ctrl)
 
 	url := "http://example.com/file.zip"
-	destPath := "/tmp/file.zip"
+	destPath := filepath.Join(t.TempDir(), "file.zip")
 	segments := 3
 
 	// Mock the HEAD request to get file size
@@ -40,11 +70,11 @@ func TestSuccessfulSegmentDownloads(t *testing.T) {
 	for i := 0; i < segments; i++ {
 		start := int64(i * 300)
 		end := int64((i+1)*300 - 1)
-		mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, start, end, gomock.Any()).Return(nil)
+		mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, segmentRange(start, end), gomock.Any()).Return(nil)
 	}
 
 	// Mock the segment merge
-	mockSegmentManager.EXPECT().MergeSegments(destPath, segments).Return(nil)
+	mockSegmentManager.EXPECT().MergeSegments(destPath, gomock.Len(segments)).Return(nil)
 
 	downloader := NewSegmentedDownloader(mockClient, mockFactory, url, destPath)
 	err := downloader.DownloadFileInSegments(url, destPath, segments)
@@ -61,7 +91,7 @@ func TestFailedSegmentDownload(t *testing.T) {
 	mockSegmentManager := NewMockSegmentManager(ctrl)
 
 	url := "http://example.com/file.zip"
-	destPath := "/tmp/file.zip"
+	destPath := filepath.Join(t.TempDir(), "file.zip")
 	segments := 3
 
 	// Mock the HEAD request to get file size
@@ -77,9 +107,9 @@ func TestFailedSegmentDownload(t *testing.T) {
 
 	// Mock the segment downloads
 	// Let's assume the first segment download fails
-	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, int64(0), int64(299), gomock.Any()).Return(fmt.Errorf("failed to download segment"))
-	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, int64(300), int64(599), gomock.Any()).Return(nil)
-	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, int64(600), int64(899), gomock.Any()).Return(nil)
+	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, segmentRange(0, 299), gomock.Any()).Return(fmt.Errorf("failed to download segment"))
+	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, segmentRange(300, 599), gomock.Any()).Return(nil)
+	mockSegmentManager.EXPECT().DownloadSegment(gomock.Any(), mockClient, url, segmentRange(600, 899), gomock.Any()).Return(nil)
 
 	// Since the first segment download fails, we don't expect the other segments to be downloaded or merged
 	// Thus, we don't mock expectations for them
@@ -253,3 +283,309 @@ func TestMergeSegments_ZeroByteSegments(t *testing.T) {
 	}
 	os.Remove(destPath)
 }
+
+// newSegmentServer serves content with Range support and records the Range
+// header of every GET. Requests whose range starts at failStart get a 500.
+func newSegmentServer(t *testing.T, content string, failStart *int64) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	ranges := &[]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			*ranges = append(*ranges, r.Header.Get("Range"))
+			mu.Unlock()
+			if failStart != nil && strings.HasPrefix(r.Header.Get("Range"), fmt.Sprintf("bytes=%d-", *failStart)) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, ranges
+}
+
+func TestSegmentedDownload_ResumesAfterFailedSegment(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	failStart := int64(12)
+	ts, ranges := newSegmentServer(t, content, &failStart)
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.Error(t, err)
+
+	// The manifest survives the failure and records the completed segments
+	manifest, err := LoadManifest(ManifestPath(destPath))
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Segments, 3)
+	assert.Equal(t, int64(12), manifest.Segments[0].Completed())
+	assert.Equal(t, int64(0), manifest.Segments[1].Completed())
+	assert.Equal(t, int64(12), manifest.Segments[2].Completed())
+
+	// A second run only asks for the missing segment
+	failStart = -1
+	*ranges = nil
+	err = downloader.DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes=12-23"}, *ranges)
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+	_, err = os.Stat(ManifestPath(destPath))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(segmentPath(destPath, 1))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSegmentedDownload_ResumesPartialSegment(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts, ranges := newSegmentServer(t, content, nil)
+
+	// Simulate a run that was interrupted half way through the first segment
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), ETag: `"v1"`, Segments: newSegments(int64(len(content)), 2)}
+	manifest.Segments[0].Add(5)
+	manifest.Segments[1].Add(18)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+	ioutil.WriteFile(segmentPath(destPath, 0), []byte(content[:5]), 0644)
+	ioutil.WriteFile(segmentPath(destPath, 1), []byte(content[18:]), 0644)
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes=5-17"}, *ranges)
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+func TestSegmentedDownload_ResumesWithoutValidators(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	var mu sync.Mutex
+	var ranges []string
+	// Neither ETag nor Last-Modified is sent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), Segments: newSegments(int64(len(content)), 2)}
+	manifest.Segments[0].Add(5)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+	ioutil.WriteFile(segmentPath(destPath, 0), []byte(content[:5]), 0644)
+
+	core, logs := observer.New(zap.WarnLevel)
+	logCtx := context.WithValue(context.Background(), "sugar", zap.New(core).Sugar())
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.downloadFileInSegments(logCtx, ts.URL, destPath, 2, nil, nil)
+	assert.NoError(t, err)
+
+	// Only the size could be checked, which is worth a warning
+	assert.ElementsMatch(t, []string{"bytes=5-17", "bytes=18-35"}, ranges)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("resuming on the size alone").Len())
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+func TestSegmentedDownload_DiscardsManifestForChangedFile(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts, ranges := newSegmentServer(t, content, nil)
+
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), ETag: `"v0"`, Segments: newSegments(int64(len(content)), 2)}
+	manifest.Segments[0].Add(5)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+	ioutil.WriteFile(segmentPath(destPath, 0), []byte("stale"), 0644)
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bytes=0-17", "bytes=18-35"}, *ranges)
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+// newChangedServer serves content as version "v2", except that its first
+// HEAD request still reports "v1", as a stale cache would. Unless honorIfRange
+// is set, ranges are served whatever the If-Range header says. The If-Range
+// header of every GET is recorded.
+func newChangedServer(t *testing.T, content string, honorIfRange bool) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	heads := 0
+	ifRanges := &[]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		w.Header().Set("ETag", `"v2"`)
+		if r.Method == http.MethodHead {
+			if heads++; heads == 1 {
+				w.Header().Set("ETag", `"v1"`)
+			}
+		} else {
+			*ifRanges = append(*ifRanges, r.Header.Get("If-Range"))
+			if !honorIfRange {
+				r.Header.Del("If-Range")
+			}
+		}
+		mu.Unlock()
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, ifRanges
+}
+
+func TestSegmentedDownload_RestartsWhenIfRangeFails(t *testing.T) {
+	content := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts, ifRanges := newChangedServer(t, content, true)
+
+	// An earlier run fetched the start of version "v1"
+	manifest := &Manifest{URL: ts.URL, Size: int64(len(content)), ETag: `"v1"`, Segments: newSegments(int64(len(content)), 2)}
+	manifest.Segments[0].Add(5)
+	assert.NoError(t, manifest.Save(ManifestPath(destPath)))
+	ioutil.WriteFile(segmentPath(destPath, 0), []byte("stale"), 0644)
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.NoError(t, err)
+
+	// The server sent the whole of "v2" instead, so the download started over
+	assert.Contains(t, *ifRanges, `"v1"`)
+	assert.Equal(t, []string{`"v2"`, `"v2"`}, (*ifRanges)[len(*ifRanges)-2:])
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+func TestSegmentedDownload_FailsWhenRemoteKeepsChanging(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every response claims another version
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, time.Now().UnixNano()))
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader("0123456789abcdefghijklmnopqrstuvwxyz"))
+	}))
+	defer ts.Close()
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 2)
+	assert.ErrorIs(t, err, ErrRemoteChanged)
+	assert.NoFileExists(t, destPath)
+}
+
+func TestManifest_SaveAndLoadSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.godl")
+	manifest := &Manifest{URL: "http://example.com/file", Size: 10, ETag: `"v1"`, Segments: newSegments(10, 3)}
+	manifest.Segments[1].Add(2)
+	assert.NoError(t, manifest.Save(path))
+
+	loaded, err := LoadManifest(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), loaded.Completed())
+	assert.Equal(t, int64(3), loaded.Segments[1].Start)
+	assert.Equal(t, int64(9), loaded.Segments[2].End)
+}
+
+func TestSegmentedDownload_RetriesFailedSegment(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+
+	var mu sync.Mutex
+	failed := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		mu.Lock()
+		fail := !failed && strings.HasPrefix(r.Header.Get("Range"), "bytes=12-")
+		if fail {
+			failed = true
+		}
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	downloader.Retry = clients.DefaultRetryPolicy()
+	downloader.Retry.InitialBackoff = time.Millisecond
+	err := downloader.DownloadFileInSegments(ts.URL, destPath, 3)
+	assert.NoError(t, err)
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+func TestSegmentedDownload_VerifiesChecksum(t *testing.T) {
+	content := "Hello World"
+	ts, _ := newSegmentServer(t, content, nil)
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: "sha-256=a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e"}
+	err := downloader.DownloadEntryInSegments(entry, destPath, 3)
+	assert.NoError(t, err)
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+}
+
+func TestSegmentedDownload_ChecksumMismatch(t *testing.T) {
+	ts, _ := newSegmentServer(t, "Hello World!", nil)
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+
+	downloader := NewSegmentedDownloader(&clients.RealHttpClient{}, &RealSegmentManagerFactory{}, ts.URL, destPath)
+	downloader.Quarantine = quarantine
+	entry := clients.DownloadEntry{URL: ts.URL, Checksum: "sha-256=a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e"}
+	err := downloader.DownloadEntryInSegments(entry, destPath, 3)
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+
+	_, statErr := os.Stat(destPath)
+	assert.True(t, os.IsNotExist(statErr))
+	quarantined, _ := ioutil.ReadFile(filepath.Join(quarantine, "file.bin"))
+	assert.Equal(t, "Hello World!", string(quarantined))
+}
+
+// rangeProtocol serves a file from memory and records the ranges opened.
+type rangeProtocol struct {
+	content []byte
+	mu      sync.Mutex
+	opened  []string
+}
+
+func (p *rangeProtocol) Stat(ctx context.Context, u *url.URL) (*clients.FileInfo, error) {
+	return &clients.FileInfo{Size: int64(len(p.content)), ETag: `"v1"`, Ranges: true}, nil
+}
+
+func (p *rangeProtocol) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	p.mu.Lock()
+	p.opened = append(p.opened, fmt.Sprintf("%d+%d", offset, length))
+	p.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(p.content[offset : offset+length])), nil
+}
+
+func TestSegmentedDownload_RegisteredProtocol(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	protocol := &rangeProtocol{content: []byte(content)}
+	clients.RegisterProtocol("segmented-test", protocol)
+	client, err := clients.NewClientBuilder().Build()
+	assert.NoError(t, err)
+
+	url := "segmented-test://store/file.bin"
+	destPath := filepath.Join(t.TempDir(), "file.bin")
+	downloader := NewSegmentedDownloader(client, &RealSegmentManagerFactory{}, url, destPath)
+	assert.NoError(t, downloader.DownloadFileInSegments(url, destPath, 3))
+
+	merged, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(merged))
+	assert.ElementsMatch(t, []string{"0+12", "12+12", "24+12"}, protocol.opened)
+}
@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"GoDownload/checksum"
+	"GoDownload/clients"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// entryChecksum returns the expected checksum of entry, or nil if it has none.
+func entryChecksum(entry clients.DownloadEntry) (*checksum.Checksum, error) {
+	if entry.Checksum == "" {
+		return nil, nil
+	}
+	sum, err := checksum.Parse(entry.Checksum)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.URL, err)
+	}
+	return sum, nil
+}
+
+// newHash returns a hash for sum, or nil when there is nothing to verify.
+func newHash(sum *checksum.Checksum) hash.Hash {
+	if sum == nil {
+		return nil
+	}
+	return sum.NewHash()
+}
+
+// hashPrefix feeds the first n bytes of the file at path to h. It is used to
+// catch up with the data an earlier attempt wrote before resuming.
+func hashPrefix(h hash.Hash, path string, n int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	copied, err := io.Copy(h, io.LimitReader(file, n))
+	if err != nil {
+		return err
+	}
+	if copied != n {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// verify checks the digest computed by h against sum. A file that does not
+// match is deleted, or moved into quarantineDir when one is set, so that it
+// is neither mistaken for a good download nor resumed from.
+func verify(sum *checksum.Checksum, h hash.Hash, path string, destPath string, quarantineDir string) error {
+	if sum == nil {
+		return nil
+	}
+	verifyErr := sum.Verify(h)
+	if verifyErr == nil {
+		return nil
+	}
+
+	if quarantineDir == "" {
+		os.Remove(path)
+		return verifyErr
+	}
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		os.Remove(path)
+		return verifyErr
+	}
+	quarantined := filepath.Join(quarantineDir, filepath.Base(destPath))
+	if err := os.Rename(path, quarantined); err != nil {
+		os.Remove(path)
+		return verifyErr
+	}
+	return fmt.Errorf("%w, moved to %s", verifyErr, quarantined)
+}
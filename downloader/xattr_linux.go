@@ -0,0 +1,28 @@
+//go:build linux
+
+package downloader
+
+import "syscall"
+
+// etagAttribute is the extended attribute the ETag of a download is kept in.
+const etagAttribute = "user.godownload.etag"
+
+// storeETag records etag on the file at path, so a later run can tell
+// whether the remote file changed. It fails silently on file systems
+// without extended attributes.
+func storeETag(path string, etag string) {
+	if etag == "" {
+		return
+	}
+	syscall.Setxattr(path, etagAttribute, []byte(etag), 0)
+}
+
+// loadETag returns the ETag stored by storeETag, or "" if there is none.
+func loadETag(path string) string {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, etagAttribute, buf)
+	if err != nil || n > len(buf) {
+		return ""
+	}
+	return string(buf[:n])
+}
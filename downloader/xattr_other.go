@@ -0,0 +1,12 @@
+//go:build !linux
+
+package downloader
+
+// storeETag is a no-op where extended attributes are not supported; files
+// are compared by size and modification time only.
+func storeETag(path string, etag string) {}
+
+// loadETag always returns "" where extended attributes are not supported.
+func loadETag(path string) string {
+	return ""
+}
@@ -0,0 +1,457 @@
+// Package ftp downloads over FTP and FTPS. Importing it registers Client
+// for ftp:// and ftps:// URLs, so they go through the same downloaders as
+// HTTP. Clients needing other settings can override the registration:
+//
+//	client, err := clients.NewClientBuilder().
+//		Protocol(ftp.SecureScheme, &ftp.Client{ExplicitTLS: true}).
+//		Build()
+package ftp
+
+import (
+	"GoDownload/clients"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Scheme is the URL scheme of plain FTP, as in ftp://host/path.
+	Scheme = "ftp"
+	// SecureScheme is the URL scheme of FTP over TLS.
+	SecureScheme = "ftps"
+)
+
+const (
+	defaultPort         = "21"
+	defaultImplicitPort = "990"
+	mdtmFormat          = "20060102150405"
+)
+
+// Client fetches files from FTP servers in passive mode, opening a control
+// connection per request. Paths are relative to the directory the server
+// logs in to, as in RFC 1738; ftp://host/%2Fpub/file is absolute. URLs
+// without user info log in anonymously.
+type Client struct {
+	// TLSConfig is used for ftps:// URLs. The server name defaults to
+	// the host of the URL.
+	TLSConfig *tls.Config
+	// ExplicitTLS makes ftps:// URLs connect in plain text to port 21 and
+	// upgrade with AUTH TLS, instead of speaking TLS from the start on
+	// port 990.
+	ExplicitTLS bool
+	// Timeout bounds connecting and waiting for each reply. Zero means
+	// no limit beyond the context of the request.
+	Timeout time.Duration
+}
+
+var _ clients.Protocol = (*Client)(nil)
+
+func init() {
+	clients.RegisterProtocol(Scheme, &Client{})
+	clients.RegisterProtocol(SecureScheme, &Client{})
+}
+
+// Stat logs in and asks for the size (SIZE) and modification time (MDTM)
+// of the file.
+func (c *Client) Stat(ctx context.Context, u *url.URL) (*clients.FileInfo, error) {
+	conn, err := c.connect(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	name := filePath(u)
+	info := &clients.FileInfo{Size: -1, Ranges: conn.rest}
+	reply, err := conn.cmd(2, "SIZE %s", name)
+	switch {
+	case notImplemented(err):
+		// The size stays unknown
+	case err != nil:
+		return nil, pathError("size", u, err)
+	default:
+		if info.Size, err = strconv.ParseInt(strings.TrimSpace(reply), 10, 64); err != nil {
+			return nil, fmt.Errorf("ftp: invalid SIZE reply %q", reply)
+		}
+	}
+	// Not every server knows MDTM; resuming then works without If-Range
+	if reply, err := conn.cmd(2, "MDTM %s", name); err == nil {
+		info.ModTime, _ = parseModTime(reply)
+	}
+	conn.quit()
+	return info, nil
+}
+
+// Open retrieves the file with RETR, starting at offset with REST.
+func (c *Client) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	conn, err := c.connect(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	data, err := conn.passive(ctx)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := conn.cmd(3, "REST %d", offset); err != nil {
+			data.Close()
+			conn.close()
+			return nil, fmt.Errorf("ftp: server cannot resume: %w", err)
+		}
+	}
+	if _, err := conn.cmd(1, "RETR %s", filePath(u)); err != nil {
+		data.Close()
+		conn.close()
+		return nil, pathError("retr", u, err)
+	}
+	return &transfer{conn: conn, data: data, remaining: length}, nil
+}
+
+// connect dials the server of u, logs in and switches to binary mode.
+func (c *Client) connect(ctx context.Context, u *url.URL) (*controlConn, error) {
+	secure := strings.EqualFold(u.Scheme, SecureScheme)
+	implicit := secure && !c.ExplicitTLS
+	port := defaultPort
+	if implicit {
+		port = defaultImplicitPort
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	raw, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn := &controlConn{raw: raw, timeout: c.Timeout}
+	// Cancelling the request drops the connection, failing whatever is
+	// waiting on it
+	conn.stop = context.AfterFunc(ctx, func() { conn.raw.Close() })
+
+	var tlsConfig *tls.Config
+	if secure {
+		tlsConfig = c.tlsConfig(u)
+		conn.tls = tlsConfig
+	}
+	if implicit {
+		conn.raw = tls.Client(raw, tlsConfig)
+	}
+	conn.text = textproto.NewConn(conn.raw)
+
+	if err := conn.login(u); err != nil {
+		conn.close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) tlsConfig(u *url.URL) *tls.Config {
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	// Many servers insist that data connections resume the TLS session
+	// of the control connection
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+	return config
+}
+
+// controlConn is a logged in control connection.
+type controlConn struct {
+	raw     net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+	stop    func() bool
+	// tls is set when data connections are to be protected.
+	tls *tls.Config
+	// rest tells whether the server is expected to support REST.
+	rest bool
+}
+
+// login reads the greeting, upgrades to TLS if needed and logs in.
+func (c *controlConn) login(u *url.URL) error {
+	if _, err := c.read(2); err != nil {
+		return err
+	}
+	if c.tls != nil {
+		if _, implicit := c.raw.(*tls.Conn); !implicit {
+			if _, err := c.cmd(2, "AUTH TLS"); err != nil {
+				return fmt.Errorf("ftp: server refused TLS: %w", err)
+			}
+			c.raw = tls.Client(c.raw, c.tls)
+			c.text = textproto.NewConn(c.raw)
+		}
+		if _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(2, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	code, _, err := c.send("USER %s", user)
+	if err == nil && code == 331 {
+		code, _, err = c.send("PASS %s", password)
+	}
+	if err != nil {
+		return err
+	}
+	if code/100 != 2 {
+		return fmt.Errorf("ftp: login as %s failed: %w", user, fs.ErrPermission)
+	}
+
+	if _, err := c.cmd(2, "TYPE I"); err != nil {
+		return err
+	}
+	// Servers that list their features but not REST cannot resume
+	c.rest = true
+	if features, err := c.cmd(2, "FEAT"); err == nil {
+		c.rest = strings.Contains(strings.ToUpper(features), "REST STREAM")
+	}
+	return nil
+}
+
+// send sends a command and returns the reply, whatever its code.
+func (c *controlConn) send(format string, args ...any) (int, string, error) {
+	c.deadline()
+	if err := c.printLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	code, message, err := c.text.ReadResponse(0)
+	return code, message, err
+}
+
+// printLine sends a command line, refusing arguments that would end it
+// early and start another command.
+func (c *controlConn) printLine(format string, args ...any) error {
+	line := fmt.Sprintf(format, args...)
+	if strings.ContainsAny(line, "\r\n\x00") {
+		return fmt.Errorf("ftp: command contains a line break or NUL")
+	}
+	return c.text.PrintfLine("%s", line)
+}
+
+// cmd sends a command and expects a reply starting with the digit class.
+func (c *controlConn) cmd(class int, format string, args ...any) (string, error) {
+	c.deadline()
+	if err := c.printLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.read(class)
+}
+
+func (c *controlConn) read(class int) (string, error) {
+	c.deadline()
+	_, message, err := c.text.ReadResponse(class)
+	return message, err
+}
+
+func (c *controlConn) deadline() {
+	if c.timeout > 0 {
+		c.raw.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// passive opens a data connection with EPSV, or PASV for servers without
+// it. The address the server reports is ignored in favour of the one the
+// control connection goes to, which also works behind NAT.
+func (c *controlConn) passive(ctx context.Context) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(c.raw.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	port, err := c.epsv()
+	if err != nil {
+		if port, err = c.pasv(); err != nil {
+			return nil, err
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	data, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		data = tls.Client(data, c.tls)
+	}
+	return data, nil
+}
+
+// epsv parses "229 Entering Extended Passive Mode (|||port|)".
+func (c *controlConn) epsv() (int, error) {
+	reply, err := c.cmd(2, "EPSV")
+	if err != nil {
+		return 0, err
+	}
+	start, end := strings.Index(reply, "("), strings.LastIndex(reply, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("ftp: invalid EPSV reply %q", reply)
+	}
+	fields := strings.Split(reply[start+1:end], reply[start+1:start+2])
+	if len(fields) != 5 {
+		return 0, fmt.Errorf("ftp: invalid EPSV reply %q", reply)
+	}
+	return parsePort(fields[3], reply)
+}
+
+// pasv parses "227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)".
+func (c *controlConn) pasv() (int, error) {
+	reply, err := c.cmd(2, "PASV")
+	if err != nil {
+		return 0, err
+	}
+	start := strings.IndexAny(reply, "0123456789")
+	end := strings.LastIndexAny(reply, "0123456789")
+	if start < 0 {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", reply)
+	}
+	fields := strings.Split(reply[start:end+1], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", reply)
+	}
+	high, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", reply)
+	}
+	low, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", reply)
+	}
+	return parsePort(strconv.Itoa(high<<8|low), reply)
+}
+
+func parsePort(value string, reply string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("ftp: invalid port in reply %q", reply)
+	}
+	return port, nil
+}
+
+// quit logs out politely; the connection is closed either way.
+func (c *controlConn) quit() {
+	c.cmd(2, "QUIT")
+}
+
+func (c *controlConn) close() {
+	c.stop()
+	c.text.Close()
+}
+
+// transfer reads a file from its data connection. Once the server closes
+// it, the final reply tells whether the whole file was sent.
+type transfer struct {
+	conn *controlConn
+	data net.Conn
+	// remaining is how much more is wanted, or -1 for all of it.
+	remaining int64
+	done      bool
+}
+
+func (t *transfer) Read(p []byte) (int, error) {
+	if t.remaining == 0 {
+		return 0, io.EOF
+	}
+	if t.remaining > 0 && int64(len(p)) > t.remaining {
+		p = p[:t.remaining]
+	}
+	if t.conn.timeout > 0 {
+		t.data.SetReadDeadline(time.Now().Add(t.conn.timeout))
+	}
+	n, err := t.data.Read(p)
+	if t.remaining > 0 {
+		t.remaining -= int64(n)
+	}
+	if err == io.EOF {
+		t.data.Close()
+		if _, err := t.conn.read(2); err != nil {
+			return n, fmt.Errorf("ftp: transfer failed: %w", err)
+		}
+		t.done = true
+		if t.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// Close drops the connections; a transfer stopped early is abandoned
+// rather than aborted, which every server copes with.
+func (t *transfer) Close() error {
+	t.data.Close()
+	if t.done {
+		t.conn.quit()
+	}
+	t.conn.close()
+	return nil
+}
+
+// filePath returns the path of u to send to the server.
+func filePath(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// checkURL refuses URLs whose path or credentials hold line breaks or NUL,
+// such as a decoded %0d%0a, which would inject commands of their own into
+// the session.
+func checkURL(u *url.URL) error {
+	values := map[string]string{"path": filePath(u)}
+	if u.User != nil {
+		values["user name"] = u.User.Username()
+		values["password"], _ = u.User.Password()
+	}
+	for what, value := range values {
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("ftp: %s of %s contains a line break or NUL", what, u.Redacted())
+		}
+	}
+	return nil
+}
+
+// pathError turns a permanent failure for a file into fs.ErrNotExist, so
+// that it is reported as a 404.
+func pathError(op string, u *url.URL, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code == 550 {
+		return &fs.PathError{Op: op, Path: u.Redacted(), Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// notImplemented reports whether the server does not know a command.
+func notImplemented(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && (reply.Code == 500 || reply.Code == 502)
+}
+
+// parseModTime parses an MDTM reply, in UTC with optional fractions of a
+// second.
+func parseModTime(reply string) (time.Time, error) {
+	value, _, _ := strings.Cut(strings.TrimSpace(reply), ".")
+	return time.ParseInLocation(mdtmFormat, value, time.UTC)
+}
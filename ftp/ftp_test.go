@@ -0,0 +1,514 @@
+package ftp
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// testServer is a minimal FTP server with files in memory.
+type testServer struct {
+	t        *testing.T
+	listener net.Listener
+	files    map[string][]byte
+	modTime  time.Time
+	// user and password are required when set.
+	user, password string
+	// noEPSV, noREST and noMDTM leave out those commands.
+	noEPSV, noREST, noMDTM bool
+	// tls is set to serve FTPS; implicit speaks it from the start.
+	tls      *tls.Config
+	implicit bool
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newTestServer(t *testing.T, files map[string][]byte) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &testServer{t: t, listener: listener, files: files, modTime: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// start serves connections until the test ends.
+func (s *testServer) start() *testServer {
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url(scheme string, name string) string {
+	return fmt.Sprintf("%s://%s/%s", scheme, s.listener.Addr(), name)
+}
+
+// sent returns the commands received so far that start with prefix.
+func (s *testServer) sent(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []string
+	for _, command := range s.commands {
+		if strings.HasPrefix(command, prefix) {
+			matching = append(matching, command)
+		}
+	}
+	return matching
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	if s.tls != nil && s.implicit {
+		conn = tls.Server(conn, s.tls)
+	}
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var user string
+	var loggedIn, protected bool
+	var data net.Listener
+	var offset int64
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+
+	reply("220 test server ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		if !loggedIn && command != "USER" && command != "PASS" && command != "AUTH" && command != "PBSZ" && command != "PROT" && command != "QUIT" {
+			reply("530 not logged in")
+			continue
+		}
+		switch command {
+		case "AUTH":
+			if s.tls == nil || s.implicit {
+				reply("502 no TLS")
+				continue
+			}
+			reply("234 go ahead")
+			conn = tls.Server(conn, s.tls)
+			reader = bufio.NewReader(conn)
+		case "PBSZ":
+			reply("200 ok")
+		case "PROT":
+			protected = arg == "P"
+			reply("200 ok")
+		case "USER":
+			user = arg
+			reply("331 password please")
+		case "PASS":
+			if s.user != "" && (user != s.user || arg != s.password) {
+				reply("530 wrong password")
+				continue
+			}
+			loggedIn = true
+			reply("230 welcome")
+		case "TYPE":
+			reply("200 binary")
+		case "FEAT":
+			features := []string{"SIZE", "EPSV"}
+			if !s.noMDTM {
+				features = append(features, "MDTM")
+			}
+			if !s.noREST {
+				features = append(features, "REST STREAM")
+			}
+			reply("211-Features:\r\n %s\r\n211 End", strings.Join(features, "\r\n "))
+		case "SIZE":
+			if content, ok := s.files[arg]; ok {
+				reply("213 %d", len(content))
+			} else {
+				reply("550 no such file")
+			}
+		case "MDTM":
+			if _, ok := s.files[arg]; !ok || s.noMDTM {
+				reply("550 unavailable")
+			} else {
+				reply("213 %s", s.modTime.Format(mdtmFormat))
+			}
+		case "EPSV", "PASV":
+			if command == "EPSV" && s.noEPSV {
+				reply("500 unknown command")
+				continue
+			}
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot listen")
+				continue
+			}
+			port := data.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				// The reported address is deliberately wrong; clients
+				// should use the control connection's
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "REST":
+			if s.noREST {
+				reply("502 not implemented")
+				continue
+			}
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting at %d", offset)
+		case "RETR":
+			content, ok := s.files[arg]
+			if !ok || data == nil {
+				reply("550 no such file")
+				continue
+			}
+			reply("150 opening data connection")
+			dataConn, err := data.Accept()
+			data.Close()
+			data = nil
+			if err != nil {
+				reply("425 no data connection")
+				continue
+			}
+			if protected {
+				dataConn = tls.Server(dataConn, s.tls)
+			}
+			_, err = dataConn.Write(content[offset:])
+			dataConn.Close()
+			offset = 0
+			if err != nil {
+				reply("426 transfer aborted")
+			} else {
+				reply("226 transfer complete")
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// selfSigned returns a server config and a client config trusting it.
+func selfSigned(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: pool}
+}
+
+func mustParse(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	assert.NoError(t, err)
+	return u
+}
+
+func TestClient_Stat(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"pub/file.txt": []byte("0123456789")}).start()
+	client := &Client{Timeout: 5 * time.Second}
+
+	info, err := client.Stat(context.Background(), mustParse(t, server.url("ftp", "pub/file.txt")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), info.Size)
+	assert.True(t, info.ModTime.Equal(server.modTime))
+	assert.True(t, info.Ranges)
+	assert.Equal(t, []string{"USER anonymous"}, server.sent("USER"))
+
+	_, err = client.Stat(context.Background(), mustParse(t, server.url("ftp", "pub/missing.txt")))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestClient_StatWithoutRestOrMdtm(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("0123456789")})
+	server.noREST, server.noMDTM = true, true
+	server.start()
+
+	info, err := (&Client{}).Stat(context.Background(), mustParse(t, server.url("ftp", "file.txt")))
+	assert.NoError(t, err)
+	assert.False(t, info.Ranges)
+	assert.True(t, info.ModTime.IsZero())
+}
+
+func TestClient_Open(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("0123456789")}).start()
+	client := &Client{Timeout: 5 * time.Second}
+	u := mustParse(t, server.url("ftp", "file.txt"))
+
+	tests := []struct {
+		offset, length int64
+		expected       string
+	}{
+		{0, -1, "0123456789"},
+		{4, -1, "456789"},
+		{2, 3, "234"},
+	}
+	for _, test := range tests {
+		body, err := client.Open(context.Background(), u, test.offset, test.length)
+		assert.NoError(t, err)
+		got, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		assert.NoError(t, body.Close())
+		assert.Equal(t, test.expected, string(got))
+	}
+	assert.Equal(t, []string{"REST 4", "REST 2"}, server.sent("REST"))
+}
+
+func TestClient_PasvFallback(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("passive")})
+	server.noEPSV = true
+	server.start()
+
+	body, err := (&Client{}).Open(context.Background(), mustParse(t, server.url("ftp", "file.txt")), 0, -1)
+	assert.NoError(t, err)
+	got, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "passive", string(got))
+	assert.Len(t, server.sent("PASV"), 1)
+}
+
+func TestClient_Login(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("secret")})
+	server.user, server.password = "alice", "s3cret"
+	server.start()
+	client := &Client{}
+
+	_, err := client.Stat(context.Background(), mustParse(t, server.url("ftp", "file.txt")))
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	u := mustParse(t, server.url("ftp", "file.txt"))
+	u.User = url.UserPassword("alice", "s3cret")
+	info, err := client.Stat(context.Background(), u)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), info.Size)
+}
+
+func TestClient_RejectsLineBreaks(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("content")}).start()
+	client := &Client{}
+
+	for _, rawURL := range []string{
+		server.url("ftp", "file.txt%0d%0aDELE%20file.txt"),
+		server.url("ftp", "file.txt%0aDELE%20file.txt"),
+		server.url("ftp", "file.txt%00"),
+		strings.Replace(server.url("ftp", "file.txt"), "//", "//anonymous%0d%0aDELE%20file.txt:x@", 1),
+		strings.Replace(server.url("ftp", "file.txt"), "//", "//anonymous:x%0d%0aDELE%20file.txt@", 1),
+	} {
+		u := mustParse(t, rawURL)
+		_, err := client.Stat(context.Background(), u)
+		assert.ErrorContains(t, err, "line break", rawURL)
+		_, err = client.Open(context.Background(), u, 0, -1)
+		assert.ErrorContains(t, err, "line break", rawURL)
+	}
+	// Nothing was sent, let alone the injected command
+	assert.Empty(t, server.sent(""))
+}
+
+func TestClient_TLS(t *testing.T) {
+	serverConfig, clientConfig := selfSigned(t)
+	content := []byte("over tls")
+
+	for _, implicit := range []bool{true, false} {
+		server := newTestServer(t, map[string][]byte{"file.txt": content})
+		server.tls, server.implicit = serverConfig, implicit
+		server.start()
+		client := &Client{TLSConfig: clientConfig, ExplicitTLS: !implicit, Timeout: 5 * time.Second}
+
+		body, err := client.Open(context.Background(), mustParse(t, server.url("ftps", "file.txt")), 0, -1)
+		if !assert.NoError(t, err, "implicit %v", implicit) {
+			continue
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, content, got)
+		assert.Equal(t, []string{"PROT P"}, server.sent("PROT"))
+		if implicit {
+			assert.Empty(t, server.sent("AUTH"))
+		} else {
+			assert.Equal(t, []string{"AUTH TLS"}, server.sent("AUTH"))
+		}
+	}
+}
+
+func TestClient_TransferInterrupted(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	// The server sends part of the file and then reports a failure
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var data net.Listener
+		fmt.Fprint(conn, "220 ready\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command, _, _ := strings.Cut(strings.TrimSpace(line), " "); command {
+			case "EPSV":
+				data, _ = net.Listen("tcp", "127.0.0.1:0")
+				fmt.Fprintf(conn, "229 ok (|||%d|)\r\n", data.Addr().(*net.TCPAddr).Port)
+			case "RETR":
+				fmt.Fprint(conn, "150 here it comes\r\n")
+				dataConn, _ := data.Accept()
+				dataConn.Write([]byte("partial"))
+				dataConn.Close()
+				fmt.Fprint(conn, "426 connection lost\r\n")
+			case "USER":
+				fmt.Fprint(conn, "230 ok\r\n")
+			default:
+				fmt.Fprint(conn, "200 ok\r\n")
+			}
+		}
+	}()
+
+	body, err := (&Client{}).Open(context.Background(), mustParse(t, "ftp://"+listener.Addr().String()+"/file"), 0, -1)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(body)
+	body.Close()
+	assert.ErrorContains(t, err, "transfer failed")
+}
+
+func TestDownloader_FTP(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+
+	big := bytes.Repeat([]byte("0123456789"), 1<<20)
+	server := newTestServer(t, map[string][]byte{
+		"pub/big.bin":   big,
+		"pub/small.txt": []byte("small file"),
+	}).start()
+	client, err := clients.NewClientBuilder().Protocol(Scheme, &Client{Timeout: 5 * time.Second}).Build()
+	assert.NoError(t, err)
+
+	dl := downloader.New(client)
+	dl.Segments = 2
+	dl.SegmentThreshold = 1
+	dir := t.TempDir()
+	bars := dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{server.url("ftp", "pub/big.bin"), server.url("ftp", "pub/small.txt")}}, dir, 4, ctx)
+
+	assert.Len(t, bars, 2)
+	for _, bar := range bars {
+		assert.Equal(t, bar.Total(), bar.Current())
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+	got, err = os.ReadFile(filepath.Join(dir, "small.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "small file", string(got))
+	// The big file was fetched in ranges
+	assert.NotEmpty(t, server.sent("REST"))
+}
+
+func TestDownloader_FTPConflicts(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+
+	server := newTestServer(t, map[string][]byte{"file.txt": []byte("0123456789")}).start()
+	client, err := clients.NewClientBuilder().Protocol(Scheme, &Client{Timeout: 5 * time.Second}).Build()
+	assert.NoError(t, err)
+	provider := &clients.StaticURLProvider{URLs: []string{server.url("ftp", "file.txt")}}
+
+	tests := []struct {
+		policy   clients.ConflictPolicy
+		existing string
+		files    map[string]string
+		rest     bool
+	}{
+		{clients.ConflictSkip, "old", map[string]string{"file.txt": "old"}, false},
+		{clients.ConflictRename, "old", map[string]string{"file.txt": "old", "file.1.txt": "0123456789"}, false},
+		{clients.ConflictOverwrite, "old", map[string]string{"file.txt": "0123456789"}, false},
+		{clients.ConflictResume, "01234", map[string]string{"file.txt": "0123456789"}, true},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte(test.existing), 0644))
+		rests := len(server.sent("REST"))
+
+		dl := downloader.New(client)
+		dl.OnConflict = test.policy
+		dl.DownloadFiles(provider, dir, 1, ctx)
+
+		for name, expected := range test.files {
+			got, err := os.ReadFile(filepath.Join(dir, name))
+			assert.NoError(t, err, test.policy)
+			assert.Equal(t, expected, string(got), test.policy)
+		}
+		entries, _ := os.ReadDir(dir)
+		assert.Len(t, entries, len(test.files), test.policy)
+		assert.Equal(t, test.rest, len(server.sent("REST")) > rests, test.policy)
+	}
+}
+
+func TestDownloader_FTPMissingFile(t *testing.T) {
+	server := newTestServer(t, map[string][]byte{}).start()
+	// Registered on import
+	client, err := clients.NewClientBuilder().Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.url("ftp", "missing.txt"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFileNameLength is the longest name, in bytes, most file systems accept.
+const maxFileNameLength = 255
+
+// reservedNames cannot be used as file names on Windows, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName turns a name taken from a server into one that is safe
+// to create in the download directory. Path separators, control characters
+// and characters reserved on Windows become underscores, so the name can
+// never escape the directory. Leading and trailing dots and spaces are
+// removed, reserved device names are prefixed with an underscore and long
+// names are shortened, keeping their extension. It returns "" when nothing
+// usable is left.
+func SanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" || strings.Trim(name, "_") == "" {
+		return ""
+	}
+
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if reservedNames[base] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFileNameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxFileNameLength/2 {
+			ext = ""
+		}
+		stem := name[:maxFileNameLength-len(ext)]
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = stem + ext
+	}
+	return name
+}
+
+// SanitizeRelativePath turns a slash-separated path taken from a server,
+// such as an object key or the path of a listed file, into a relative
+// directory that stays within the download directory. Every segment is
+// sanitised like a file name, and empty, . and .. segments are dropped.
+func SanitizeRelativePath(p string) string {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "." || segment == ".." {
+			continue
+		}
+		if segment = SanitizeFileName(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return filepath.Join(segments...)
+}
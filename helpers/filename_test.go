@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"file.txt", "file.txt"},
+		{"résumé.pdf", "résumé.pdf"},
+		{"../../etc/passwd", "_.._etc_passwd"},
+		{`..\..\boot.ini`, `_.._boot.ini`},
+		{"a<b>c:d\"e|f?g*h.txt", "a_b_c_d_e_f_g_h.txt"},
+		{"tab\there.txt", "tab_here.txt"},
+		{"  .hidden. ", "hidden"},
+		{"..", ""},
+		{"///", ""},
+		{"", ""},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"console.txt", "console.txt"},
+		{"bad\xffutf8", "bad_utf8"},
+	}
+
+	for _, tt := range tests {
+		result := SanitizeFileName(tt.name)
+		if result != tt.expected {
+			t.Errorf("SanitizeFileName(%q) = %q; want %q", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestSanitizeFileName_Long(t *testing.T) {
+	result := SanitizeFileName(strings.Repeat("é", 200) + ".tar.gz")
+	if len(result) > 255 || !strings.HasSuffix(result, ".gz") || !strings.HasPrefix(result, "é") {
+		t.Errorf("SanitizeFileName shortened long name to %q (%d bytes)", result, len(result))
+	}
+}
+
+func TestSanitizeRelativePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"a/b/c", filepath.Join("a", "b", "c")},
+		{"/a//b/", filepath.Join("a", "b")},
+		{"../../etc", "etc"},
+		{"a/./../b", filepath.Join("a", "b")},
+		{"con/x:y", filepath.Join("_con", "x_y")},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeRelativePath(tt.path); got != tt.expected {
+			t.Errorf("SanitizeRelativePath(%q) = %q; want %q", tt.path, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package helpers
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MatchGlob reports whether name, a slash-separated relative path, matches
+// pattern. * matches any run of characters within a path segment, ** any
+// run including slashes, ? a single character other than a slash, and
+// [...] a character class as in path.Match, negated with [!...] or [^...]. Patterns without a slash are
+// matched against the last segment of name only, so *.iso matches
+// images/disk.iso.
+func MatchGlob(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	return matchGlob(pattern, name)
+}
+
+func matchGlob(pattern string, name string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			if strings.HasPrefix(pattern, "**") {
+				rest := strings.TrimLeft(pattern, "*")
+				// **/ also matches no directory at all
+				if strings.HasPrefix(rest, "/") && matchGlob(rest[1:], name) {
+					return true
+				}
+				for i := 0; i <= len(name); i++ {
+					if matchGlob(rest, name[i:]) {
+						return true
+					}
+				}
+				return false
+			}
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(rest, name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' {
+					return false
+				}
+			}
+			return false
+		case '?':
+			r, size := utf8.DecodeRuneInString(name)
+			if name == "" || r == '/' {
+				return false
+			}
+			pattern, name = pattern[1:], name[size:]
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 || name == "" {
+				return false
+			}
+			class := pattern[:end+2]
+			r, size := utf8.DecodeRuneInString(name)
+			// Shells negate classes with ! where path.Match uses ^
+			if matched, err := path.Match(strings.Replace(class, "[!", "[^", 1), string(r)); err != nil || !matched || r == '/' {
+				return false
+			}
+			pattern, name = pattern[len(class):], name[size:]
+		case '\\':
+			if len(pattern) < 2 || name == "" || name[0] != pattern[1] {
+				return false
+			}
+			pattern, name = pattern[2:], name[1:]
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+// GlobFilter selects paths by glob patterns, as matched by MatchGlob.
+type GlobFilter struct {
+	// Include, if not empty, keeps only paths matching one of its
+	// patterns.
+	Include []string
+	// Exclude drops paths matching any of its patterns, even included
+	// ones.
+	Exclude []string
+}
+
+// Match reports whether name passes the filter.
+func (f GlobFilter) Match(name string) bool {
+	for _, pattern := range f.Exclude {
+		if MatchGlob(pattern, name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
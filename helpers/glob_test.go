@@ -0,0 +1,55 @@
+package helpers
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"*.iso", "disk.iso", true},
+		{"*.iso", "images/2024/disk.iso", true},
+		{"*.iso", "disk.iso.sig", false},
+		{"images/*.iso", "images/disk.iso", true},
+		{"images/*.iso", "images/old/disk.iso", false},
+		{"images/**/*.iso", "images/disk.iso", true},
+		{"images/**/*.iso", "images/old/2023/disk.iso", true},
+		{"**/checksums/*", "a/b/checksums/SHA256SUMS", true},
+		{"**", "anything/at/all", true},
+		{"disk-?.img", "disk-1.img", true},
+		{"disk-?.img", "disk-10.img", false},
+		{"disk-[0-9].img", "disk-7.img", true},
+		{"disk-[!0-9].img", "disk-7.img", false},
+		{"data/\\*", "data/*", true},
+		{"data/\\*", "data/x", false},
+		{"v1.*/*", "v1.2/app.tar.gz", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.expected {
+			t.Errorf("MatchGlob(%q, %q) = %v; want %v", tt.pattern, tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestGlobFilter(t *testing.T) {
+	filter := GlobFilter{Include: []string{"*.iso", "*.img"}, Exclude: []string{"**/beta/**"}}
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"stable/disk.iso", true},
+		{"stable/disk.img", true},
+		{"stable/readme.txt", false},
+		{"x/beta/disk.iso", false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.name); got != tt.expected {
+			t.Errorf("Match(%q) = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+	if !(GlobFilter{}).Match("anything") {
+		t.Errorf("An empty filter should match everything")
+	}
+}
@@ -5,24 +5,48 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 )
 
-// GetFileNameFromURL Helper function to extract file name from URL
-func GetFileNameFromURL(url string) string {
-	return url[strings.LastIndex(url, "/")+1:]
+// GetFileNameFromURL Helper function to extract file name from URL. The query
+// string and fragment are ignored and the name is percent-decoded, so
+// "https://host/a%20b.txt?x=1" gives "a b.txt". A URL ending in a slash,
+// and a data: URL, gives "".
+func GetFileNameFromURL(rawURL string) string {
+	if hasScheme(rawURL, "data") {
+		return ""
+	}
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	name := rawURL[strings.LastIndex(rawURL, "/")+1:]
+	if decoded, err := url.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	return name
 }
 
-// IsValidURL Helper function to validate URLs
+// IsValidURL Helper function to validate URLs. Any scheme with a host is
+// accepted, so s3://bucket/key and ftp://host/path are valid too, as are
+// file:///path and data: URLs, which need none.
 func IsValidURL(testURL string) bool {
 	parsedURL, err := url.ParseRequestURI(testURL)
-	if err != nil {
+	if err != nil || parsedURL.Scheme == "" {
 		return false
 	}
-	if parsedURL.Scheme == "" || parsedURL.Host == "" {
-		return false
+	switch strings.ToLower(parsedURL.Scheme) {
+	case "data":
+		return parsedURL.Opaque != ""
+	case "file":
+		return parsedURL.Path != ""
 	}
-	return true
+	return parsedURL.Host != ""
+}
+
+// hasScheme reports whether rawURL starts with scheme and a colon.
+func hasScheme(rawURL string, scheme string) bool {
+	return len(rawURL) > len(scheme) && rawURL[len(scheme)] == ':' && strings.EqualFold(rawURL[:len(scheme)], scheme)
 }
 
 func ValidateDirectory(dir string) error {
@@ -41,3 +65,34 @@ func ValidateDirectory(dir string) error {
 
 	return nil
 }
+
+// ParseByteSize parses a size such as "512K", "5M" or "1.5G". Suffixes are
+// binary multiples, case insensitive, and may be followed by "B" or "iB".
+// A plain number is a count of bytes.
+func ParseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+
+	multiplier := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(number * multiplier), nil
+}
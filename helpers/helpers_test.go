@@ -15,6 +15,11 @@ func TestGetFileNameFromURL(t *testing.T) {
 		{"https://example.com/path/to/file.jpg", "file.jpg"},
 		{"https://example.com/", ""},
 		{"", ""},
+		{"https://example.com/download?id=7", "download"},
+		{"https://example.com/dir/?page=2", ""},
+		{"https://example.com/a%20b.txt#top", "a b.txt"},
+		{"https://example.com/50%.txt", "50%.txt"},
+		{"data:text/plain,a/b.txt", ""},
 	}
 
 	for _, tt := range tests {
@@ -33,6 +38,12 @@ func TestIsValidURL(t *testing.T) {
 		{"https://example.com/file.txt", true},
 		{"http://example.com", true},
 		{"ftp://example.com", true},
+		{"s3://bucket/dir/file.bin", true},
+		{"s3:///file.bin", false},
+		{"file:///tmp/file.txt", true},
+		{"file://", false},
+		{"data:text/plain;base64,SGVsbG8=", true},
+		{"data:", false},
 		{"example.com", false},
 		{"https:/example.com", false},
 		{"", false},
@@ -78,3 +89,31 @@ func TestValidateDirectory(t *testing.T) {
 		t.Error("Expected error for non-existent directory, got nil")
 	}
 }
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		valid    bool
+	}{
+		{"1024", 1024, true},
+		{"512K", 512 << 10, true},
+		{"5M", 5 << 20, true},
+		{"5m", 5 << 20, true},
+		{"5MB", 5 << 20, true},
+		{"5MiB", 5 << 20, true},
+		{"1.5G", 3 << 29, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"-1K", 0, false},
+		{"5X", 0, false},
+	}
+
+	for _, tt := range tests {
+		result, err := ParseByteSize(tt.value)
+		if (err == nil) != tt.valid || result != tt.expected {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d, valid %v", tt.value, result, err, tt.expected, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Package hostlimit keeps a download run polite towards every host it talks
+// to: it caps the number of concurrent requests per host, spaces requests
+// to the same host out, and pauses hosts that keep failing.
+package hostlimit
+
+import (
+	"GoDownload/clients"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the per-host limits. Zero values disable the corresponding
+// limit.
+type Config struct {
+	// MaxConnections is the most requests in flight to one host. A request
+	// counts until its response body is closed.
+	MaxConnections int
+	// Delay is the least time between the starts of two requests to the
+	// same host.
+	Delay time.Duration
+	// FailureThreshold is the number of consecutive failures after which
+	// requests to a host are paused for Cooldown. Once the pause is over a
+	// single request is let through to probe the host; the host is paused
+	// again if that fails too.
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// Client applies Config to the requests made through it. Connection
+// errors, 429 and 5xx responses count as failures. Hosts are forgotten once
+// nothing is left to remember about them, so long runs over many hosts do
+// not grow the client without bound.
+type Client struct {
+	client clients.HttpClient
+	config Config
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// Ensure Client implements clients.HttpClient
+var _ clients.HttpClient = &Client{}
+
+// host is the state of one host.
+type host struct {
+	active      int
+	next        time.Time
+	failures    int
+	pausedUntil time.Time
+	probing     bool
+	// changed is closed and replaced whenever a waiting request may be
+	// able to go ahead.
+	changed chan struct{}
+}
+
+// NewClient wraps client.
+func NewClient(client clients.HttpClient, config Config) *Client {
+	return &Client{client: client, config: config, hosts: map[string]*host{}}
+}
+
+func (c *Client) Get(url string) (*http.Response, error) {
+	return c.send(http.MethodGet, url)
+}
+
+func (c *Client) Head(url string) (*http.Response, error) {
+	return c.send(http.MethodHead, url)
+}
+
+func (c *Client) send(method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(context.Background(), req)
+}
+
+// Do waits until the host of req may be sent another request and sends it.
+func (c *Client) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	key := hostKey(req.URL)
+	h, probe, err := c.acquire(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(ctx, req)
+	if errors.Is(err, context.Canceled) {
+		c.abandon(h, probe)
+	} else {
+		c.record(h, probe, err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+	}
+	if err != nil {
+		c.release(h)
+		return nil, err
+	}
+
+	var once sync.Once
+	resp.Body = &body{ReadCloser: resp.Body, close: func() { once.Do(func() { c.release(h) }) }}
+	return resp, nil
+}
+
+// HostReady reports whether a request to the host of rawURL would be sent
+// right away.
+func (c *Client) HostReady(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, blocked := c.blocked(c.host(hostKey(parsed)), time.Now())
+	return !blocked
+}
+
+// WaitHost waits until a request to the host of rawURL would be sent right
+// away, without sending one. Callers can use it to hold off work for a busy
+// or paused host while other hosts keep going.
+func (c *Client) WaitHost(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	key := hostKey(parsed)
+	for {
+		c.mu.Lock()
+		h := c.host(key)
+		wait, blocked := c.blocked(h, time.Now())
+		changed := h.changed
+		c.mu.Unlock()
+		if !blocked {
+			return nil
+		}
+		if err := sleep(ctx, wait, changed); err != nil {
+			return err
+		}
+	}
+}
+
+// acquire waits for a slot at the host with the given key and for the
+// delay since the previous request to pass. It reports whether the request
+// is probing a paused host.
+func (c *Client) acquire(ctx context.Context, key string) (*host, bool, error) {
+	for {
+		c.mu.Lock()
+		h := c.host(key)
+		now := time.Now()
+		wait, blocked := c.blocked(h, now)
+		if !blocked {
+			h.active++
+			probe := c.tripped(h)
+			if probe {
+				h.probing = true
+			}
+
+			start := h.next
+			if start.Before(now) {
+				start = now
+			}
+			h.next = start.Add(c.config.Delay)
+			c.mu.Unlock()
+
+			if err := sleep(ctx, start.Sub(now), nil); err != nil {
+				c.abandon(h, probe)
+				c.release(h)
+				return nil, false, err
+			}
+			return h, probe, nil
+		}
+		changed := h.changed
+		c.mu.Unlock()
+
+		if err := sleep(ctx, wait, changed); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// blocked reports whether a request to h has to wait, and if so for at most
+// how long before checking again; zero means until h changes. The caller
+// holds c.mu.
+func (c *Client) blocked(h *host, now time.Time) (time.Duration, bool) {
+	switch {
+	case now.Before(h.pausedUntil):
+		return h.pausedUntil.Sub(now), true
+	case c.tripped(h) && h.probing:
+		return 0, true
+	case c.config.MaxConnections > 0 && h.active >= c.config.MaxConnections:
+		return 0, true
+	}
+	return 0, false
+}
+
+// tripped reports whether h failed often enough to be paused. The caller
+// holds c.mu.
+func (c *Client) tripped(h *host) bool {
+	return c.config.FailureThreshold > 0 && h.failures >= c.config.FailureThreshold
+}
+
+// record counts the outcome of a request to h, pausing it when it failed
+// too often in a row.
+func (c *Client) record(h *host, probe bool, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if probe {
+		h.probing = false
+	}
+	if !failed {
+		h.failures = 0
+		h.pausedUntil = time.Time{}
+	} else {
+		h.failures++
+		if c.tripped(h) {
+			h.pausedUntil = time.Now().Add(c.config.Cooldown)
+		}
+	}
+	notify(h)
+}
+
+// abandon undoes acquire's bookkeeping for a request that was cancelled
+// before it had an outcome.
+func (c *Client) abandon(h *host, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if probe {
+		h.probing = false
+	}
+	notify(h)
+}
+
+// release frees the slot a request held at h.
+func (c *Client) release(h *host) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h.active--
+	notify(h)
+}
+
+// host returns the state of the host with the given key, forgetting idle
+// hosts when a new one is added. The caller holds c.mu.
+func (c *Client) host(key string) *host {
+	h, ok := c.hosts[key]
+	if !ok {
+		c.forgetIdle(time.Now())
+		h = &host{changed: make(chan struct{})}
+		c.hosts[key] = h
+	}
+	return h
+}
+
+// forgetIdle removes the hosts without requests in flight, pending delay or
+// tripped circuit breaker, dropping any failures below the threshold along
+// with them. Nothing waits on such a host, so no waiter misses a notify.
+// The caller holds c.mu.
+func (c *Client) forgetIdle(now time.Time) {
+	for key, h := range c.hosts {
+		if h.active == 0 && !h.probing && !c.tripped(h) && !now.Before(h.next) && !now.Before(h.pausedUntil) {
+			delete(c.hosts, key)
+		}
+	}
+}
+
+// notify wakes up the requests waiting for h. The caller holds c.mu.
+func notify(h *host) {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// hostKey identifies the host of u, port included.
+func hostKey(u *url.URL) string {
+	return strings.ToLower(u.Host)
+}
+
+// sleep waits for d, or until changed is closed if d is zero, or until ctx
+// is cancelled.
+func sleep(ctx context.Context, d time.Duration, changed <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	} else if changed == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-timeout:
+	case <-changed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// body releases the host slot of its request when closed.
+type body struct {
+	io.ReadCloser
+	close func()
+}
+
+func (b *body) Close() error {
+	b.close()
+	return b.ReadCloser.Close()
+}
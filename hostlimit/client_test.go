@@ -0,0 +1,194 @@
+package hostlimit
+
+import (
+	"GoDownload/clients"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, client *Client, url string) int {
+	resp, err := client.Get(url)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestClient_MaxConnections(t *testing.T) {
+	var active, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, Config{MaxConnections: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(t, client, ts.URL)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), peak)
+}
+
+func TestClient_SlotHeldUntilBodyClosed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+	defer ts.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, Config{MaxConnections: 1})
+	resp, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.False(t, client.HostReady(ts.URL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.WaitHost(ctx, ts.URL), context.DeadlineExceeded)
+
+	resp.Body.Close()
+	assert.True(t, client.HostReady(ts.URL))
+	assert.NoError(t, client.WaitHost(context.Background(), ts.URL))
+}
+
+func TestClient_Delay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, Config{Delay: 50 * time.Millisecond})
+	started := time.Now()
+	get(t, client, ts.URL)
+	get(t, client, ts.URL)
+	get(t, client, ts.URL)
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+
+	// Other hosts are not held up
+	started = time.Now()
+	get(t, client, other.URL)
+	assert.Less(t, time.Since(started), 50*time.Millisecond)
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, Config{FailureThreshold: 2, Cooldown: 100 * time.Millisecond})
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, client, ts.URL))
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, client, ts.URL))
+
+	// The host is paused, others are not
+	started := time.Now()
+	assert.Equal(t, http.StatusOK, get(t, client, other.URL))
+	assert.Less(t, time.Since(started), 50*time.Millisecond)
+
+	// The probe after the pause fails, so the host is paused again
+	started = time.Now()
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, client, ts.URL))
+	assert.GreaterOrEqual(t, time.Since(started), 90*time.Millisecond)
+
+	failing.Store(false)
+	started = time.Now()
+	assert.Equal(t, http.StatusOK, get(t, client, ts.URL))
+	assert.GreaterOrEqual(t, time.Since(started), 90*time.Millisecond)
+
+	// A successful probe closes the breaker
+	started = time.Now()
+	assert.Equal(t, http.StatusOK, get(t, client, ts.URL))
+	assert.Less(t, time.Since(started), 50*time.Millisecond)
+}
+
+func TestClient_CancelledWhilePaused(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, Config{FailureThreshold: 1, Cooldown: time.Minute})
+	get(t, client, ts.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	_, err := client.Do(ctx, req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// stubClient answers every request with status.
+type stubClient struct {
+	status int
+}
+
+func (s *stubClient) Get(url string) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	return s.Do(context.Background(), req)
+}
+
+func (s *stubClient) Head(url string) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodHead, url, nil)
+	return s.Do(context.Background(), req)
+}
+
+func (s *stubClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: s.status, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestClient_ForgetsIdleHosts(t *testing.T) {
+	stub := &stubClient{status: http.StatusServiceUnavailable}
+	client := NewClient(stub, Config{FailureThreshold: 1, Cooldown: time.Hour})
+	get(t, client, "http://failing.example.com/")
+
+	stub.status = http.StatusOK
+	resp, err := client.Get("http://busy.example.com/")
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		get(t, client, fmt.Sprintf("http://host%d.example.com/", i))
+	}
+
+	// Only the paused host, the one with a request in flight and the
+	// latest one are left
+	client.mu.Lock()
+	hosts := len(client.hosts)
+	client.mu.Unlock()
+	assert.Equal(t, 3, hosts)
+	assert.False(t, client.HostReady("http://failing.example.com/"))
+
+	resp.Body.Close()
+	get(t, client, "http://another.example.com/")
+	client.mu.Lock()
+	_, busy := client.hosts["busy.example.com"]
+	client.mu.Unlock()
+	assert.False(t, busy)
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"GoDownload/auth"
+	"GoDownload/clients"
+	"GoDownload/crawl"
 	"GoDownload/downloader"
+	"GoDownload/helpers"
+	"GoDownload/ratelimit"
+	"GoDownload/s3"
 	"context"
+	"flag"
 	"go.uber.org/zap"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -13,14 +23,14 @@ import (
 )
 
 //func TestRunDownloader_HelpFlag(t *testing.T) {
-//	err := RunDownloader(true, 1, "./", nil)
+//	err := RunDownloader(Config{Help: true, Threads: 1, Dir: "./"}, nil, ctx)
 //	if err != nil {
 //		t.Fatalf("Expected no error with helpFlag, got %v", err)
 //	}
 //}
 //
 //func TestRunDownloader_NoURLs(t *testing.T) {
-//	err := RunDownloader(false, 1, "./", []string{})
+//	err := RunDownloader(Config{Threads: 1, Dir: "./", URLs: []string{}}, nil, ctx)
 //	if err == nil || err.Error() != "Please provide URLs to download using the -url flag." {
 //		t.Fatalf("Expected error for no URLs, got %v", err)
 //	}
@@ -50,7 +60,7 @@ func TestRunDownloader_ValidURLs(t *testing.T) {
 	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
 	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(1)
 
-	err := RunDownloader(false, 1, "./", []string{"https://example.com/file1.txt"}, mockFactory, 1, ctx)
+	err := RunDownloader(Config{Threads: 1, Dir: "./", URLs: []string{"https://example.com/file1.txt"}, Segments: 1}, mockFactory, ctx)
 	if err != nil {
 		t.Fatalf("Expected no error with valid URL, got %v", err)
 	}
@@ -69,7 +79,7 @@ func TestRunDownloader_MultipleValidURLs(t *testing.T) {
 	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(1)
 
 	urls := []string{"https://example.com/file1.txt", "https://example.com/file2.txt"}
-	err := RunDownloader(false, 2, "./", urls, mockFactory, 1, ctx)
+	err := RunDownloader(Config{Threads: 2, Dir: "./", URLs: urls, Segments: 1}, mockFactory, ctx)
 	if err != nil {
 		t.Fatalf("Expected no error with multiple valid URLs, got %v", err)
 	}
@@ -88,7 +98,7 @@ func TestRunDownloader_MoreThreadsThanURLs(t *testing.T) {
 	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(1)
 
 	urls := []string{"https://example.com/file1.txt"}
-	err := RunDownloader(false, 5, "./", urls, mockFactory, 1, ctx)
+	err := RunDownloader(Config{Threads: 5, Dir: "./", URLs: urls, Segments: 1}, mockFactory, ctx)
 	if err != nil {
 		t.Fatalf("Expected no error with more threads than URLs, got %v", err)
 	}
@@ -103,7 +113,7 @@ func TestRunDownloader_InvalidDirectory(t *testing.T) {
 	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
 
 	urls := []string{"https://example.com/file1.txt"}
-	err := RunDownloader(false, 1, "/invalid_directory/", urls, mockFactory, 1, ctx)
+	err := RunDownloader(Config{Threads: 1, Dir: "/invalid_directory/", URLs: urls, Segments: 1}, mockFactory, ctx)
 	if err == nil {
 		t.Fatalf("Expected an error with an invalid directory, got nil")
 	}
@@ -131,8 +141,300 @@ func TestRunDownloader_NoWritePermissions(t *testing.T) {
 	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
 
 	urls := []string{"https://example.com/file1.txt"}
-	err = RunDownloader(false, 1, tempDir, urls, mockFactory, 1, ctx)
+	err = RunDownloader(Config{Threads: 1, Dir: tempDir, URLs: urls, Segments: 1}, mockFactory, ctx)
 	if err == nil {
 		t.Fatalf("Expected an error due to no write permissions, got nil")
 	}
 }
+
+func TestRunDownloader_InputFile(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	inputFile := filepath.Join(t.TempDir(), "urls.txt")
+	ioutil.WriteFile(inputFile, []byte("https://example.com/file2.txt\n  out=renamed.txt\n"), 0644)
+
+	mockDownloader := downloader.NewMockDownloaderInterface(ctrl)
+	mockDownloader.EXPECT().DownloadStream(gomock.Any(), "./", 1, gomock.Any()).DoAndReturn(
+		func(provider clients.StreamingURLProvider, dir string, threads int, ctx context.Context) error {
+			var entries []clients.DownloadEntry
+			stream, errc := provider.StreamEntries(ctx)
+			for entry := range stream {
+				entries = append(entries, entry)
+			}
+			if err := <-errc; err != nil {
+				t.Fatalf("Expected no error reading entries, got %v", err)
+			}
+			if len(entries) != 2 || entries[0].URL != "https://example.com/file1.txt" || entries[1].Out != "renamed.txt" {
+				t.Fatalf("Expected -url and -input entries to be combined, got %+v", entries)
+			}
+			return nil
+		}).Times(1)
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(1)
+
+	cfg := Config{Threads: 1, Dir: "./", URLs: []string{"https://example.com/file1.txt"}, InputFile: inputFile, Segments: 1}
+	err := RunDownloader(cfg, mockFactory, ctx)
+	if err != nil {
+		t.Fatalf("Expected no error with an input file, got %v", err)
+	}
+}
+
+func TestRunDownloader_LimitRate(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownloader := downloader.NewMockDownloaderInterface(ctrl)
+	mockDownloader.EXPECT().DownloadFiles(gomock.Any(), "./", 1, gomock.Any()).Times(1)
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.AssignableToTypeOf(&ratelimit.Client{})).Return(mockDownloader).Times(1)
+
+	cfg := Config{Threads: 1, Dir: "./", URLs: []string{"https://example.com/file1.txt"}, Segments: 1, LimitRate: 5 << 20}
+	err := RunDownloader(cfg, mockFactory, ctx)
+	if err != nil {
+		t.Fatalf("Expected no error with a rate limit, got %v", err)
+	}
+}
+
+func TestRunDownloader_TooManyChecksums(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(downloader.NewMockDownloaderInterface(ctrl)).AnyTimes()
+
+	cfg := Config{Threads: 1, Dir: "./", URLs: []string{"https://example.com/file1.txt"}, Segments: 1,
+		Checksums: []string{"md5=b10a8db164e0754105b7a99be72e3fe5", "md5=b10a8db164e0754105b7a99be72e3fe5"}}
+	err := RunDownloader(cfg, mockFactory, ctx)
+	if err == nil {
+		t.Fatalf("Expected an error with more checksums than URLs, got nil")
+	}
+}
+
+func TestWithChecksums(t *testing.T) {
+	setupOnce.Do(setup)
+
+	sumsFile := filepath.Join(t.TempDir(), "SHA256SUMS")
+	ioutil.WriteFile(sumsFile, []byte("a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e  listed.txt\n"), 0644)
+
+	urls := []string{"https://example.com/flag.txt", "https://example.com/listed.txt", "https://example.com/other.txt"}
+	cfg := Config{URLs: urls, Checksums: []string{"md5=b10a8db164e0754105b7a99be72e3fe5"}, SumsURL: sumsFile}
+	provider, err := withChecksums(ctx, &clients.RealHttpClient{}, &clients.StaticURLProvider{URLs: urls}, cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	entries, _ := clients.Entries(provider)
+	expected := []string{
+		"md5=b10a8db164e0754105b7a99be72e3fe5",
+		"sha-256=a591a6d40bf420404a011733cfb7b190d62c65bf0bcda32b57b277d9ad9f146e",
+		"",
+	}
+	for i, entry := range entries {
+		if entry.Checksum != expected[i] {
+			t.Errorf("entry %d: got checksum %q, want %q", i, entry.Checksum, expected[i])
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "godownload.conf")
+	ioutil.WriteFile(path, []byte(`# Settings
+threads = 4
+dir = /from/config
+url = https://example.com/a
+url = https://example.com/b
+
+http2 = false
+`), 0644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	threads := fs.Int("threads", 8, "")
+	dir := fs.String("dir", "./", "")
+	http2 := fs.Bool("http2", true, "")
+	var urls multiFlag
+	fs.Var(&urls, "url", "")
+	fs.String("config", "", "")
+	if err := fs.Parse([]string{"-dir", "/from/flags"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfigFile(fs, path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if *threads != 4 || *dir != "/from/flags" || *http2 {
+		t.Errorf("got threads=%d dir=%s http2=%v, want 4, /from/flags and false", *threads, *dir, *http2)
+	}
+	if len(urls) != 2 {
+		t.Errorf("got URLs %v, want both from the config file", urls)
+	}
+
+	ioutil.WriteFile(path, []byte("unknown = 1\n"), 0644)
+	if err := loadConfigFile(fs, path); err == nil {
+		t.Errorf("Expected an error for an unknown setting")
+	}
+	ioutil.WriteFile(path, []byte("threads 4\n"), 0644)
+	if err := loadConfigFile(fs, path); err == nil {
+		t.Errorf("Expected an error for a line without =")
+	}
+}
+
+func TestParseProxyFlags(t *testing.T) {
+	config, err := parseProxyFlags("", "", nil)
+	if err != nil || config != nil {
+		t.Fatalf("Expected no proxy settings without flags, got %v, %v", config, err)
+	}
+
+	config, err = parseProxyFlags("http://proxy:3128", "localhost,.internal", []string{"*.example.com=socks5://socks:1080", "files.example.com=direct"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Default != "http://proxy:3128" || len(config.NoProxy) != 2 || config.Hosts["*.example.com"] != "socks5://socks:1080" || config.Hosts["files.example.com"] != "direct" {
+		t.Errorf("got %+v", config)
+	}
+
+	if _, err := parseProxyFlags("", "", []string{"no-equals-sign"}); err == nil {
+		t.Errorf("Expected an error for a -host-proxy without =")
+	}
+}
+
+func TestAuthProvider(t *testing.T) {
+	hosts, err := parseCredentials([]string{"Files.example.com=alice:secret", "api.example.com=bearer:token"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if hosts["files.example.com"].Password != "secret" || hosts["api.example.com"].Token != "token" {
+		t.Errorf("got %+v", hosts)
+	}
+	if _, err := parseCredentials([]string{"alice:secret"}); err == nil {
+		t.Errorf("Expected an error for -auth without a host")
+	}
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	ioutil.WriteFile(netrc, []byte("machine mirror.example.org login bob password hunter2\n"), 0600)
+	oauth2 := &auth.ClientCredentials{TokenURL: "https://login.example.com/token", Hosts: []string{"store.example.com"}}
+	chain, err := authProvider(Config{Credentials: hosts, OAuth2: oauth2, Netrc: netrc, CredentialHelper: "git credential-store"}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(chain) != 4 || chain[1] != oauth2 || oauth2.Client != http.DefaultClient {
+		t.Errorf("got providers %v, want explicit credentials, OAuth2, netrc and helper", chain)
+	}
+
+	if _, err := authProvider(Config{Netrc: netrc + ".missing"}, http.DefaultClient); err == nil {
+		t.Errorf("Expected an error for a missing -netrc-file")
+	}
+}
+
+func TestRunDownloader_S3List(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownloader := downloader.NewMockDownloaderInterface(ctrl)
+	mockDownloader.EXPECT().DownloadStream(gomock.AssignableToTypeOf(&s3.ListProvider{}), "./", 4, gomock.Any()).Times(1)
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(2)
+
+	cfg := Config{Threads: 4, Dir: "./", Segments: 1, S3Lists: []string{"s3://bucket/releases/"}}
+	err := RunDownloader(cfg, mockFactory, ctx)
+	if err != nil {
+		t.Fatalf("Expected no error listing a bucket, got %v", err)
+	}
+
+	cfg.S3Lists = []string{"https://bucket/releases/"}
+	if err := RunDownloader(cfg, mockFactory, ctx); err == nil {
+		t.Errorf("Expected an error for a listing that is not an s3:// URL")
+	}
+}
+
+func TestRunDownloader_Mirror(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var provider *crawl.IndexProvider
+	mockDownloader := downloader.NewMockDownloaderInterface(ctrl)
+	mockDownloader.EXPECT().DownloadStream(gomock.AssignableToTypeOf(&crawl.IndexProvider{}), "./", 4, gomock.Any()).
+		Do(func(p clients.StreamingURLProvider, dir string, threads int, ctx context.Context) {
+			provider = p.(*crawl.IndexProvider)
+		})
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(2)
+
+	cfg := Config{Threads: 4, Dir: "./", Segments: 1, Mirrors: []string{"https://example.com/pub"}, MirrorDepth: 3}
+	if err := RunDownloader(cfg, mockFactory, ctx); err != nil {
+		t.Fatalf("Expected no error mirroring a listing, got %v", err)
+	}
+	if provider == nil || provider.Root.String() != "https://example.com/pub/" || provider.MaxDepth != 3 {
+		t.Errorf("Expected a provider for https://example.com/pub/ with depth 3, got %+v", provider)
+	}
+
+	cfg.Mirrors = []string{"not a url"}
+	if err := RunDownloader(cfg, mockFactory, ctx); err == nil {
+		t.Errorf("Expected an error for an invalid listing URL")
+	}
+}
+
+func TestRunDownloader_Spider(t *testing.T) {
+	setupOnce.Do(setup)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var spider *crawl.Spider
+	mockDownloader := downloader.NewMockDownloaderInterface(ctrl)
+	mockDownloader.EXPECT().DownloadStream(gomock.AssignableToTypeOf(&crawl.Spider{}), "./", 4, gomock.Any()).
+		Do(func(p clients.StreamingURLProvider, dir string, threads int, ctx context.Context) {
+			spider = p.(*crawl.Spider)
+		})
+
+	mockFactory := downloader.NewMockDownloaderFactory(ctrl)
+	mockFactory.EXPECT().NewDownloader(gomock.Any()).Return(mockDownloader).Times(2)
+
+	cfg := Config{
+		Threads: 4, Dir: "./", Segments: 1,
+		Spiders: []string{"https://Example.com/data/#top", "https://example.com/more"},
+		Spider:  crawl.Spider{Extensions: []string{"csv"}, MaxDepth: 2, UserAgent: "MyBot"},
+		Filter:  helpers.GlobFilter{Exclude: []string{"*.tmp"}},
+	}
+	if err := RunDownloader(cfg, mockFactory, ctx); err != nil {
+		t.Fatalf("Expected no error crawling pages, got %v", err)
+	}
+	if spider == nil || len(spider.Seeds) != 2 || spider.Seeds[0].String() != "https://example.com/data/" || spider.Client == nil {
+		t.Fatalf("Expected a spider starting at both pages, got %+v", spider)
+	}
+	if spider.MaxDepth != 2 || spider.UserAgent != "MyBot" || len(spider.Extensions) != 1 || len(spider.Filter.Exclude) != 1 {
+		t.Errorf("Expected the spider to be set up from the config, got %+v", spider)
+	}
+
+	cfg.Spiders = []string{"mailto:someone@example.com"}
+	if err := RunDownloader(cfg, mockFactory, ctx); err == nil {
+		t.Errorf("Expected an error for a page that is not an http URL")
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	for middleware, want := range map[string]string{
+		"":                                  "",
+		"log":                               "",
+		"user-agent=MyBot/1.0 (+https://x)": "MyBot/1.0 (+https://x)",
+		"user-agent=Harvester":              "Harvester",
+	} {
+		if got := userAgent(strings.Split(middleware, ",")); got != want {
+			t.Errorf("userAgent(%q) = %q, want %q", middleware, got, want)
+		}
+	}
+}
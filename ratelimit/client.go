@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"GoDownload/clients"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Read sizes are kept between these bounds so that concurrent readers take
+// turns often enough for the limit to be shared fairly between them.
+const (
+	minChunkSize = 512
+	maxChunkSize = 32 * 1024
+)
+
+// Client limits the rate at which response bodies are read. Every body
+// counts towards the global limiter, towards a limiter shared by all bodies
+// of the same URL, so that the segments of one file are limited together,
+// and towards a limiter shared by all bodies from the same host.
+type Client struct {
+	client  clients.HttpClient
+	global  *Limiter
+	perFile *limiterGroup
+	perHost *limiterGroup
+}
+
+// Ensure Client implements clients.HttpClient
+var _ clients.HttpClient = &Client{}
+
+// NewClient wraps client. global may be nil or shared with other clients;
+// perFile and perHost are in bytes per second, zero meaning unlimited.
+func NewClient(client clients.HttpClient, global *Limiter, perFile int64, perHost int64) *Client {
+	if global == nil {
+		global = NewLimiter(0)
+	}
+	return &Client{
+		client:  client,
+		global:  global,
+		perFile: newLimiterGroup(perFile),
+		perHost: newLimiterGroup(perHost),
+	}
+}
+
+// Global returns the limiter shared by every body read through the client.
+func (c *Client) Global() *Limiter {
+	return c.global
+}
+
+// SetPerFileRate changes the per-file limit, including for downloads in
+// progress.
+func (c *Client) SetPerFileRate(bytesPerSecond int64) {
+	c.perFile.SetRate(bytesPerSecond)
+}
+
+// SetPerHostRate changes the per-host limit, including for downloads in
+// progress.
+func (c *Client) SetPerHostRate(bytesPerSecond int64) {
+	c.perHost.SetRate(bytesPerSecond)
+}
+
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(context.Background(), req)
+}
+
+func (c *Client) Head(url string) (*http.Response, error) {
+	return c.client.Head(url)
+}
+
+func (c *Client) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(ctx, req)
+	if err != nil || resp.Body == nil || req.Method == http.MethodHead {
+		return resp, err
+	}
+
+	fileKey, hostKey := req.URL.String(), req.URL.Host
+	resp.Body = &reader{
+		ctx:      ctx,
+		body:     resp.Body,
+		limiters: []*Limiter{c.global, c.perFile.Acquire(fileKey), c.perHost.Acquire(hostKey)},
+		release: func() {
+			c.perFile.Release(fileKey)
+			c.perHost.Release(hostKey)
+		},
+	}
+	return resp, nil
+}
+
+// reader waits for its limiters after every read from body.
+type reader struct {
+	ctx      context.Context
+	body     io.ReadCloser
+	limiters []*Limiter
+	once     sync.Once
+	release  func()
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if chunk := r.chunkSize(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.body.Read(p)
+	for _, limiter := range r.limiters {
+		if waitErr := limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	r.once.Do(r.release)
+	return r.body.Close()
+}
+
+// chunkSize reads about a tenth of a second worth of data at the lowest of
+// the limits.
+func (r *reader) chunkSize() int {
+	chunk := maxChunkSize
+	for _, limiter := range r.limiters {
+		if rate := limiter.Rate(); rate > 0 && int(rate/10) < chunk {
+			chunk = int(rate / 10)
+		}
+	}
+	if chunk < minChunkSize {
+		chunk = minChunkSize
+	}
+	return chunk
+}
+
+// limiterGroup hands out one limiter per key, shared by everyone holding
+// the key and dropped once the last of them releases it.
+type limiterGroup struct {
+	mu       sync.Mutex
+	rate     int64
+	limiters map[string]*groupLimiter
+}
+
+type groupLimiter struct {
+	limiter *Limiter
+	refs    int
+}
+
+func newLimiterGroup(bytesPerSecond int64) *limiterGroup {
+	return &limiterGroup{rate: bytesPerSecond, limiters: map[string]*groupLimiter{}}
+}
+
+// Acquire returns the limiter for key, creating it if needed.
+func (g *limiterGroup) Acquire(key string) *Limiter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, ok := g.limiters[key]
+	if !ok {
+		entry = &groupLimiter{limiter: NewLimiter(g.rate)}
+		g.limiters[key] = entry
+	}
+	entry.refs++
+	return entry.limiter
+}
+
+// Release gives back a limiter obtained from Acquire.
+func (g *limiterGroup) Release(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry, ok := g.limiters[key]
+	if !ok {
+		return
+	}
+	entry.refs--
+	if entry.refs <= 0 {
+		delete(g.limiters, key)
+	}
+}
+
+// SetRate changes the rate of the group's current and future limiters.
+func (g *limiterGroup) SetRate(bytesPerSecond int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rate = bytesPerSecond
+	for _, entry := range g.limiters {
+		entry.limiter.SetRate(bytesPerSecond)
+	}
+}
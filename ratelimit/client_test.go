@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"GoDownload/clients"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newBodyServer(size int) *httptest.Server {
+	body := strings.Repeat("x", size)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+}
+
+func download(t *testing.T, client clients.HttpClient, url string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := client.Do(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+}
+
+func TestClient_GlobalLimit(t *testing.T) {
+	ts := newBodyServer(20000)
+	defer ts.Close()
+
+	global := NewLimiter(20000)
+	global.WaitN(context.Background(), 20000) // drain the initial burst
+	client := NewClient(&clients.RealHttpClient{}, global, 0, 0)
+
+	// Two downloads of 20KB share 20KB/s
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			download(t, client, ts.URL)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 1800*time.Millisecond)
+	assert.Less(t, elapsed, 3*time.Second)
+}
+
+func TestClient_PerFileLimit(t *testing.T) {
+	ts := newBodyServer(15000)
+	defer ts.Close()
+
+	client := NewClient(&clients.RealHttpClient{}, nil, 10000, 0)
+
+	// Different files are limited separately: both finish after the 10KB
+	// burst plus half a second
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, path := range []string{"/a", "/b"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			download(t, client, ts.URL+path)
+		}(path)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+
+	// Limiters are dropped once their last body is closed
+	assert.Empty(t, client.perFile.limiters)
+	assert.Empty(t, client.perHost.limiters)
+}
+
+func TestClient_SetPerHostRate(t *testing.T) {
+	client := NewClient(&clients.RealHttpClient{}, nil, 0, 1000)
+
+	limiter := client.perHost.Acquire("example.com")
+	client.SetPerHostRate(5000)
+	assert.Equal(t, int64(5000), limiter.Rate())
+	assert.Equal(t, int64(5000), client.perHost.Acquire("other.example.com").Rate())
+}
+
+func TestWatchRateFile(t *testing.T) {
+	watchInterval = 10 * time.Millisecond
+	logger, _ := zap.NewDevelopment()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "sugar", logger.Sugar()))
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "rate")
+	os.WriteFile(path, []byte("5M\n"), 0644)
+
+	limiter := NewLimiter(0)
+	assert.NoError(t, WatchRateFile(ctx, path, limiter))
+	assert.Equal(t, int64(5<<20), limiter.Rate())
+
+	os.WriteFile(path, []byte("512K"), 0644)
+	assert.Eventually(t, func() bool { return limiter.Rate() == 512<<10 }, time.Second, 10*time.Millisecond)
+
+	// Invalid contents leave the limit alone
+	os.WriteFile(path, []byte("fast"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(512<<10), limiter.Rate())
+
+	assert.Error(t, WatchRateFile(ctx, filepath.Join(t.TempDir(), "missing"), limiter))
+}
@@ -0,0 +1,120 @@
+// Package ratelimit caps the bandwidth used by downloads with token buckets
+// that can be shared between any number of goroutines.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket holding one token per byte. It refills at its
+// rate and holds at most one second worth of tokens. Bytes are granted in
+// the order they are asked for, so concurrent readers sharing a limiter get
+// an equal share of it. A Limiter with a rate of zero or less is unlimited.
+// The rate can be changed at any time, including while readers are waiting.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	tokens  float64
+	last    time.Time
+	changed chan struct{}
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond bytes per second.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	return &Limiter{
+		rate:    float64(bytesPerSecond),
+		tokens:  float64(bytesPerSecond),
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// Rate returns the current limit in bytes per second, or zero if unlimited.
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// SetRate changes the limit. Readers already waiting are woken up and wait
+// again according to the new rate.
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	l.rate = float64(bytesPerSecond)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done. Bytes are
+// reserved up front, so a reader asking for more than is available waits
+// for the deficit while readers behind it wait for theirs on top.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		l.advance(now)
+		l.tokens -= float64(n)
+		var wait time.Duration
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			l.refund(n)
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+			l.refund(n)
+		}
+	}
+}
+
+// advance adds the tokens accumulated since the last call. The caller must
+// hold l.mu.
+func (l *Limiter) advance(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+}
+
+// refund gives back n bytes that were reserved but not used.
+func (l *Limiter) refund(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+	if l.rate > 0 && l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Unlimited(t *testing.T) {
+	limiter := NewLimiter(0)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, limiter.WaitN(context.Background(), 1<<20))
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, int64(0), limiter.Rate())
+}
+
+func TestLimiter_WaitsForDeficit(t *testing.T) {
+	limiter := NewLimiter(10000)
+
+	// The bucket starts full, so the first second worth is immediate
+	start := time.Now()
+	assert.NoError(t, limiter.WaitN(context.Background(), 10000))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	assert.NoError(t, limiter.WaitN(context.Background(), 2000))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+}
+
+func TestLimiter_SharedFairly(t *testing.T) {
+	limiter := NewLimiter(100000)
+	limiter.WaitN(context.Background(), 100000) // drain the initial burst
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	counts := make([]int, 3)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for limiter.WaitN(ctx, 1000) == nil {
+				counts[i]++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := counts[0] + counts[1] + counts[2]
+	assert.InDelta(t, 30, total, 8, "total throughput should follow the rate")
+	for _, count := range counts {
+		assert.InDelta(t, total/3, count, 3, "readers should get an equal share: %v", counts)
+	}
+}
+
+func TestLimiter_SetRateWakesWaiters(t *testing.T) {
+	limiter := NewLimiter(1)
+	limiter.WaitN(context.Background(), 1)
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.WaitN(context.Background(), 1<<20)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	limiter.SetRate(0)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not released when the limit was lifted")
+	}
+}
+
+func TestLimiter_CancelledWait(t *testing.T) {
+	limiter := NewLimiter(1)
+	limiter.WaitN(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := limiter.WaitN(ctx, 1000)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"GoDownload/helpers"
+	"context"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often WatchRateFile checks its file for changes.
+var watchInterval = time.Second
+
+// WatchRateFile sets the rate of limiter from the size written in path,
+// such as "5M", and keeps it in sync whenever the file changes until ctx is
+// done. An empty file or "0" lifts the limit. This lets the limit of a long
+// running session be changed without restarting it.
+func WatchRateFile(ctx context.Context, path string, limiter *Limiter) error {
+	sugar, ok := ctx.Value("sugar").(*zap.SugaredLogger)
+	if !ok {
+		panic("error getting logger")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := loadRateFile(path, limiter); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		modTime, size := info.ModTime(), info.Size()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+				continue
+			}
+			modTime, size = info.ModTime(), info.Size()
+			if err := loadRateFile(path, limiter); err != nil {
+				sugar.Errorw("Ignoring rate limit file", "path", path, "error", err)
+				continue
+			}
+			sugar.Infow("Rate limit changed", "bytesPerSecond", limiter.Rate())
+		}
+	}()
+	return nil
+}
+
+func loadRateFile(path string, limiter *Limiter) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		value = "0"
+	}
+	rate, err := helpers.ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	limiter.SetRate(rate)
+	return nil
+}
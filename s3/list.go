@@ -0,0 +1,158 @@
+package s3
+
+import (
+	"GoDownload/clients"
+	"GoDownload/helpers"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ListProvider lists the objects under a prefix of a bucket with
+// ListObjectsV2, one page at a time, and yields an entry per object that
+// passes Filter. Entries keep the key hierarchy below the prefix as their
+// directory, so a whole tree is recreated under the download directory.
+type ListProvider struct {
+	// Client sends the list requests. They are resolved and signed with
+	// Config.
+	Client clients.HttpClient
+	Config Config
+	// Bucket and Prefix select the objects. Keys are recreated relative to
+	// the last slash of Prefix.
+	Bucket string
+	Prefix string
+	// Filter selects objects by their key relative to the prefix.
+	Filter helpers.GlobFilter
+	// PageSize is the number of keys asked for per request. Zero leaves
+	// it to the server, which returns up to 1000.
+	PageSize int
+}
+
+// NewListProvider lists the objects under location, given as
+// s3://bucket/prefix.
+func NewListProvider(client clients.HttpClient, config Config, location string) (*ListProvider, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != Scheme || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", location)
+	}
+	return &ListProvider{
+		Client: client,
+		Config: config,
+		Bucket: u.Host,
+		Prefix: strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+// listBucketResult is one page of a ListObjectsV2 response.
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (p *ListProvider) GetEntries() ([]clients.DownloadEntry, error) {
+	var entries []clients.DownloadEntry
+	err := p.list(context.Background(), func(entry clients.DownloadEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// StreamEntries yields the objects while later pages are still being
+// listed.
+func (p *ListProvider) StreamEntries(ctx context.Context) (<-chan clients.DownloadEntry, <-chan error) {
+	return clients.ProduceEntries(ctx, func(emit func(clients.DownloadEntry) error) error {
+		return p.list(ctx, emit)
+	})
+}
+
+func (p *ListProvider) GetURLs() ([]string, error) {
+	entries, err := p.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return urls, nil
+}
+
+// list pages through the listing and calls emit for every selected object.
+func (p *ListProvider) list(ctx context.Context, emit func(clients.DownloadEntry) error) error {
+	client := clients.Use(p.Client, Middleware(p.Config))
+	base := p.Prefix[:strings.LastIndex(p.Prefix, "/")+1]
+
+	token := ""
+	for {
+		page, err := p.page(ctx, client, token)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			// Keys ending in a slash are folder markers
+			if strings.HasSuffix(object.Key, "/") || !strings.HasPrefix(object.Key, base) {
+				continue
+			}
+			relative := strings.TrimPrefix(object.Key, base)
+			if !p.Filter.Match(relative) {
+				continue
+			}
+			entry := clients.DownloadEntry{
+				URL: (&url.URL{Scheme: Scheme, Host: p.Bucket, Path: "/" + object.Key}).String(),
+				Dir: helpers.SanitizeRelativePath(path.Dir(relative)),
+				Out: helpers.SanitizeFileName(path.Base(relative)),
+			}
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// page requests one page of the listing.
+func (p *ListProvider) page(ctx context.Context, client clients.HttpClient, token string) (*listBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {p.Prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if p.PageSize > 0 {
+		query.Set("max-keys", fmt.Sprint(p.PageSize))
+	}
+	listURL := &url.URL{Scheme: Scheme, Host: p.Bucket, Path: "/", RawQuery: query.Encode()}
+	req, err := http.NewRequest(http.MethodGet, listURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("listing s3://%s/%s: %w", p.Bucket, p.Prefix, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("listing s3://%s/%s: %s %s", p.Bucket, p.Prefix, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var page listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("listing s3://%s/%s: %w", p.Bucket, p.Prefix, err)
+	}
+	return &page, nil
+}
@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"GoDownload/helpers"
+	"context"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListProvider_PagesAndFilters(t *testing.T) {
+	credentials := Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+	store := newStandIn(t, credentials, map[string][]byte{
+		"/releases/v1/app.tar.gz":          []byte("app"),
+		"/releases/v1/app.tar.gz.sig":      []byte("sig"),
+		"/releases/v1/docs/":               nil,
+		"/releases/v1/docs/guide.pdf":      []byte("guide"),
+		"/releases/v1/beta/app.tar.gz":     []byte("beta"),
+		"/releases/v1/linux/x64/app.bin":   []byte("bin"),
+		"/releases/v2/app.tar.gz":          []byte("v2"),
+		"/releases/v1-old/app.tar.gz":      []byte("old"),
+		"/other-bucket/v1/app.tar.gz":      []byte("other"),
+		"/releases/v1/../../escape.tar.gz": []byte("escape"),
+	})
+	config := Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &credentials}
+
+	provider, err := NewListProvider(&clients.RealHttpClient{}, config, "s3://releases/v1/")
+	if !assert.NoError(t, err) {
+		return
+	}
+	provider.PageSize = 2
+	provider.Filter = helpers.GlobFilter{Exclude: []string{"*.sig", "beta/**"}}
+
+	entries, err := provider.GetEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, []clients.DownloadEntry{
+		{URL: "s3://releases/v1/../../escape.tar.gz", Dir: "", Out: "escape.tar.gz"},
+		{URL: "s3://releases/v1/app.tar.gz", Dir: "", Out: "app.tar.gz"},
+		{URL: "s3://releases/v1/docs/guide.pdf", Dir: "docs", Out: "guide.pdf"},
+		{URL: "s3://releases/v1/linux/x64/app.bin", Dir: filepath.Join("linux", "x64"), Out: "app.bin"},
+	}, entries)
+	assert.Equal(t, 4, store.pages)
+
+	provider.Filter = helpers.GlobFilter{Include: []string{"**/*.bin"}}
+	urls, err := provider.GetURLs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s3://releases/v1/linux/x64/app.bin"}, urls)
+}
+
+func TestListProvider_Errors(t *testing.T) {
+	_, err := NewListProvider(&clients.RealHttpClient{}, Config{}, "https://releases/v1/")
+	assert.Error(t, err)
+
+	store := newStandIn(t, Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}, nil)
+	config := Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &Credentials{AccessKeyID: "minio", SecretAccessKey: "wrong"}}
+	provider, _ := NewListProvider(&clients.RealHttpClient{}, config, "s3://releases/")
+	_, err = provider.GetEntries()
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestListProvider_RecreatesTree(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+
+	credentials := Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+	store := newStandIn(t, credentials, map[string][]byte{
+		"/data/sets/2024/jan.csv": []byte("jan"),
+		"/data/sets/2024/feb.csv": []byte("feb"),
+		"/data/sets/readme.md":    []byte("readme"),
+	})
+	config := Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &credentials}
+	provider, _ := NewListProvider(&clients.RealHttpClient{}, config, "s3://data/sets/")
+
+	dl := downloader.New(&clients.RealHttpClient{})
+	dl.Middleware = []clients.Middleware{Middleware(config)}
+	dir := t.TempDir()
+	assert.NoError(t, dl.DownloadStream(provider, dir, 2, ctx))
+
+	for name, want := range map[string]string{"2024/jan.csv": "jan", "2024/feb.csv": "feb", "readme.md": "readme"} {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+}
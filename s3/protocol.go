@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"GoDownload/clients"
+	"context"
+	"io"
+	"net/url"
+)
+
+// Protocol reads s3:// URLs from the store described by Config. Importing
+// this package registers one configured from the environment, see
+// ConfigFromEnv.
+type Protocol struct {
+	// Client sends the requests. It defaults to the shared default
+	// client.
+	Client clients.HttpClient
+	Config Config
+}
+
+var _ clients.Protocol = (*Protocol)(nil)
+
+func init() {
+	clients.RegisterProtocol(Scheme, &Protocol{Config: ConfigFromEnv()})
+}
+
+// http returns a protocol sending signed requests for resolved URLs.
+func (p *Protocol) http() *clients.HTTPProtocol {
+	client := p.Client
+	if client == nil {
+		client = &clients.RealHttpClient{}
+	}
+	return &clients.HTTPProtocol{Client: clients.Use(client, Middleware(p.Config))}
+}
+
+func (p *Protocol) Stat(ctx context.Context, u *url.URL) (*clients.FileInfo, error) {
+	return p.http().Stat(ctx, u)
+}
+
+func (p *Protocol) Open(ctx context.Context, u *url.URL, offset int64, length int64) (io.ReadCloser, error) {
+	return p.http().Open(ctx, u, offset, length)
+}
@@ -0,0 +1,43 @@
+package s3
+
+import (
+	"GoDownload/clients"
+	"context"
+	"io"
+	"io/fs"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocol(t *testing.T) {
+	credentials := Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+	store := newStandIn(t, credentials, map[string][]byte{"/releases/file.txt": []byte("0123456789")})
+	protocol := &Protocol{Config: Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &credentials}}
+	ctx := context.Background()
+	u, _ := url.Parse("s3://releases/file.txt")
+
+	info, err := protocol.Stat(ctx, u)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(10), info.Size)
+	assert.True(t, info.Ranges)
+
+	body, err := protocol.Open(ctx, u, 2, 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "23456", string(content))
+	assert.Equal(t, 1, store.ranged)
+
+	missing, _ := url.Parse("s3://releases/missing.txt")
+	_, err = protocol.Stat(ctx, missing)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, ok := clients.LookupProtocol(Scheme)
+	assert.True(t, ok)
+}
@@ -0,0 +1,155 @@
+// Package s3 downloads from S3 and S3-compatible object stores such as
+// MinIO: it resolves s3://bucket/key URLs to the endpoint and signs the
+// requests to it with AWS Signature Version 4.
+package s3
+
+import (
+	"GoDownload/clients"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scheme is the URL scheme of objects, as in s3://bucket/key.
+const Scheme = "s3"
+
+// DefaultRegion is used when no region is configured.
+const DefaultRegion = "us-east-1"
+
+// Config says where buckets live and how to authenticate to them.
+type Config struct {
+	// Endpoint is the base URL of the object store, such as
+	// http://localhost:9000. It defaults to AWS S3 in Region.
+	Endpoint string
+	// Region is the region requests are signed for. It defaults to
+	// us-east-1.
+	Region string
+	// PathStyle puts the bucket in the path, as in endpoint/bucket/key,
+	// rather than in the host name. Most S3-compatible stores need it.
+	PathStyle bool
+	// Credentials sign requests. Nil sends them unsigned, which only
+	// works for public buckets.
+	Credentials *Credentials
+}
+
+// ConfigFromEnv reads the standard AWS environment variables:
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION
+// or AWS_DEFAULT_REGION, and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL.
+func ConfigFromEnv() Config {
+	var config Config
+	config.Region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	config.Endpoint = firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		config.Credentials = &Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+	}
+	return config
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (c *Config) region() string {
+	if c.Region == "" {
+		return DefaultRegion
+	}
+	return c.Region
+}
+
+// endpoint returns the parsed endpoint URL.
+func (c *Config) endpoint() (*url.URL, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.region())
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return parsed, nil
+}
+
+// ResolveURL turns s3://bucket/key into the HTTP URL of the object. Other
+// URLs are returned as they are.
+func (c *Config) ResolveURL(u *url.URL) (*url.URL, error) {
+	if u.Scheme != Scheme {
+		return u, nil
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q: missing bucket", u.String())
+	}
+	endpoint, err := c.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	resolved := *endpoint
+	resolved.RawQuery = u.RawQuery
+	basePath := strings.TrimSuffix(endpoint.Path, "/")
+	if c.PathStyle {
+		resolved.Path = basePath + "/" + u.Host + u.Path
+	} else {
+		resolved.Host = u.Host + "." + endpoint.Host
+		resolved.Path = basePath + u.Path
+	}
+	if resolved.Path == "" {
+		resolved.Path = "/"
+	}
+	resolved.RawPath = ""
+	return &resolved, nil
+}
+
+// signs reports whether requests to u are signed: those going to the
+// endpoint or, for virtual-hosted buckets, to a host under it.
+func (c *Config) signs(u *url.URL) bool {
+	if c.Credentials == nil {
+		return false
+	}
+	endpoint, err := c.endpoint()
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	endpointHost := strings.ToLower(endpoint.Host)
+	return host == endpointHost || strings.HasSuffix(host, "."+endpointHost)
+}
+
+// Middleware returns middleware resolving s3:// URLs and signing requests
+// to the object store, including ranged segment requests and HEADs.
+// Requests that are already authorized or presigned are sent as they are.
+func Middleware(config Config) clients.Middleware {
+	signer := &Signer{Region: config.region()}
+	if config.Credentials != nil {
+		signer.Credentials = *config.Credentials
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return clients.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Scheme == Scheme {
+				resolved, err := config.ResolveURL(req.URL)
+				if err != nil {
+					return nil, err
+				}
+				req = req.Clone(req.Context())
+				req.URL, req.Host = resolved, ""
+			}
+			if !config.signs(req.URL) || req.Header.Get("Authorization") != "" || req.URL.Query().Has("X-Amz-Signature") {
+				return next.RoundTrip(req)
+			}
+
+			hash, err := payloadHash(req)
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			signer.Sign(req, hash, time.Now())
+			return next.RoundTrip(req)
+		})
+	}
+}
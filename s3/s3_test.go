@@ -0,0 +1,227 @@
+package s3
+
+import (
+	"GoDownload/clients"
+	"GoDownload/downloader"
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// standIn is a local S3-compatible server holding path-style objects. It
+// checks the signature of every request against credentials.
+type standIn struct {
+	*httptest.Server
+	credentials Credentials
+	objects     map[string][]byte
+
+	mu     sync.Mutex
+	ranged int
+	pages  int
+}
+
+func newStandIn(t *testing.T, credentials Credentials, objects map[string][]byte) *standIn {
+	s := &standIn{credentials: credentials, objects: objects}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) serve(w http.ResponseWriter, r *http.Request) {
+	if !s.verify(r) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("list-type") == "2" {
+		s.list(w, r)
+		return
+	}
+	content, ok := s.objects[r.URL.Path]
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Range") != "" {
+		s.mu.Lock()
+		s.ranged++
+		s.mu.Unlock()
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// list answers ListObjectsV2 requests for path-style buckets, in pages of
+// max-keys keys. The continuation token is the last key of the page.
+func (s *standIn) list(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+	pageSize, err := strconv.Atoi(query.Get("max-keys"))
+	if err != nil {
+		pageSize = 1000
+	}
+
+	var keys []string
+	for name := range s.objects {
+		key, ok := strings.CutPrefix(name, "/"+bucket+"/")
+		if ok && strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	s.mu.Lock()
+	s.pages++
+	s.mu.Unlock()
+	truncated := len(keys) > pageSize
+	if truncated {
+		keys = keys[:pageSize]
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><IsTruncated>%v</IsTruncated>`, bucket, truncated)
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", html.EscapeString(key), len(s.objects["/"+bucket+"/"+key]))
+	}
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", html.EscapeString(keys[len(keys)-1]))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// verify signs a copy of r with the headers it claims to have signed and
+// compares the signatures.
+func (s *standIn) verify(r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	_, signedHeaders, found := strings.Cut(authorization, "SignedHeaders=")
+	if !found {
+		return false
+	}
+	signedHeaders, _, _ = strings.Cut(signedHeaders, ",")
+	signedAt, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	copied, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name != "host" {
+			copied.Header.Set(name, r.Header.Get(name))
+		}
+	}
+	(&Signer{Credentials: s.credentials, Region: "eu-central-1"}).Sign(copied, r.Header.Get("X-Amz-Content-Sha256"), signedAt)
+	return copied.Header.Get("Authorization") == authorization
+}
+
+func TestConfig_ResolveURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		url    string
+		want   string
+	}{
+		{"aws", Config{Region: "eu-west-1"}, "s3://bucket/dir/file.bin", "https://bucket.s3.eu-west-1.amazonaws.com/dir/file.bin"},
+		{"default region", Config{}, "s3://bucket/file.bin", "https://bucket.s3.us-east-1.amazonaws.com/file.bin"},
+		{"path style", Config{Endpoint: "http://localhost:9000", PathStyle: true}, "s3://bucket/my file.bin", "http://localhost:9000/bucket/my%20file.bin"},
+		{"endpoint path", Config{Endpoint: "https://store.example.com/s3/", PathStyle: true}, "s3://bucket/a?versionId=2", "https://store.example.com/s3/bucket/a?versionId=2"},
+		{"not s3", Config{}, "https://example.com/a", "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		got, err := tt.config.ResolveURL(u)
+		if assert.NoError(t, err, tt.name) {
+			assert.Equal(t, tt.want, got.String(), tt.name)
+		}
+	}
+
+	_, err := (&Config{Endpoint: "localhost:9000"}).ResolveURL(&url.URL{Scheme: Scheme, Host: "bucket", Path: "/a"})
+	assert.Error(t, err)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "ap-south-1")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "http://minio:9000")
+
+	config := ConfigFromEnv()
+	assert.Equal(t, Config{
+		Endpoint:    "http://minio:9000",
+		Region:      "ap-south-1",
+		Credentials: &Credentials{AccessKeyID: "id", SecretAccessKey: "secret"},
+	}, config)
+}
+
+func TestMiddleware_SignsOnlyTheEndpoint(t *testing.T) {
+	var sent []*http.Request
+	next := clients.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	rt := Middleware(Config{Endpoint: "http://minio:9000", PathStyle: true, Credentials: &exampleCredentials})(next)
+
+	for _, target := range []string{"s3://bucket/key", "http://minio:9000/bucket/key", "http://elsewhere/key", "http://minio:9000/bucket/key?X-Amz-Signature=abc"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		rt.RoundTrip(req)
+	}
+	assert.Equal(t, "http://minio:9000/bucket/key", sent[0].URL.String())
+	assert.NotEmpty(t, sent[0].Header.Get("Authorization"))
+	assert.NotEmpty(t, sent[1].Header.Get("Authorization"))
+	assert.Empty(t, sent[2].Header.Get("Authorization"))
+	assert.Empty(t, sent[3].Header.Get("Authorization"))
+}
+
+func TestDownloadFiles_SignedSegments(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+
+	credentials := Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+	content := bytes.Repeat([]byte("0123456789"), 1<<20)
+	store := newStandIn(t, credentials, map[string][]byte{"/releases/v1/big.bin": content, "/releases/small.txt": []byte("small")})
+
+	config := Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &credentials}
+	dl := downloader.New(&clients.RealHttpClient{})
+	dl.Middleware = []clients.Middleware{Middleware(config)}
+	dl.Segments = 2
+	dl.SegmentThreshold = 1
+	dir := t.TempDir()
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{"s3://releases/v1/big.bin", "s3://releases/small.txt"}}, dir, 4, ctx)
+
+	got, err := os.ReadFile(filepath.Join(dir, "big.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	got, err = os.ReadFile(filepath.Join(dir, "small.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(got))
+	assert.Equal(t, 2, store.ranged, "ranged requests")
+}
+
+func TestDownloadFiles_RejectsWrongKey(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), "sugar", logger.Sugar())
+	store := newStandIn(t, Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}, map[string][]byte{"/releases/a.txt": []byte("a")})
+
+	config := Config{Endpoint: store.URL, Region: "eu-central-1", PathStyle: true, Credentials: &Credentials{AccessKeyID: "minio", SecretAccessKey: "wrong"}}
+	var results []downloader.Result
+	dl := downloader.New(&clients.RealHttpClient{})
+	dl.Middleware = []clients.Middleware{Middleware(config)}
+	dl.OnResult = func(result downloader.Result) { results = append(results, result) }
+	dl.DownloadFiles(&clients.StaticURLProvider{URLs: []string{"s3://releases/a.txt"}}, t.TempDir(), 1, ctx)
+
+	if assert.Len(t, results, 1) {
+		assert.Error(t, results[0].Err)
+	}
+}